@@ -0,0 +1,100 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type throttleKey struct{}
+
+// 在ctx中记录请求遇到的限流和服务端错误次数，重试后成功的请求也会计入
+func withThrottleCounter(ctx context.Context) (context.Context, *int32) {
+	counter := new(int32)
+	return context.WithValue(ctx, throttleKey{}, counter), counter
+}
+
+// 请求遇到限流或服务端错误时计数，ctx中没有计数器时忽略
+func noteThrottled(ctx context.Context, err error) {
+	if counter, ok := ctx.Value(throttleKey{}).(*int32); ok && IsOneauthThrottled(err) {
+		atomic.AddInt32(counter, 1)
+	}
+}
+
+// 人员任务的并发数控制，同时执行的任务数不超过limit
+// 开启自动调整时，任务遇到限流或服务端错误后limit减半，耗时正常的任务累计达到limit个后limit加1
+type adaptiveLimit struct {
+	lock sync.Mutex
+	cond *sync.Cond
+
+	limit    int
+	min, max int
+	active   int // 正在执行的任务数
+
+	adaptive bool
+	latency  time.Duration // 耗时不超过该值的任务视为正常
+	healthy  int           // 上次调整后耗时正常的任务数
+}
+
+// 创建并发数控制，不开启自动调整时固定为max
+func newAdaptiveLimit(max int, config AdaptiveInfo) *adaptiveLimit {
+	l := &adaptiveLimit{limit: max, min: max, max: max}
+	if config.Enable == true {
+		l.adaptive = true
+		l.min = config.Min
+		l.latency = config.LatencyDuration
+		if l.min < 1 || l.min > max {
+			l.min = 1
+		}
+	}
+	l.cond = sync.NewCond(&l.lock)
+	return l
+}
+
+// 等待可以开始执行任务
+func (l *adaptiveLimit) acquire() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for l.active >= l.limit {
+		l.cond.Wait()
+	}
+	l.active++
+}
+
+// 任务执行完成，根据耗时和是否遇到限流调整并发数，返回调整后的并发数和本次是否调整
+func (l *adaptiveLimit) release(elapsed time.Duration, throttled bool, err error) (int, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.active--
+	old := l.limit
+	if l.adaptive == true {
+		switch {
+		case throttled || IsOneauthThrottled(err):
+			l.limit /= 2
+			if l.limit < l.min {
+				l.limit = l.min
+			}
+			l.healthy = 0
+
+		case err == nil && elapsed <= l.latency:
+			l.healthy++
+			if l.healthy >= l.limit && l.limit < l.max {
+				l.limit++
+				l.healthy = 0
+			}
+		}
+	}
+
+	l.cond.Broadcast()
+	return l.limit, l.limit != old
+}
+
+// 当前并发数
+func (l *adaptiveLimit) current() int {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.limit
+}
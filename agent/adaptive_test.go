@@ -0,0 +1,88 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 限流时并发数减半，不低于最小值；耗时正常的任务累计达到当前并发数后加1，不超过最大值
+func TestAdaptiveLimit(t *testing.T) {
+	limit := newAdaptiveLimit(8, AdaptiveInfo{Enable: true, Min: 2, LatencyDuration: 100 * time.Millisecond})
+	throttled := &OneauthError{StatusCode: http.StatusTooManyRequests}
+
+	steps := []struct {
+		elapsed   time.Duration
+		throttled bool
+		err       error
+		want      int
+	}{
+		{10 * time.Millisecond, false, throttled, 4},
+		{10 * time.Millisecond, true, nil, 2},
+		{10 * time.Millisecond, true, nil, 2},
+		// 慢任务和普通错误不调整
+		{time.Second, false, nil, 2},
+		{10 * time.Millisecond, false, &OneauthError{StatusCode: http.StatusBadRequest}, 2},
+		{10 * time.Millisecond, false, nil, 2},
+		{10 * time.Millisecond, false, nil, 3},
+		{10 * time.Millisecond, false, &OneauthError{StatusCode: http.StatusBadGateway}, 2},
+	}
+	for i, step := range steps {
+		limit.acquire()
+		if got, _ := limit.release(step.elapsed, step.throttled, step.err); got != step.want {
+			t.Fatalf("step %d: limit %d, want %d", i, got, step.want)
+		}
+	}
+
+	for i := 0; i < 100; i++ {
+		limit.acquire()
+		limit.release(time.Millisecond, false, nil)
+	}
+	if limit.current() != 8 {
+		t.Fatal("limit should ramp up to max, got: ", limit.current())
+	}
+
+	// 重试成功的请求也记录限流
+	ctx, counter := withThrottleCounter(context.Background())
+	noteThrottled(ctx, throttled)
+	noteThrottled(ctx, &OneauthError{StatusCode: http.StatusNotFound})
+	noteThrottled(context.Background(), throttled)
+	if *counter != 1 {
+		t.Fatal("only throttled requests should be counted, got: ", *counter)
+	}
+
+	fixed := newAdaptiveLimit(4, AdaptiveInfo{})
+	fixed.acquire()
+	if got, changed := fixed.release(0, true, throttled); got != 4 || changed {
+		t.Fatal("limit without adaptive should not change, got: ", got)
+	}
+}
+
+// 开启自动调整后限流的请求重试成功，同步结果不受影响，记录任务耗时和当前并发数
+func TestAdaptiveUserTasks(t *testing.T) {
+	env := newE2EEnv(t)
+	env.config.System.Adaptive.Enable = true
+	env.config.System.Adaptive.LatencyDuration = time.Second
+	env.datapub.SetOrgs(baseOrgs())
+	env.datapub.SetEmps(baseEmps())
+	env.oneauth.FailNext(http.MethodPost, "/api/v1/account/user", http.StatusTooManyRequests, 2)
+	env.start()
+	env.sync()
+	env.assertTree(baseTree)
+	env.assertUsers(baseUsers)
+
+	w := httptest.NewRecorder()
+	MetricsHandler(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`oneauth_agent_task_duration_seconds_count{job="default",kind="user",action="create"}`,
+		`oneauth_agent_task_duration_seconds_count{job="default",kind="org",action="create"}`,
+		`oneauth_agent_user_task_concurrency{job="default"}`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Fatal("metrics should contain ", want)
+		}
+	}
+}
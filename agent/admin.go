@@ -0,0 +1,212 @@
+package agent
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 校验管理接口的bearer token，必须带Bearer前缀；未配置token时拒绝所有请求
+func (m *Manager) adminAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		expected := string(m.Config.System.Admin.Token)
+		header := r.Header.Get("Authorization")
+		token := strings.TrimPrefix(header, "Bearer ")
+		if len(expected) == 0 || token == header ||
+			subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+			writeAdminJson(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+
+		next(w, r)
+	}
+}
+
+func writeAdminJson(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Warn("[admin] write response error: ", err)
+	}
+}
+
+// 根据job参数查找任务，只有一个任务时可以不带参数，找不到时已写入错误响应
+func (m *Manager) adminJob(w http.ResponseWriter, r *http.Request) *Syncer {
+	name := r.URL.Query().Get("job")
+	if len(name) == 0 {
+		if len(m.Syncers) == 1 {
+			return m.Syncers[0]
+		}
+		writeAdminJson(w, http.StatusBadRequest, map[string]string{"error": "job must be set"})
+		return nil
+	}
+
+	syncer := m.Job(name)
+	if syncer == nil {
+		writeAdminJson(w, http.StatusNotFound, map[string]string{"error": "job " + name + " not found"})
+	}
+	return syncer
+}
+
+// 单个任务的同步状态
+func adminJobStatus(s *Syncer) map[string]interface{} {
+	status, successTime, ok := s.GetSyncStatus()
+	rsp := map[string]interface{}{"running": status.Running}
+	if ok {
+		rsp["lastRun"] = status
+	}
+	if !successTime.IsZero() {
+		rsp["lastSuccessTime"] = successTime
+	}
+	return rsp
+}
+
+// GET /status 查看最近一次同步的状态，带job参数或只有一个任务时返回该任务的状态，否则按任务名返回所有任务的状态
+func (m *Manager) adminStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdminJson(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	if len(r.URL.Query().Get("job")) > 0 || len(m.Syncers) == 1 {
+		if s := m.adminJob(w, r); s != nil {
+			writeAdminJson(w, http.StatusOK, adminJobStatus(s))
+		}
+		return
+	}
+
+	jobs := make(map[string]interface{}, len(m.Syncers))
+	for _, s := range m.Syncers {
+		jobs[s.Name()] = adminJobStatus(s)
+	}
+	writeAdminJson(w, http.StatusOK, map[string]interface{}{"jobs": jobs})
+}
+
+// POST /sync?job=name 立即执行一次同步，该任务已有同步在执行时返回409
+func (m *Manager) adminSync(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAdminJson(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	s := m.adminJob(w, r)
+	if s == nil {
+		return
+	}
+
+	if !s.lock.TryLock() {
+		writeAdminJson(w, http.StatusConflict, map[string]string{"error": ErrSyncRunning.Error()})
+		return
+	}
+
+	if s.Stopping() {
+		s.lock.Unlock()
+		writeAdminJson(w, http.StatusServiceUnavailable, map[string]string{"error": ErrShuttingDown.Error()})
+		return
+	}
+
+	s.log.Info("[admin] sync triggered by ", r.RemoteAddr)
+	go func() {
+		defer s.lock.Unlock()
+		s.runSync(context.Background(), "api")
+	}()
+
+	writeAdminJson(w, http.StatusAccepted, map[string]string{"result": "sync started"})
+}
+
+// POST /cancel?job=name 取消正在执行的同步，已完成的任务会被记录，下次启动时重新读取oneauth数据
+func (m *Manager) adminCancel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeAdminJson(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	s := m.adminJob(w, r)
+	if s == nil {
+		return
+	}
+
+	if !s.Cancel() {
+		writeAdminJson(w, http.StatusConflict, map[string]string{"error": "no sync is running"})
+		return
+	}
+
+	s.log.Info("[admin] sync canceled by ", r.RemoteAddr)
+	writeAdminJson(w, http.StatusAccepted, map[string]string{"result": "sync canceled"})
+}
+
+// GET /plan?job=name 生成当前数据的同步计划，不修改oneauth数据，format=text时返回文本格式
+func (m *Manager) adminPlan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdminJson(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+
+	s := m.adminJob(w, r)
+	if s == nil {
+		return
+	}
+
+	plan, safetyErr, err := s.Plan(r.Context())
+	if err == ErrSyncRunning {
+		writeAdminJson(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeAdminJson(w, http.StatusBadGateway, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if r.URL.Query().Get("format") == "text" {
+		text := plan.Text()
+		if safetyErr != nil {
+			text += "\nWARNING: " + safetyErr.Error() + "\n"
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(text))
+		return
+	}
+
+	rsp := map[string]interface{}{"plan": plan}
+	if safetyErr != nil {
+		rsp["safety"] = safetyErr.Error()
+	}
+	writeAdminJson(w, http.StatusOK, rsp)
+}
+
+// 本地管理接口的处理器，可以挂载到调用方自己的http服务中
+func (m *Manager) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", m.adminAuth(m.adminStatus))
+	mux.HandleFunc("/sync", m.adminAuth(m.adminSync))
+	mux.HandleFunc("/cancel", m.adminAuth(m.adminCancel))
+	mux.HandleFunc("/plan", m.adminAuth(m.adminPlan))
+	// 指标只读，供prometheus抓取，不需要能触发同步的token
+	mux.HandleFunc("/metrics", MetricsHandler)
+	return mux
+}
+
+// 按配置启动本地管理接口服务，未配置监听地址时不启动
+func (m *Manager) StartAdmin() {
+	if len(m.Config.System.Admin.Listen) == 0 {
+		return
+	}
+
+	server := &http.Server{
+		Addr:              m.Config.System.Admin.Listen,
+		Handler:           m.AdminHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		log.Info("[admin] listen on ", server.Addr)
+		if err := server.ListenAndServe(); err != nil {
+			log.Error("[admin] listen error: ", err)
+		}
+	}()
+}
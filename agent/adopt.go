@@ -0,0 +1,203 @@
+package agent
+
+import (
+	"context"
+	"sync"
+)
+
+// 上一次同步在oneauth创建成功后、记录id之前中止时，再次创建会返回对象已存在
+// 此时按外部编码查找oneauth中已有的对象，使用它的id，并把名字、上级等不一致的属性更新为本次的数据
+// 没有找到已有的对象时返回创建时的错误conflict
+
+// 创建冲突时读取的oneauth已有对象，每次同步每类列表最多读取一次，多个冲突共用
+// 每个已有对象最多被一个任务使用，之后列表中的属性不再准确也不影响查找
+type adoptCache struct {
+	lock  sync.Mutex
+	roots []RootInfo
+	deps  map[string][]OrgInfo // key为根节点id
+	users []MemInfo
+
+	rootsLoaded, usersLoaded bool
+}
+
+// 清空已读取的列表，每次同步结束时调用
+func (c *adoptCache) reset() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.roots, c.deps, c.users = nil, nil, nil
+	c.rootsLoaded, c.usersLoaded = false, false
+}
+
+// oneauth中已有的根节点，读取失败时不缓存，下一次冲突时重新读取
+func (s *Syncer) existingRoots(ctx context.Context) ([]RootInfo, error) {
+	c := &s.adoptCache
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.rootsLoaded {
+		roots, err := s.Target.ListRoots(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c.roots, c.rootsLoaded = roots, true
+	}
+	return c.roots, nil
+}
+
+// oneauth中根节点下已有的部门
+func (s *Syncer) existingDepartments(ctx context.Context, orgId string) ([]OrgInfo, error) {
+	c := &s.adoptCache
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if deps, ok := c.deps[orgId]; ok {
+		return deps, nil
+	}
+	deps, err := s.Target.ListDepartments(ctx, orgId)
+	if err != nil {
+		return nil, err
+	}
+	if c.deps == nil {
+		c.deps = make(map[string][]OrgInfo)
+	}
+	c.deps[orgId] = deps
+	return deps, nil
+}
+
+// oneauth中已有的人员
+func (s *Syncer) existingUsers(ctx context.Context) ([]MemInfo, error) {
+	c := &s.adoptCache
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if !c.usersLoaded {
+		users, err := s.Target.ListUsers(ctx)
+		if err != nil {
+			return nil, err
+		}
+		c.users, c.usersLoaded = users, true
+	}
+	return c.users, nil
+}
+
+// 根节点已存在时，按外部编码查找根节点id
+func (s *Syncer) adoptRoot(ctx context.Context, node *DataOrgMemNode, conflict error) (string, error) {
+	roots, err := s.existingRoots(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	for _, root := range roots {
+		if root.OriginId == node.NodeCode {
+			s.log.Info("[oneauth] adopt existing root [", node.NodeCode, ", ", node.NodeName, "]: ", root.OrgId)
+			return root.OrgId, nil
+		}
+	}
+	return "", conflict
+}
+
+// 部门已存在时，在同一个根节点下按外部编码查找部门id，名字或上级部门不一致时更新
+func (s *Syncer) adoptDepartment(ctx context.Context, node *DataOrgMemNode, conflict error) (string, error) {
+	deps, err := s.existingDepartments(ctx, node.OrgId)
+	if err != nil {
+		return "", err
+	}
+
+	var existing *OrgInfo
+	for i := range deps {
+		if deps[i].OriginId == node.NodeCode {
+			existing = &deps[i]
+			break
+		}
+	}
+	if existing == nil {
+		return "", conflict
+	}
+
+	s.log.Info("[oneauth] adopt existing org [", node.NodeCode, ", ", node.NodeName, "]: ", existing.DepId)
+
+	// 顶层部门的上级为根节点
+	fatherId := node.FatherId
+	if len(fatherId) == 0 {
+		fatherId = node.OrgId
+	}
+
+	update := 0
+	if existing.Name != node.NodeName {
+		update |= 1 << 1
+	}
+	if existing.ParentId != fatherId {
+		update |= 1 << 2
+	}
+	if update == 0 {
+		return existing.DepId, nil
+	}
+
+	// 更新失败时按创建失败处理，下次同步时重新查找并更新
+	node.DepId = existing.DepId
+	node.FatherId = fatherId
+	node.Action |= update
+	err = s.Target.UpdateDepartment(ctx, node)
+	node.Action &^= update
+	node.DepId = ""
+	if err != nil {
+		return "", err
+	}
+	return existing.DepId, nil
+}
+
+// 人员已存在时，按工号查找人员，工号为空的人员按账号查找，属性或部门不一致时更新
+func (s *Syncer) adoptUser(ctx context.Context, user *DataApiEmpNode, conflict error) (string, error) {
+	users, err := s.existingUsers(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	var existing *MemInfo
+	for i := range users {
+		if users[i].EmployeeId == user.UserCode {
+			existing = &users[i]
+			break
+		}
+	}
+	if existing == nil {
+		// 账号属于其他工号的人员时不能使用
+		for i := range users {
+			if users[i].Account == user.OAID && len(users[i].EmployeeId) == 0 {
+				existing = &users[i]
+				break
+			}
+		}
+	}
+	if existing == nil {
+		return "", conflict
+	}
+
+	s.log.Info("[oneauth] adopt existing user [", user.UserCode, ", ", user.UserName, "]: ", existing.UserId)
+
+	var orgId, depId string
+	if len(existing.Department) > 0 {
+		orgId = existing.Department[0].OrgId
+		if len(existing.Department[0].DepId) > 0 {
+			depId = existing.Department[0].DepId[0]
+		}
+	}
+
+	// 更新失败时按创建失败处理，下次同步时重新查找并更新
+	user.Id = existing.UserId
+	defer func() { user.Id = "" }()
+
+	if existing.DisplayName != user.UserName || existing.Email != user.Email || existing.Account != user.OAID ||
+		existing.EmployeeId != user.UserCode {
+		if err := s.Target.UpdateUser(ctx, user); err != nil {
+			return "", err
+		}
+	}
+	if orgId != user.OrgId || depId != user.DepId {
+		if err := s.Target.MoveUser(ctx, user); err != nil {
+			return "", err
+		}
+	}
+	return existing.UserId, nil
+}
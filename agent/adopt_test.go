@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/CipherChina/OneAuth-Agent/mock"
+)
+
+// oneauth中的部门id，key为外部编码
+func (env *e2eEnv) depIds() map[string]string {
+	ids := make(map[string]string)
+	for _, dep := range env.oneauth.Departments() {
+		ids[dep.OriginId] = dep.DepId
+	}
+	return ids
+}
+
+// 读取基准数据后oneauth中出现了同编码的根节点和部门，创建时使用已有的对象，不产生重复数据
+func TestAdoptExistingRoot(t *testing.T) {
+	env := newE2EEnv(t)
+	env.datapub.SetOrgs(baseOrgs())
+	env.datapub.SetEmps(baseEmps())
+	env.start()
+
+	// 模拟上一次同步创建成功后没有记录id
+	orgId := env.oneauth.AddOrg(e2eRoot, e2eRoot)
+	env.oneauth.AddDepartment(orgId, "", "Sales", "A")
+	env.sync()
+
+	env.assertTree(baseTree)
+	env.assertUsers(baseUsers)
+	if orgs := env.oneauth.Orgs(); len(orgs) != 1 || len(env.oneauth.Departments()) != len(baseTree) {
+		t.Fatal("existing root and department should be adopted, got: ", orgs, env.tree())
+	}
+
+	env.oneauth.ResetRequests()
+	env.sync()
+	env.assertNoWrites()
+}
+
+// 已存在的部门和人员名字、上级不一致时更新为主数据；账号属于其他工号的人员时仍然创建失败
+func TestAdoptExistingObjects(t *testing.T) {
+	env := newE2EEnv(t)
+	env.datapub.SetOrgs(baseOrgs())
+	env.datapub.SetEmps(baseEmps())
+	env.start()
+	env.sync()
+
+	orgId := env.oneauth.Orgs()[0].OrgId
+	ids := env.depIds()
+	env.oneauth.AddDepartment(orgId, "", "Old Legal", "C")
+	env.oneauth.AddDepartment(orgId, ids["B1"], "Contracts", "C1")
+	env.oneauth.AddUser(mock.OneauthUser{Account: "frank", DisplayName: "Frank Old", Email: "frank@example.com", EmployeeId: "E6",
+		OrgId: orgId, DepId: ids["B1"], Status: 1})
+	env.oneauth.AddUser(mock.OneauthUser{Account: "grace", DisplayName: "Grace", EmployeeId: "X9", OrgId: orgId, DepId: ids["B1"], Status: 1})
+
+	env.datapub.SetOrgs(append(baseOrgs(), org("C", "Legal", ""), org("C1", "Contracts", "C")))
+	env.datapub.SetEmps(append(baseEmps(), emp("E6", "Frank", "frank", "C1"), emp("E7", "Grace", "grace", "C")))
+	if err := env.syncer.Sync(context.Background(), "test"); err != nil {
+		t.Fatal("sync: ", err)
+	}
+
+	tree := copyMap(baseTree)
+	tree["C"] = e2eRoot + "/Legal"
+	tree["C1"] = "C/Contracts"
+	env.assertTree(tree)
+	if len(env.oneauth.Departments()) != len(tree) {
+		t.Fatal("existing departments should not be duplicated, got: ", env.oneauth.Departments())
+	}
+
+	users := env.users()
+	if users["E6"] != "Frank|frank|frank@example.com|C1" {
+		t.Fatal("existing user should be adopted and updated, got: ", users["E6"])
+	}
+	if len(users) != len(baseUsers)+2 {
+		t.Fatal("account of another employee should not be adopted, got: ", users)
+	}
+	status, _, _ := env.syncer.GetSyncStatus()
+	if len(status.Errors) != 1 || status.Failed["user"]["create"] != 1 {
+		t.Fatal("create with a conflicting account should fail, got: ", status.Errors)
+	}
+}
+
+// 多个对象创建冲突时，每类列表在一次同步中只读取一次
+func TestAdoptListsOncePerSync(t *testing.T) {
+	env := newE2EEnv(t)
+	env.datapub.SetOrgs(baseOrgs())
+	env.datapub.SetEmps(baseEmps())
+	env.start()
+
+	// 模拟上一次同步创建了所有对象后中止
+	orgId := env.oneauth.AddOrg(e2eRoot, e2eRoot)
+	ids := make(map[string]string)
+	for _, o := range baseOrgs() {
+		ids[o.OrgUnitCode] = env.oneauth.AddDepartment(orgId, ids[o.UpperOrgUnitCode], o.OrgUnitName, o.OrgUnitCode)
+	}
+	for _, e := range baseEmps() {
+		env.oneauth.AddUser(mock.OneauthUser{Account: e.OAID, DisplayName: e.UserName, Email: e.Email, EmployeeId: e.UserCode,
+			OrgId: orgId, DepId: ids[e.OrgCode], Status: 1})
+	}
+
+	env.oneauth.ResetRequests()
+	env.sync()
+	env.assertTree(baseTree)
+	env.assertUsers(baseUsers)
+
+	lists := make(map[string]int)
+	for _, req := range env.oneauth.Requests() {
+		if strings.HasPrefix(req, "GET ") {
+			lists[req]++
+		}
+	}
+	// 人员列表分页读取，读到空页为止
+	expected := map[string]int{"GET /api/v1/account/org": 1, "GET /api/v1/account/org/" + orgId + "/tree": 1, "GET /api/v1/account/user": 2}
+	for list, count := range expected {
+		if lists[list] != count {
+			t.Fatal("list should be read once per sync: ", list, ", got: ", lists)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 第一次创建人员时通过管理接口取消同步
+type cancelOnCreateTarget struct {
+	Target
+	manager *Manager
+	once    sync.Once
+	code    int
+}
+
+func (t *cancelOnCreateTarget) CreateUser(ctx context.Context, user *DataApiEmpNode) (string, error) {
+	t.once.Do(func() {
+		w := httptest.NewRecorder()
+		t.manager.adminCancel(w, httptest.NewRequest(http.MethodPost, "/cancel", nil))
+		t.code = w.Code
+	})
+	return t.Target.CreateUser(ctx, user)
+}
+
+// 取消后正在执行的请求立即结束，保留同步标记，下次同步补齐
+func TestCancelSync(t *testing.T) {
+	env := newE2EEnv(t)
+	env.datapub.SetOrgs(baseOrgs())
+	env.datapub.SetEmps(baseEmps())
+	env.config.System.Fiber = "1"
+	env.start()
+
+	manager := &Manager{Config: env.config, Syncers: []*Syncer{env.syncer}}
+	target := &cancelOnCreateTarget{Target: env.syncer.Target, manager: manager}
+	env.syncer.Target = target
+
+	err := env.syncer.Sync(context.Background(), "test")
+	if !errors.Is(err, ErrSyncInterrupted) || !errors.Is(err, context.Canceled) {
+		t.Fatal("sync should be canceled, got: ", err)
+	}
+	if target.code != http.StatusAccepted {
+		t.Fatal("cancel during sync should be accepted, got: ", target.code)
+	}
+	if len(env.oneauth.Users()) != 0 {
+		t.Fatal("canceled create should not be sent, got users: ", len(env.oneauth.Users()))
+	}
+	if marker, _ := env.syncer.ReadSyncMarker(); marker == nil || !strings.Contains(marker.Error, "canceled") {
+		t.Fatal("sync marker should record the cancel: ", marker)
+	}
+
+	w := httptest.NewRecorder()
+	manager.adminCancel(w, httptest.NewRequest(http.MethodPost, "/cancel", nil))
+	if w.Code != http.StatusConflict {
+		t.Fatal("cancel without running sync should conflict, got: ", w.Code)
+	}
+
+	env.syncer.Target = target.Target
+	env.restart()
+	env.sync()
+	env.assertTree(baseTree)
+	env.assertUsers(baseUsers)
+}
+
+// 单次写请求超时只影响这一个任务，写请求超时后不重试
+func TestWriteTimeout(t *testing.T) {
+	env := newE2EEnv(t)
+	env.datapub.SetOrgs(baseOrgs())
+	env.datapub.SetEmps(baseEmps())
+	env.config.Oneauth.Timeout.WriteDuration = 50 * time.Millisecond
+	env.oneauth.DelayNext(http.MethodPost, "/api/v1/account/user", 2*time.Second, 1)
+	env.start()
+
+	start := time.Now()
+	if err := env.syncer.Sync(context.Background(), "test"); err != nil {
+		t.Fatal("sync: ", err)
+	}
+	if time.Since(start) > time.Second {
+		t.Fatal("timed out request should not wait for the response")
+	}
+
+	status, _, _ := env.syncer.GetSyncStatus()
+	if len(status.Errors) != 1 || !strings.Contains(status.Errors[0], "deadline exceeded") {
+		t.Fatal("one create should time out, got: ", status.Errors)
+	}
+	if len(env.oneauth.Users()) != len(baseUsers)-1 {
+		t.Fatal("other creates should succeed, got users: ", len(env.oneauth.Users()))
+	}
+}
+
+// 整次同步超时后取消剩余任务
+func TestSyncTimeout(t *testing.T) {
+	env := newE2EEnv(t)
+	env.datapub.SetOrgs(baseOrgs())
+	env.datapub.SetEmps(baseEmps())
+	env.config.System.SyncTimeoutDuration = 200 * time.Millisecond
+	env.oneauth.DelayNext(http.MethodPost, "/api/v1/account/user", 5*time.Second, len(baseUsers))
+	env.start()
+
+	err := env.syncer.Sync(context.Background(), "test")
+	if !errors.Is(err, ErrSyncInterrupted) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatal("sync should time out, got: ", err)
+	}
+	if status, _, _ := env.syncer.GetSyncStatus(); status.Success {
+		t.Fatal("timed out sync should not be successful")
+	}
+}
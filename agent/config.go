@@ -0,0 +1,760 @@
+package agent
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/mail"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// 配置文件相关数据结构
+type LogInfo struct {
+	Level string `yaml:"level"`
+	Path  string `yaml:"path"`
+}
+
+// 同步快照配置
+type SnapshotInfo struct {
+	Path   string `yaml:"path"`   // 快照文件路径，为空时不保存快照
+	MaxAge string `yaml:"maxage"` // 快照最长有效时间，如72h，为空时不过期
+}
+
+// 同步报告配置，每次同步后生成json和html报告
+type ReportInfo struct {
+	Path   string `yaml:"path"`   // 报告目录，为空时不生成报告
+	Keep   int    `yaml:"keep"`   // 每个任务最多保留的报告数，为0时不限制
+	MaxAge string `yaml:"maxage"` // 报告最长保留时间，如720h，为空时不过期
+	// 解析后的保留时间
+	MaxAgeDuration time.Duration `yaml:"-"`
+}
+
+// 同步失败时调用的webhook，请求体为json
+type WebhookInfo struct {
+	Url        string `yaml:"url"`         // 为空时不发送
+	Secret     Secret `yaml:"secret"`      // HMAC-SHA256签名密钥，为空时不签名
+	SecretEnv  string `yaml:"secret_env"`  // 从环境变量读取secret
+	SecretFile string `yaml:"secret_file"` // 从文件读取secret
+}
+
+// 同步失败时发送邮件的smtp服务器
+type SmtpInfo struct {
+	Host         string     `yaml:"host"` // 为空时不发送
+	Port         string     `yaml:"port"`
+	Username     string     `yaml:"username"` // 为空时不认证
+	Password     Secret     `yaml:"password"`
+	PasswordEnv  string     `yaml:"password_env"`  // 从环境变量读取password
+	PasswordFile string     `yaml:"password_file"` // 从文件读取password
+	From         string     `yaml:"from"`          // 可以带显示名，如OneAuth Agent <agent@example.com>
+	To           StringList `yaml:"to"`
+	// 解析后的发件人和收件人，smtp命令只使用其中的邮箱地址
+	FromAddress *mail.Address   `yaml:"-"`
+	ToAddresses []*mail.Address `yaml:"-"`
+}
+
+// 同步通知配置，同步失败、被删除保护中止或失败的任务数超过maxerrors时通知
+type NotifyInfo struct {
+	MaxErrors int         `yaml:"maxerrors"` // 同步完成但失败的任务数超过该值时通知，为0时有任务失败就通知
+	Timeout   string      `yaml:"timeout"`   // 每次发送通知的超时时间
+	Template  string      `yaml:"template"`  // 通知内容的text/template模板文件，为空时使用默认模板
+	Webhook   WebhookInfo `yaml:"webhook"`
+	Smtp      SmtpInfo    `yaml:"smtp"`
+	// 解析后的超时时间
+	TimeoutDuration time.Duration `yaml:"-"`
+	// 解析后的通知模板
+	template *template.Template
+}
+
+// 人员任务并发数自动调整，最大并发数为system.fiber
+type AdaptiveInfo struct {
+	Enable  bool   `yaml:"enable"`
+	Min     int    `yaml:"min"`     // 最小并发数，限流或服务端错误时并发数减半，不低于该值
+	Latency string `yaml:"latency"` // 任务耗时低于该值时逐步增加并发数
+	// 解析后的耗时
+	LatencyDuration time.Duration `yaml:"-"`
+}
+
+// 本地管理接口配置
+type AdminInfo struct {
+	Listen    string `yaml:"listen"`     // 监听地址，如127.0.0.1:8090，为空时不开启，/metrics不需要token
+	Token     Secret `yaml:"token"`      // 访问/metrics以外的管理接口的bearer token
+	TokenEnv  string `yaml:"token_env"`  // 从环境变量读取token
+	TokenFile string `yaml:"token_file"` // 从文件读取token
+}
+
+type SystemConfig struct {
+	Log      LogInfo      `yaml:"log"`
+	Fiber    string       `yaml:"fiber"`
+	OrgFiber string       `yaml:"orgfiber"` // 部门接口的并发数，没有上下级关系的部门同时执行
+	Adaptive AdaptiveInfo `yaml:"adaptive"`
+	Snapshot SnapshotInfo `yaml:"snapshot"`
+	Report   ReportInfo   `yaml:"report"`
+	Notify   NotifyInfo   `yaml:"notify"`
+	Admin    AdminInfo    `yaml:"admin"`
+	// 退出时等待正在执行的请求完成的最长时间，如30s
+	ShutdownTimeout string `yaml:"shutdowntimeout"`
+	// 解析后的退出等待时间
+	ShutdownTimeoutDuration time.Duration `yaml:"-"`
+	// 单次同步的最长执行时间，如2h，超时后取消剩余任务，为空时不限制
+	SyncTimeout string `yaml:"synctimeout"`
+	// 解析后的同步超时时间
+	SyncTimeoutDuration time.Duration `yaml:"-"`
+}
+
+type DatabaseUser struct {
+	Appkey        string `yaml:"appkey"`
+	Appsecret     Secret `yaml:"appsecret"`
+	AppsecretEnv  string `yaml:"appsecret_env"`  // 从环境变量读取appsecret
+	AppsecretFile string `yaml:"appsecret_file"` // 从文件读取appsecret，如kubernetes挂载的secret
+}
+
+type FilterInfo struct {
+	Unitcode []string          `yaml:"unitcode"`
+	Unitname []string          `yaml:"unitname"`
+	Filter   map[string]string `yaml:"-"`
+}
+
+// 批量删除保护阈值，为0表示不限制
+type SafetyInfo struct {
+	MaxUserDelete        int `yaml:"maxuserdelete"`        // 单次最多删除人员数
+	MaxUserDeletePercent int `yaml:"maxuserdeletepercent"` // 单次最多删除人员比例
+	MaxOrgDelete         int `yaml:"maxorgdelete"`         // 单次最多删除部门数
+	MaxOrgDeletePercent  int `yaml:"maxorgdeletepercent"`  // 单次最多删除部门比例
+	MaxSourceDropPercent int `yaml:"maxsourcedroppercent"` // 主数据总数相比上次最多减少的比例，只在全量同步时检查
+}
+
+// 增量同步配置
+type IncrementalInfo struct {
+	Enable       bool   `yaml:"enable"`
+	Param        string `yaml:"param"`        // 传给主数据接口的增量参数名，为空时拉取全量后按updateDate过滤
+	FullInterval string `yaml:"fullinterval"` // 强制全量同步的间隔，如168h
+	// 解析后的全量同步间隔
+	FullIntervalDuration time.Duration `yaml:"-"`
+}
+
+// 部门负责人同步配置
+type LeaderInfo struct {
+	Department bool `yaml:"department"` // 将部门负责人同步为oneauth部门主管
+	User       bool `yaml:"user"`       // 将所在部门负责人同步为人员的直属上级
+}
+
+// 数据库端相关配置
+type DataBase struct {
+	Host        string          `yaml:"host"`
+	Port        string          `yaml:"port"`
+	User        DatabaseUser    `yaml:"user"`
+	DefaultTree string          `yaml:"defaulttree"`
+	ReadTime    string          `yaml:"readtime"`
+	Schedule    StringList      `yaml:"schedule"` // cron表达式或every 30m，可以配置多个
+	Timezone    string          `yaml:"timezone"` // 同步计划使用的时区，默认本地时区
+	SyncOu      string          `yaml:"syncou"`
+	Filter      FilterInfo      `yaml:"filter"`
+	Safety      SafetyInfo      `yaml:"safety"`
+	Incremental IncrementalInfo `yaml:"incremental"`
+	Leader      LeaderInfo      `yaml:"leader"`
+	Timeout     string          `yaml:"timeout"` // 每次请求主数据接口的超时时间
+	// 获取组织架构接口
+	OrgInterface string `yaml:"-"`
+	// 获取人员接口
+	MemberInterface string `yaml:"-"`
+	// 解析后的同步计划
+	Schedules []Schedule `yaml:"-"`
+	// 解析后的请求超时时间
+	TimeoutDuration time.Duration `yaml:"-"`
+}
+
+type UpstreamConfig struct {
+	Ssl  string `yaml:"ssl"`
+	Host string `yaml:"host"`
+	Port string `yaml:"port"`
+	// ssl 配置转换，默认true
+	Tls bool `yaml:"-"`
+}
+
+// oneauth接口重试配置
+type RetryInfo struct {
+	Attempts   int    `yaml:"attempts"`   // 最多请求次数，包含第一次请求
+	Backoff    string `yaml:"backoff"`    // 第一次重试前的等待时间，之后每次翻倍
+	MaxBackoff string `yaml:"maxbackoff"` // 最长等待时间
+	// 解析后的等待时间
+	BackoffDuration    time.Duration `yaml:"-"`
+	MaxBackoffDuration time.Duration `yaml:"-"`
+}
+
+// oneauth接口请求超时配置，读接口和写接口分别配置，每次重试单独计时
+type TimeoutInfo struct {
+	Read  string `yaml:"read"`  // 查询接口的超时时间
+	Write string `yaml:"write"` // 创建、更新、删除接口的超时时间
+	// 解析后的超时时间
+	ReadDuration  time.Duration `yaml:"-"`
+	WriteDuration time.Duration `yaml:"-"`
+}
+
+// 限流配置，rate为每秒请求数，为0时不限流
+type LimitInfo struct {
+	Rate  float64 `yaml:"rate"`
+	Burst int     `yaml:"burst"`
+}
+
+// oneauth接口按类别限流，同一个oneauth地址和token的多个任务共享限流配额
+type RateLimitInfo struct {
+	Read   LimitInfo `yaml:"read"`
+	Create LimitInfo `yaml:"create"`
+	Update LimitInfo `yaml:"update"`
+	Delete LimitInfo `yaml:"delete"`
+}
+
+type OneAuthConfig struct {
+	Token     Secret         `yaml:"token"`
+	TokenEnv  string         `yaml:"token_env"`  // 从环境变量读取token
+	TokenFile string         `yaml:"token_file"` // 从文件读取token，如kubernetes挂载的secret
+	Upstream  UpstreamConfig `yaml:"upstream"`
+	RootName  string         `yaml:"rootname"`
+	Retry     RetryInfo      `yaml:"retry"`
+	RateLimit RateLimitInfo  `yaml:"ratelimit"`
+	Timeout   TimeoutInfo    `yaml:"timeout"`
+	BaseUrl   string         `yaml:"-"`
+}
+
+// 同步任务配置，每个任务有独立的主数据、oneauth和快照配置
+type JobConfig struct {
+	Name     string        `yaml:"name"`
+	Oneauth  OneAuthConfig `yaml:"oneauth"`
+	Database DataBase      `yaml:"database"`
+	Snapshot SnapshotInfo  `yaml:"snapshot"` // 为空时使用system.snapshot，文件名加上任务名
+
+	typeErrors []string // 解析时的类型错误和未知字段，由ParseConfig统一报告
+}
+
+// 未配置的项使用默认值
+func (job *JobConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain JobConfig
+	*job = JobConfig{Oneauth: DefaultOneauthConfig(), Database: DefaultDatabase()}
+
+	// 返回类型错误时整个任务会被丢弃，保留已解析的配置项以便继续检查其他问题
+	err := unmarshal((*plain)(job))
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		job.typeErrors = typeErr.Errors
+		return nil
+	}
+	return err
+}
+
+// 配置文件数据存储结构
+type Config struct {
+	Name     string        `yaml:"-"`        // 任务名，未配置jobs时为default
+	System   SystemConfig  `yaml:"system"`   // 系统相关配置
+	Oneauth  OneAuthConfig `yaml:"oneauth"`  // OneAuth相关配置项，未配置jobs时使用
+	Database DataBase      `yaml:"database"` // 同步数据库的相关配置项，未配置jobs时使用
+	Jobs     []JobConfig   `yaml:"jobs"`     // 多个同步任务
+
+	jobs     []*Config // 每个任务的配置，由Init生成
+	warnings []string  // 初始化时发现的不影响运行的问题
+}
+
+// 支持单个字符串或字符串列表的配置项
+type StringList []string
+
+func (l *StringList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var single string
+	if err := unmarshal(&single); err == nil {
+		*l = StringList{single}
+		return nil
+	}
+
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		return err
+	}
+
+	*l = list
+	return nil
+}
+
+// 默认任务名，未配置jobs时使用
+const DefaultJobName = "default"
+
+// 默认的oneauth配置
+func DefaultOneauthConfig() OneAuthConfig {
+	var oneauth OneAuthConfig
+	// 默认开启tls
+	oneauth.Upstream.Tls = true
+	oneauth.Retry.Attempts = 3
+	oneauth.Retry.Backoff = "500ms"
+	oneauth.Retry.MaxBackoff = "10s"
+	oneauth.Timeout.Read = "60s"
+	oneauth.Timeout.Write = "60s"
+	return oneauth
+}
+
+// 默认的主数据配置
+func DefaultDatabase() DataBase {
+	var database DataBase
+	database.Incremental.FullInterval = "168h"
+	database.Timeout = "60s"
+	return database
+}
+
+// 默认配置
+func DefaultConfig() *Config {
+	config := new(Config)
+	config.Name = DefaultJobName
+	config.Oneauth = DefaultOneauthConfig()
+	config.Database = DefaultDatabase()
+	config.System.Log.Level = "4"
+	config.System.Log.Path = "log/OneAuth.log"
+	config.System.Fiber = "10"
+	config.System.OrgFiber = "4"
+	config.System.Adaptive.Min = 1
+	config.System.Adaptive.Latency = "500ms"
+	config.System.Snapshot.Path = "state/snapshot.json"
+	config.System.Snapshot.MaxAge = "168h"
+	config.System.Report.Path = "state/reports"
+	config.System.Report.Keep = 30
+	config.System.Notify.Timeout = "10s"
+	config.System.ShutdownTimeout = "30s"
+	return config
+}
+
+// 读取并解析配置文件，未配置的项使用默认值
+func LoadConfig(fileConf string) (*Config, error) {
+	// 读取配置文件内容
+	data, err := ioutil.ReadFile(fileConf)
+	if err != nil {
+		return nil, fmt.Errorf("read config file error: %v", err)
+	}
+
+	return ParseConfig(data)
+}
+
+// 解析配置内容，未知的配置项、类型错误和无效的配置值会一起返回，每个问题带有yaml路径和行号
+func ParseConfig(data []byte) (*Config, error) {
+	config := DefaultConfig()
+	lines, linePaths := IndexYamlLines(data)
+	v := &configValidator{lines: lines}
+
+	// 类型错误时其他配置项仍然会被解析，继续检查以便一次列出所有问题
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		v.addYamlError(err, linePaths)
+		if _, ok := err.(*yaml.TypeError); !ok {
+			return nil, v.err()
+		}
+	}
+	for _, job := range config.Jobs {
+		if len(job.typeErrors) > 0 {
+			v.addYamlError(&yaml.TypeError{Errors: job.typeErrors}, linePaths)
+		}
+	}
+
+	config.init(v)
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// 生成配置的派生字段并检查配置，配置了jobs时为每个任务生成独立的配置
+func (config *Config) Init() error {
+	v := new(configValidator)
+	config.init(v)
+	return v.err()
+}
+
+func (config *Config) init(v *configValidator) {
+	if len(config.Name) == 0 {
+		config.Name = DefaultJobName
+	}
+
+	config.warnings = nil
+	config.checkSystem(v)
+
+	config.jobs = nil
+	if len(config.Jobs) == 0 {
+		config.initJob(config, v, "")
+		config.check(v, "")
+		config.jobs = []*Config{config}
+		return
+	}
+
+	if len(config.Database.Host) > 0 {
+		v.add("database", "database and oneauth must be set inside jobs when jobs is set")
+	}
+	if len(config.Oneauth.Upstream.Host) > 0 {
+		v.add("oneauth", "database and oneauth must be set inside jobs when jobs is set")
+	}
+
+	names := make(map[string]bool)
+	snapshots := make(map[string]string)
+	ratelimits := make(map[string]*Config)
+	for i, job := range config.Jobs {
+		prefix := fmt.Sprintf("jobs[%d].", i)
+		if len(job.Name) == 0 {
+			v.add(prefix+"name", "job name must be set")
+		} else if names[job.Name] {
+			v.add(prefix+"name", "job %s is duplicated", job.Name)
+		} else if !jobNamePattern.MatchString(job.Name) {
+			v.add(prefix+"name", "job name %q must only contain letters, digits, _ and -", job.Name)
+		}
+		names[job.Name] = true
+
+		jobConfig := &Config{
+			Name:     job.Name,
+			System:   config.System,
+			Oneauth:  job.Oneauth,
+			Database: job.Database,
+		}
+
+		// 每个任务使用独立的快照文件
+		if len(job.Snapshot.Path) > 0 {
+			jobConfig.System.Snapshot.Path = job.Snapshot.Path
+		} else if len(config.System.Snapshot.Path) > 0 {
+			ext := filepath.Ext(config.System.Snapshot.Path)
+			jobConfig.System.Snapshot.Path = strings.TrimSuffix(config.System.Snapshot.Path, ext) + "-" + job.Name + ext
+		}
+		if path := jobConfig.System.Snapshot.Path; len(path) > 0 {
+			if other, ok := snapshots[path]; ok {
+				v.add(prefix+"snapshot.path", "snapshot file %s is already used by job %s", path, other)
+			}
+			snapshots[path] = job.Name
+		}
+
+		if len(job.Snapshot.MaxAge) > 0 {
+			checkDuration(v, prefix+"snapshot.maxage", job.Snapshot.MaxAge)
+			jobConfig.System.Snapshot.MaxAge = job.Snapshot.MaxAge
+		}
+
+		jobConfig.initJob(config, v, prefix)
+		jobConfig.check(v, prefix)
+
+		// 共享限流器的任务使用同一份限流配置
+		key := rateLimitKey(&jobConfig.Oneauth)
+		if other, ok := ratelimits[key]; !ok {
+			ratelimits[key] = jobConfig
+		} else if other.Oneauth.RateLimit != jobConfig.Oneauth.RateLimit {
+			v.add(prefix+"oneauth.ratelimit", "must be the same as job %s which uses the same oneauth", other.Name)
+		}
+		jobConfig.jobs = []*Config{jobConfig}
+		config.jobs = append(config.jobs, jobConfig)
+	}
+}
+
+// 所有同步任务的配置，需要先调用Init
+func (config *Config) JobConfigs() []*Config {
+	return config.jobs
+}
+
+// 初始化配置时的警告，如敏感配置项直接写在配置文件中
+func (config *Config) Warnings() []string {
+	return config.warnings
+}
+
+// 获取敏感配置项，直接写在配置文件中时记录警告，读取失败时返回false
+func (config *Config) resolveSecret(v *configValidator, path string, secret *Secret, env, file string) bool {
+	inline, err := ResolveSecret(secret, env, file)
+	if err != nil {
+		if len(env) > 0 {
+			v.add(path+"_env", "%v", err)
+		} else {
+			v.add(path+"_file", "%v", err)
+		}
+		return false
+	}
+
+	if inline {
+		config.warnings = append(config.warnings, path+" is stored inline in the config file, use *_env or *_file instead")
+	}
+	return true
+}
+
+// 检查所有任务共用的系统配置
+func (config *Config) checkSystem(v *configValidator) {
+	system := &config.System
+	checkInt(v, "system.log.level", system.Log.Level, 0, 6)
+	if len(system.Log.Path) == 0 {
+		v.add("system.log.path", "must be set")
+	}
+	checkInt(v, "system.fiber", system.Fiber, 1, 1000)
+	checkInt(v, "system.orgfiber", system.OrgFiber, 1, 100)
+	if system.Adaptive.Enable == true {
+		if fiber, err := strconv.Atoi(system.Fiber); err == nil && (system.Adaptive.Min < 1 || system.Adaptive.Min > fiber) {
+			v.add("system.adaptive.min", "must be between 1 and system.fiber %d, got %d", fiber, system.Adaptive.Min)
+		}
+		system.Adaptive.LatencyDuration = checkTimeout(v, "system.adaptive.latency", system.Adaptive.Latency)
+	}
+
+	if len(system.Snapshot.MaxAge) > 0 {
+		checkDuration(v, "system.snapshot.maxage", system.Snapshot.MaxAge)
+	}
+	if system.Report.Keep < 0 {
+		v.add("system.report.keep", "must not be negative, got %d", system.Report.Keep)
+	}
+	if len(system.Report.MaxAge) > 0 {
+		system.Report.MaxAgeDuration = checkDuration(v, "system.report.maxage", system.Report.MaxAge)
+	}
+	system.ShutdownTimeoutDuration = checkDuration(v, "system.shutdowntimeout", system.ShutdownTimeout)
+	if len(system.SyncTimeout) > 0 {
+		system.SyncTimeoutDuration = checkDuration(v, "system.synctimeout", system.SyncTimeout)
+	}
+
+	config.checkNotify(v)
+
+	admin := &system.Admin
+	resolved := config.resolveSecret(v, "system.admin.token", &admin.Token, admin.TokenEnv, admin.TokenFile)
+	if len(admin.Listen) > 0 {
+		checkListen(v, "system.admin.listen", admin.Listen)
+		if resolved && len(admin.Token) == 0 {
+			v.add("system.admin.token", "must be set when system.admin.listen is set")
+		}
+	}
+}
+
+// 检查同步通知配置，解析通知模板
+func (config *Config) checkNotify(v *configValidator) {
+	notify := &config.System.Notify
+	if notify.MaxErrors < 0 {
+		v.add("system.notify.maxerrors", "must not be negative, got %d", notify.MaxErrors)
+	}
+	notify.TimeoutDuration = checkTimeout(v, "system.notify.timeout", notify.Timeout)
+
+	notify.template = defaultNotifyTemplate
+	if len(notify.Template) > 0 {
+		data, err := ioutil.ReadFile(notify.Template)
+		if err != nil {
+			v.add("system.notify.template", "%v", err)
+		} else if notify.template, err = ParseNotifyTemplate(string(data)); err != nil {
+			v.add("system.notify.template", "%v", err)
+		}
+	}
+
+	webhook := &notify.Webhook
+	config.resolveSecret(v, "system.notify.webhook.secret", &webhook.Secret, webhook.SecretEnv, webhook.SecretFile)
+	if len(webhook.Url) > 0 {
+		if u, err := url.Parse(webhook.Url); err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+			v.add("system.notify.webhook.url", "must be an http or https url, got %q", webhook.Url)
+		}
+	}
+
+	smtp := &notify.Smtp
+	config.resolveSecret(v, "system.notify.smtp.password", &smtp.Password, smtp.PasswordEnv, smtp.PasswordFile)
+	if len(smtp.Host) == 0 {
+		return
+	}
+	checkHost(v, "system.notify.smtp.host", smtp.Host)
+	checkPort(v, "system.notify.smtp.port", smtp.Port)
+	from, err := mail.ParseAddress(smtp.From)
+	if err != nil {
+		v.add("system.notify.smtp.from", "must be an email address, got %q", smtp.From)
+	}
+	smtp.FromAddress = from
+	if len(smtp.To) == 0 {
+		v.add("system.notify.smtp.to", "must be set when system.notify.smtp.host is set")
+	}
+	smtp.ToAddresses = nil
+	for i, to := range smtp.To {
+		address, err := mail.ParseAddress(to)
+		if err != nil {
+			v.add(fmt.Sprintf("system.notify.smtp.to[%d]", i), "must be an email address, got %q", to)
+			continue
+		}
+		smtp.ToAddresses = append(smtp.ToAddresses, address)
+	}
+}
+
+// 生成单个任务配置的派生字段，root用于记录警告，prefix为任务在配置文件中的路径
+func (config *Config) initJob(root *Config, v *configValidator, prefix string) {
+	oneauth := &config.Oneauth
+	root.resolveSecret(v, prefix+"oneauth.token", &oneauth.Token, oneauth.TokenEnv, oneauth.TokenFile)
+
+	user := &config.Database.User
+	root.resolveSecret(v, prefix+"database.user.appsecret", &user.Appsecret, user.AppsecretEnv, user.AppsecretFile)
+
+	if config.Oneauth.Upstream.Ssl == "false" {
+		config.Oneauth.Upstream.Tls = false
+	}
+
+	// 解析目录过滤
+	config.Database.Filter.Filter = make(map[string]string)
+	for _, key := range config.Database.Filter.Unitcode {
+		config.Database.Filter.Filter[key] = "1"
+	}
+	for _, key := range config.Database.Filter.Unitname {
+		config.Database.Filter.Filter[key] = "1"
+	}
+
+	// 初始化主数据相关接口
+	var BaseUrl = "https://" + config.Database.Host + ":" + config.Database.Port
+	config.Database.OrgInterface = BaseUrl + OrgUrl + config.Database.User.Appkey
+	config.Database.MemberInterface = BaseUrl + MemberUrl + config.Database.User.Appkey
+
+	// 初始化oneauth
+	config.Oneauth.BaseUrl = "http://"
+	if config.Oneauth.Upstream.Tls == true {
+		config.Oneauth.BaseUrl = "https://"
+	}
+	config.Oneauth.BaseUrl += config.Oneauth.Upstream.Host + ":" + config.Oneauth.Upstream.Port
+}
+
+// 检查单个任务的配置是否有效，同时解析时间相关的配置
+func (config *Config) Check() error {
+	v := new(configValidator)
+	config.check(v, "")
+	return v.err()
+}
+
+func (config *Config) check(v *configValidator, prefix string) {
+	oneauth := &config.Oneauth
+	if len(oneauth.Token) == 0 && len(oneauth.TokenEnv) == 0 && len(oneauth.TokenFile) == 0 {
+		v.add(prefix+"oneauth.token", "must be set")
+	}
+
+	switch oneauth.Upstream.Ssl {
+	case "", "true", "false":
+	default:
+		v.add(prefix+"oneauth.upstream.ssl", "must be \"true\" or \"false\", got %q", oneauth.Upstream.Ssl)
+	}
+	checkHost(v, prefix+"oneauth.upstream.host", oneauth.Upstream.Host)
+	checkPort(v, prefix+"oneauth.upstream.port", oneauth.Upstream.Port)
+
+	if len(oneauth.RootName) == 0 {
+		v.add(prefix+"oneauth.rootname", "must be set")
+	} else {
+		checkOrgCode(v, prefix+"oneauth.rootname", oneauth.RootName)
+	}
+
+	retry := &oneauth.Retry
+	if retry.Attempts <= 0 {
+		v.add(prefix+"oneauth.retry.attempts", "must be positive, got %d", retry.Attempts)
+	}
+	retry.BackoffDuration = checkDuration(v, prefix+"oneauth.retry.backoff", retry.Backoff)
+	retry.MaxBackoffDuration = checkDuration(v, prefix+"oneauth.retry.maxbackoff", retry.MaxBackoff)
+	if retry.MaxBackoffDuration < retry.BackoffDuration {
+		retry.MaxBackoffDuration = retry.BackoffDuration
+	}
+
+	oneauth.Timeout.ReadDuration = checkTimeout(v, prefix+"oneauth.timeout.read", oneauth.Timeout.Read)
+	oneauth.Timeout.WriteDuration = checkTimeout(v, prefix+"oneauth.timeout.write", oneauth.Timeout.Write)
+
+	limits := map[string]LimitInfo{"read": oneauth.RateLimit.Read, "create": oneauth.RateLimit.Create,
+		"update": oneauth.RateLimit.Update, "delete": oneauth.RateLimit.Delete}
+	for _, name := range []string{"read", "create", "update", "delete"} {
+		if limits[name].Rate < 0 {
+			v.add(prefix+"oneauth.ratelimit."+name+".rate", "must not be negative, got %v", limits[name].Rate)
+		}
+		if limits[name].Burst < 0 {
+			v.add(prefix+"oneauth.ratelimit."+name+".burst", "must not be negative, got %d", limits[name].Burst)
+		}
+	}
+
+	database := &config.Database
+	checkHost(v, prefix+"database.host", database.Host)
+	checkPort(v, prefix+"database.port", database.Port)
+
+	if len(database.DefaultTree) == 0 {
+		v.add(prefix+"database.defaulttree", "must be set")
+	} else {
+		checkOrgCode(v, prefix+"database.defaulttree", database.DefaultTree)
+	}
+
+	if len(database.User.Appkey) == 0 {
+		v.add(prefix+"database.user.appkey", "must be set")
+	} else if strings.ContainsAny(database.User.Appkey, "/?#& \t") {
+		v.add(prefix+"database.user.appkey", "must not contain url special characters or spaces: %q", database.User.Appkey)
+	}
+	if len(database.User.Appsecret) == 0 && len(database.User.AppsecretEnv) == 0 && len(database.User.AppsecretFile) == 0 {
+		v.add(prefix+"database.user.appsecret", "must be set")
+	}
+
+	checkSchedule(v, prefix+"database.", database)
+	database.TimeoutDuration = checkTimeout(v, prefix+"database.timeout", database.Timeout)
+
+	if len(database.Incremental.FullInterval) > 0 {
+		database.Incremental.FullIntervalDuration = checkDuration(v, prefix+"database.incremental.fullinterval", database.Incremental.FullInterval)
+	}
+
+	safety := database.Safety
+	counts := map[string]int{"maxuserdelete": safety.MaxUserDelete, "maxorgdelete": safety.MaxOrgDelete}
+	for _, name := range []string{"maxuserdelete", "maxorgdelete"} {
+		if counts[name] < 0 {
+			v.add(prefix+"database.safety."+name, "must not be negative, got %d", counts[name])
+		}
+	}
+	percents := map[string]int{"maxuserdeletepercent": safety.MaxUserDeletePercent,
+		"maxorgdeletepercent": safety.MaxOrgDeletePercent, "maxsourcedroppercent": safety.MaxSourceDropPercent}
+	for _, name := range []string{"maxuserdeletepercent", "maxorgdeletepercent", "maxsourcedroppercent"} {
+		if percents[name] < 0 || percents[name] > 100 {
+			v.add(prefix+"database.safety."+name, "must be between 0 and 100, got %d", percents[name])
+		}
+	}
+
+	// 过滤的组织编码和名称
+	seen := make(map[string]bool)
+	filter := database.Filter
+	for _, name := range []string{"unitcode", "unitname"} {
+		codes := filter.Unitcode
+		if name == "unitname" {
+			codes = filter.Unitname
+		}
+		for i, code := range codes {
+			path := fmt.Sprintf("%sdatabase.filter.%s[%d]", prefix, name, i)
+			checkOrgCode(v, path, code)
+			if seen[name+code] {
+				v.add(path, "%q is duplicated", code)
+			}
+			seen[name+code] = true
+		}
+	}
+
+	if len(database.SyncOu) > 0 {
+		checkOrgCode(v, prefix+"database.syncou", database.SyncOu)
+		if _, ok := filter.Filter[database.SyncOu]; ok {
+			v.add(prefix+"database.syncou", "%q is excluded by database.filter, nothing would be synced", database.SyncOu)
+		}
+	}
+}
+
+// 检查并解析同步计划配置，未配置schedule时使用readtime每天执行一次
+func checkSchedule(v *configValidator, prefix string, database *DataBase) {
+	loc := time.Local
+	if len(database.Timezone) > 0 {
+		var err error
+		if loc, err = time.LoadLocation(database.Timezone); err != nil {
+			v.add(prefix+"timezone", "unknown time zone %q", database.Timezone)
+			loc = time.Local
+		}
+	}
+
+	offset, err := ParseReadTime(database.ReadTime)
+	if err != nil {
+		v.add(prefix+"readtime", "%v, got %q", err, database.ReadTime)
+	}
+
+	database.Schedules = nil
+	for i, spec := range database.Schedule {
+		path := prefix + "schedule"
+		if len(database.Schedule) > 1 {
+			path = fmt.Sprintf("%s[%d]", path, i)
+		}
+
+		schedule, err := ParseSchedule(spec, loc)
+		if err != nil {
+			v.add(path, "%v", err)
+			continue
+		}
+		if schedule.Next(time.Now()).IsZero() {
+			v.add(path, "%s will never run", spec)
+			continue
+		}
+		database.Schedules = append(database.Schedules, schedule)
+	}
+
+	if len(database.Schedules) == 0 {
+		database.Schedules = append(database.Schedules, DailySchedule{Offset: time.Second * offset, Location: loc})
+	}
+}
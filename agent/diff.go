@@ -0,0 +1,315 @@
+package agent
+
+import (
+	"sort"
+)
+
+// 比对使用的部门和人员数据，可以是上一次同步后的备份、oneauth中的数据或主数据
+type SyncState struct {
+	Orgs    []SnapshotOrg
+	Members []SnapshotMember
+}
+
+// 比对选项
+type DiffOptions struct {
+	Leader  bool // 是否比对部门负责人
+	Manager bool // 是否比对人员直属上级
+}
+
+// 单个部门的变更
+type OrgChange struct {
+	Action int         // 操作类型，同DataOrgMemNode.Action
+	Org    SnapshotOrg // 同步后的部门，oneauth id来自基准数据，删除时为基准数据中的部门
+	Old    SnapshotOrg // 基准数据中的部门，新建时为空
+}
+
+// 单个人员的变更
+type MemberChange struct {
+	Action int            // 操作类型，同DataApiEmpNode.Action
+	Member SnapshotMember // 同步后的人员，oneauth id来自基准数据，删除时为基准数据中的人员
+	Old    SnapshotMember // 基准数据中的人员，新建时为空
+
+	ManagerCode string // 期望的直属上级工号，Action包含1 << 4时有效
+}
+
+// 比对结果，所有数据都是副本，修改不会影响比对的输入
+type DiffPlan struct {
+	// 期望数据，填充了基准数据中的oneauth id和已同步的负责人、直属上级，按编码排序
+	Resolved SyncState
+
+	OrgCreates []OrgChange    // 父部门在子部门之前，同一层级按编码排序
+	OrgUpdates []OrgChange    // 父部门在子部门之前，同一层级按编码排序
+	OrgDeletes []OrgChange    // 子部门在父部门之前，同一层级按编码排序
+	Members    []MemberChange // 按工号排序
+}
+
+// 没有任何变更
+func (plan *DiffPlan) Empty() bool {
+	return len(plan.OrgCreates) == 0 && len(plan.OrgUpdates) == 0 && len(plan.OrgDeletes) == 0 && len(plan.Members) == 0
+}
+
+// 按编码建立索引，编码重复时以最后一个为准，返回排序后的编码
+func orgIndex(orgs []SnapshotOrg) (map[string]SnapshotOrg, []string) {
+	index := make(map[string]SnapshotOrg, len(orgs))
+	for _, org := range orgs {
+		index[org.Code] = org
+	}
+
+	codes := make([]string, 0, len(index))
+	for code := range index {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return index, codes
+}
+
+func memberIndex(members []SnapshotMember) (map[string]SnapshotMember, []string) {
+	index := make(map[string]SnapshotMember, len(members))
+	for _, member := range members {
+		index[member.UserCode] = member
+	}
+
+	codes := make([]string, 0, len(index))
+	for code := range index {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return index, codes
+}
+
+// 部门的层级，根节点和顶层部门为0，父部门不存在时视为顶层部门，出现环时最多查找len(index)层
+func orgDepth(index map[string]SnapshotOrg, code string) int {
+	depth := 0
+	for i := 0; i < len(index); i++ {
+		org, ok := index[code]
+		if !ok || org.Root {
+			break
+		}
+		parent, ok := index[org.ParentCode]
+		if !ok || parent.Root {
+			break
+		}
+		depth++
+		code = org.ParentCode
+	}
+	return depth
+}
+
+// 人员的直属上级工号，为所在部门的负责人，本人是负责人时向上查找，出现环时最多查找len(index)层
+func desiredManagerCode(index map[string]SnapshotOrg, member SnapshotMember) string {
+	code := member.OrgCode
+	for i := 0; i < len(index); i++ {
+		org, ok := index[code]
+		if !ok {
+			break
+		}
+		if len(org.LeaderCode) > 0 && org.LeaderCode != member.UserCode {
+			return org.LeaderCode
+		}
+		if org.Root {
+			break
+		}
+		code = org.ParentCode
+	}
+	return ""
+}
+
+// 按层级和编码排序，reverse为true时子部门在父部门之前
+func sortOrgChanges(changes []OrgChange, index map[string]SnapshotOrg, reverse bool) {
+	depths := make(map[string]int, len(changes))
+	for _, change := range changes {
+		depths[change.Org.Code] = orgDepth(index, change.Org.Code)
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		di, dj := depths[changes[i].Org.Code], depths[changes[j].Org.Code]
+		// 根节点在最前面
+		if changes[i].Org.Root != changes[j].Org.Root {
+			return changes[i].Org.Root != reverse
+		}
+		if di != dj {
+			return (di < dj) != reverse
+		}
+		return changes[i].Org.Code < changes[j].Org.Code
+	})
+}
+
+// 比对基准数据和期望数据，生成同步计划，不会修改输入数据，相同的输入总是生成相同的计划
+// 基准数据中没有oneauth id的部门和人员是上一次创建失败的，需要重新创建，不需要删除
+func DiffSyncState(prev, desired SyncState, options DiffOptions) *DiffPlan {
+	plan := new(DiffPlan)
+	prevOrgs, prevOrgCodes := orgIndex(prev.Orgs)
+	desiredOrgs, desiredOrgCodes := orgIndex(desired.Orgs)
+
+	// 只有一个根节点，有多个时使用编码最小的，其他的作为普通部门
+	var rootCode string
+	for _, code := range desiredOrgCodes {
+		if desiredOrgs[code].Root {
+			rootCode = code
+			break
+		}
+	}
+	for _, code := range desiredOrgCodes {
+		if org := desiredOrgs[code]; org.Root && code != rootCode {
+			org.Root = false
+			desiredOrgs[code] = org
+		}
+	}
+
+	var orgId string
+	if old, ok := prevOrgs[rootCode]; ok && old.Root && len(old.OrgId) > 0 && len(rootCode) > 0 {
+		orgId = old.OrgId
+	}
+
+	// 已同步到oneauth的部门
+	applied := func(code string) (SnapshotOrg, bool) {
+		old, ok := prevOrgs[code]
+		return old, ok && !old.Root && len(old.DepId) > 0
+	}
+
+	// 父部门的oneauth id，父部门需要新建时为空
+	fatherId := func(parentCode string) string {
+		if parentCode == rootCode {
+			return orgId
+		}
+		if father, ok := applied(parentCode); ok {
+			return father.DepId
+		}
+		return ""
+	}
+
+	for _, code := range desiredOrgCodes {
+		org := desiredOrgs[code]
+		resolved := SnapshotOrg{Code: org.Code, Name: org.Name, ParentCode: org.ParentCode, Root: org.Root, OrgId: orgId}
+
+		if org.Root {
+			resolved.ParentCode = ""
+			// 根节点只需要创建，不做更新
+			if len(orgId) == 0 {
+				plan.OrgCreates = append(plan.OrgCreates, OrgChange{Action: 1, Org: resolved})
+			}
+			plan.Resolved.Orgs = append(plan.Resolved.Orgs, resolved)
+			continue
+		}
+
+		old, ok := applied(code)
+		if !ok {
+			// 新建部门
+			resolved.FatherId = fatherId(org.ParentCode)
+			action := 1 << 0
+			if options.Leader && len(org.LeaderCode) > 0 {
+				action |= 1 << 4
+			}
+			plan.OrgCreates = append(plan.OrgCreates, OrgChange{Action: action, Org: resolved})
+			plan.Resolved.Orgs = append(plan.Resolved.Orgs, resolved)
+			continue
+		}
+
+		resolved.OrgId = old.OrgId
+		resolved.DepId = old.DepId
+		resolved.FatherId = old.FatherId
+		resolved.LeaderCode = old.LeaderCode
+		resolved.ManagerId = old.ManagerId
+
+		action := 0
+		if org.Name != old.Name {
+			action |= 1 << 1
+		}
+		if org.ParentCode != old.ParentCode {
+			action |= 1 << 2
+			// 新的父部门需要新建时，执行时再从新建的部门中查找
+			resolved.FatherId = fatherId(org.ParentCode)
+		}
+		if options.Leader && org.LeaderCode != old.LeaderCode {
+			action |= 1 << 4
+		}
+		if action != 0 {
+			plan.OrgUpdates = append(plan.OrgUpdates, OrgChange{Action: action, Org: resolved, Old: old})
+		}
+		plan.Resolved.Orgs = append(plan.Resolved.Orgs, resolved)
+	}
+
+	for _, code := range prevOrgCodes {
+		if _, ok := desiredOrgs[code]; ok {
+			continue
+		}
+		// 根节点不删除，没有创建成功的部门不需要删除
+		old, ok := applied(code)
+		if !ok {
+			continue
+		}
+		plan.OrgDeletes = append(plan.OrgDeletes, OrgChange{Action: 1 << 3, Org: old, Old: old})
+	}
+
+	sortOrgChanges(plan.OrgCreates, desiredOrgs, false)
+	sortOrgChanges(plan.OrgUpdates, desiredOrgs, false)
+	sortOrgChanges(plan.OrgDeletes, prevOrgs, true)
+
+	// 人员所在部门的oneauth id，根节点下不能直接放人员
+	resolvedOrgs := make(map[string]SnapshotOrg, len(plan.Resolved.Orgs))
+	for _, org := range plan.Resolved.Orgs {
+		if !org.Root {
+			resolvedOrgs[org.Code] = org
+		}
+	}
+
+	prevMembers, prevMemberCodes := memberIndex(prev.Members)
+	desiredMembers, desiredMemberCodes := memberIndex(desired.Members)
+	for _, code := range desiredMemberCodes {
+		member := desiredMembers[code]
+		resolved := SnapshotMember{UserCode: member.UserCode, UserName: member.UserName, Email: member.Email, OAID: member.OAID,
+			Status: member.Status, OrgCode: member.OrgCode}
+		if org, ok := resolvedOrgs[member.OrgCode]; ok {
+			resolved.OrgId = org.OrgId
+			resolved.DepId = org.DepId
+		}
+
+		action := 1 << 0
+		old, ok := prevMembers[code]
+		if ok && len(old.Id) > 0 {
+			resolved.Id = old.Id
+			resolved.ManagerCode = old.ManagerCode
+			resolved.ManagerId = old.ManagerId
+
+			action = 0
+			if resolved.UserName != old.UserName || resolved.Email != old.Email || resolved.OAID != old.OAID {
+				action |= 1 << 1
+			}
+			if resolved.DepId != old.DepId || resolved.OrgId != old.OrgId {
+				action |= 1 << 2
+			}
+		} else {
+			old = SnapshotMember{}
+		}
+
+		// 直属上级在人员创建后更新，新建的人员没有已同步的直属上级
+		var managerCode string
+		if options.Manager {
+			managerCode = desiredManagerCode(desiredOrgs, member)
+			if managerCode != resolved.ManagerCode {
+				action |= 1 << 4
+			}
+		}
+
+		if action != 0 {
+			plan.Members = append(plan.Members, MemberChange{Action: action, Member: resolved, Old: old, ManagerCode: managerCode})
+		}
+		plan.Resolved.Members = append(plan.Resolved.Members, resolved)
+	}
+
+	for _, code := range prevMemberCodes {
+		if _, ok := desiredMembers[code]; ok {
+			continue
+		}
+		// 没有创建成功的人员不需要删除
+		if old := prevMembers[code]; len(old.Id) > 0 {
+			plan.Members = append(plan.Members, MemberChange{Action: 1 << 3, Member: old, Old: old})
+		}
+	}
+
+	sort.SliceStable(plan.Members, func(i, j int) bool {
+		return plan.Members[i].Member.UserCode < plan.Members[j].Member.UserCode
+	})
+
+	return plan
+}
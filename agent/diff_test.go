@@ -0,0 +1,377 @@
+package agent
+
+import (
+	"math/rand"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+const diffRoot = "ROOT"
+
+var (
+	diffOrgCodes  = []string{"A", "B", "C", "D", "E", "F", "G", "H"}
+	diffUserCodes = []string{"E1", "E2", "E3", "E4", "E5", "E6", "E7", "E8"}
+)
+
+// 生成测试数据使用的随机数，*rand.Rand和byteSource都满足
+type intn interface {
+	Intn(n int) int
+}
+
+// 从模糊测试的输入中取随机数，用完后从头循环
+type byteSource struct {
+	data []byte
+	pos  int
+}
+
+func (b *byteSource) Intn(n int) int {
+	if len(b.data) == 0 {
+		return 0
+	}
+	v := int(b.data[b.pos%len(b.data)])
+	b.pos++
+	return v % n
+}
+
+// 随机生成期望数据，部门组成一棵以ROOT为根的树
+func genDesiredState(r intn) SyncState {
+	state := SyncState{Orgs: []SnapshotOrg{{Code: diffRoot, Name: diffRoot, Root: true}}}
+	var codes []string
+	for _, i := range rand.New(rand.NewSource(int64(r.Intn(1 << 16)))).Perm(len(diffOrgCodes)) {
+		if r.Intn(4) == 0 {
+			continue
+		}
+
+		code := diffOrgCodes[i]
+		org := SnapshotOrg{Code: code, Name: "org" + strconv.Itoa(r.Intn(3)), ParentCode: diffRoot}
+		if len(codes) > 0 && r.Intn(2) == 0 {
+			org.ParentCode = codes[r.Intn(len(codes))]
+		}
+		if r.Intn(2) == 0 {
+			org.LeaderCode = diffUserCodes[r.Intn(len(diffUserCodes))]
+		}
+		codes = append(codes, code)
+		state.Orgs = append(state.Orgs, org)
+	}
+
+	for _, code := range diffUserCodes {
+		if r.Intn(4) == 0 || len(codes) == 0 {
+			continue
+		}
+		name := "user" + strconv.Itoa(r.Intn(3))
+		state.Members = append(state.Members, SnapshotMember{UserCode: code, UserName: name, Email: name + "@example.com",
+			OAID: name, Status: "1", OrgCode: codes[r.Intn(len(codes))]})
+	}
+	return state
+}
+
+// 随机生成基准数据，部分部门和人员上一次创建失败，没有oneauth id
+func genPrevState(r intn) SyncState {
+	state := genDesiredState(r)
+
+	var orgId string
+	if r.Intn(8) != 0 {
+		orgId = "org-1"
+	}
+	depIds := map[string]string{diffRoot: orgId}
+	for i := range state.Orgs {
+		org := &state.Orgs[i]
+		org.OrgId = orgId
+		if !org.Root && len(orgId) > 0 && r.Intn(8) != 0 {
+			org.DepId = "dep-" + org.Code
+			depIds[org.Code] = org.DepId
+		}
+		if r.Intn(2) == 0 {
+			org.LeaderCode = ""
+		}
+	}
+	for i := range state.Orgs {
+		if org := &state.Orgs[i]; len(org.DepId) > 0 {
+			org.FatherId = depIds[org.ParentCode]
+		}
+	}
+
+	for i := range state.Members {
+		member := &state.Members[i]
+		if len(orgId) > 0 && r.Intn(8) != 0 {
+			member.Id = "user-" + member.UserCode
+		}
+		member.OrgId = orgId
+		member.DepId = depIds[member.OrgCode]
+		if r.Intn(2) == 0 {
+			member.ManagerCode = diffUserCodes[r.Intn(len(diffUserCodes))]
+		}
+	}
+	return state
+}
+
+// 深拷贝，用于检查比对没有修改输入
+func copyState(state SyncState) SyncState {
+	return SyncState{
+		Orgs:    append([]SnapshotOrg(nil), state.Orgs...),
+		Members: append([]SnapshotMember(nil), state.Members...),
+	}
+}
+
+func shuffleState(r *rand.Rand, state SyncState) SyncState {
+	state = copyState(state)
+	r.Shuffle(len(state.Orgs), func(i, j int) { state.Orgs[i], state.Orgs[j] = state.Orgs[j], state.Orgs[i] })
+	r.Shuffle(len(state.Members), func(i, j int) { state.Members[i], state.Members[j] = state.Members[j], state.Members[i] })
+	return state
+}
+
+// 模拟所有任务执行成功后oneauth中的数据
+func applyDiff(desired SyncState, plan *DiffPlan) SyncState {
+	desiredOrgs, _ := orgIndex(desired.Orgs)
+	actions := make(map[string]int)
+	for _, change := range append(append([]OrgChange(nil), plan.OrgCreates...), plan.OrgUpdates...) {
+		actions[change.Org.Code] = change.Action
+	}
+	created := make(map[string]bool)
+	managers := make(map[string]string)
+	for _, change := range plan.Members {
+		if change.Action&(1<<0) != 0 {
+			created[change.Member.UserCode] = true
+		}
+		if change.Action&(1<<4) != 0 {
+			managers[change.Member.UserCode] = change.ManagerCode
+		}
+	}
+
+	var orgId string
+	for _, org := range plan.Resolved.Orgs {
+		if org.Root {
+			orgId = org.OrgId
+			if actions[org.Code]&(1<<0) != 0 {
+				orgId = "org-new"
+			}
+		}
+	}
+
+	var applied SyncState
+	orgs := make(map[string]SnapshotOrg)
+	for _, org := range plan.Resolved.Orgs {
+		action := actions[org.Code]
+		if action&(1<<0) != 0 {
+			org.OrgId = orgId
+			if !org.Root {
+				org.DepId = "dep-new-" + org.Code
+			}
+		}
+		if action&(1<<4) != 0 {
+			org.LeaderCode = desiredOrgs[org.Code].LeaderCode
+		}
+		orgs[org.Code] = org
+		applied.Orgs = append(applied.Orgs, org)
+	}
+
+	for _, member := range plan.Resolved.Members {
+		if created[member.UserCode] {
+			member.Id = "user-new-" + member.UserCode
+		}
+		if code, ok := managers[member.UserCode]; ok {
+			member.ManagerCode = code
+		}
+		// 部门创建后刷新人员的部门id
+		if org, ok := orgs[member.OrgCode]; ok && !org.Root {
+			member.OrgId = org.OrgId
+			member.DepId = org.DepId
+		}
+		applied.Members = append(applied.Members, member)
+	}
+	return applied
+}
+
+// 检查计划的顺序：新建和更新时父部门在前，删除时子部门在前，人员按工号排序
+func checkDiffOrder(t *testing.T, prev, desired SyncState, plan *DiffPlan) {
+	t.Helper()
+	prevOrgs, _ := orgIndex(prev.Orgs)
+	desiredOrgs, _ := orgIndex(desired.Orgs)
+
+	parentFirst := func(name string, changes []OrgChange, index map[string]SnapshotOrg, reverse bool) {
+		position := make(map[string]int)
+		for i, change := range changes {
+			if _, ok := position[change.Org.Code]; ok {
+				t.Fatalf("%s: duplicate org %s", name, change.Org.Code)
+			}
+			position[change.Org.Code] = i
+		}
+		for code, i := range position {
+			j, ok := position[index[code].ParentCode]
+			if ok && (j < i) == reverse {
+				t.Fatalf("%s: org %s at %d, parent %s at %d", name, code, i, index[code].ParentCode, j)
+			}
+		}
+	}
+	parentFirst("creates", plan.OrgCreates, desiredOrgs, false)
+	parentFirst("updates", plan.OrgUpdates, desiredOrgs, false)
+	parentFirst("deletes", plan.OrgDeletes, prevOrgs, true)
+
+	for i := 1; i < len(plan.Members); i++ {
+		if plan.Members[i-1].Member.UserCode >= plan.Members[i].Member.UserCode {
+			t.Fatal("members should be sorted by code: ", plan.Members[i-1].Member.UserCode, plan.Members[i].Member.UserCode)
+		}
+	}
+}
+
+// 检查比对的性质：不修改输入、结果和输入顺序无关、顺序正确、执行计划后再次比对没有变更
+func checkDiffProperties(t *testing.T, r *rand.Rand, prev, desired SyncState, options DiffOptions) {
+	t.Helper()
+	prevCopy, desiredCopy := copyState(prev), copyState(desired)
+
+	plan := DiffSyncState(prev, desired, options)
+	if !reflect.DeepEqual(prev, prevCopy) || !reflect.DeepEqual(desired, desiredCopy) {
+		t.Fatal("diff should not modify its input")
+	}
+
+	if again := DiffSyncState(shuffleState(r, prev), shuffleState(r, desired), options); !reflect.DeepEqual(plan, again) {
+		t.Fatalf("diff should not depend on input order\nfirst: %+v\nagain: %+v", plan, again)
+	}
+
+	checkDiffOrder(t, prev, desired, plan)
+
+	applied := applyDiff(desired, plan)
+	if next := DiffSyncState(applied, desired, options); !next.Empty() {
+		t.Fatalf("applied plan should converge\nprev: %+v\ndesired: %+v\nplan: %+v\nnext: %+v", prev, desired, plan, next)
+	}
+}
+
+// 父部门先创建，子部门先删除，同一层级按编码排序
+func TestDiffSyncStateOrder(t *testing.T) {
+	prev := SyncState{
+		Orgs: []SnapshotOrg{
+			{Code: "X1", Name: "X1", ParentCode: "X", OrgId: "o", DepId: "x1"},
+			{Code: diffRoot, Name: diffRoot, Root: true, OrgId: "o"},
+			{Code: "X", Name: "X", ParentCode: diffRoot, OrgId: "o", DepId: "x"},
+			{Code: "A", Name: "Sales", ParentCode: diffRoot, OrgId: "o", DepId: "a"},
+			{Code: "F", Name: "Failed", ParentCode: diffRoot, OrgId: "o"},
+			{Code: "X2", Name: "X2", ParentCode: "X1", OrgId: "o", DepId: "x2"},
+		},
+		Members: []SnapshotMember{
+			{UserCode: "E3", UserName: "Carol", OrgCode: "X", Id: "u3", OrgId: "o", DepId: "x"},
+			{UserCode: "E1", UserName: "Alice", OrgCode: "A", Id: "u1", OrgId: "o", DepId: "a"},
+			{UserCode: "E9", UserName: "Failed", OrgCode: "A", OrgId: "o", DepId: "a"},
+		},
+	}
+	desired := SyncState{
+		Orgs: []SnapshotOrg{
+			{Code: "B1", Name: "B1", ParentCode: "B"},
+			{Code: "A", Name: "Sales Global", ParentCode: diffRoot},
+			{Code: "B", Name: "B", ParentCode: diffRoot},
+			{Code: diffRoot, Name: diffRoot, Root: true},
+			{Code: "F", Name: "Failed", ParentCode: "A"},
+		},
+		Members: []SnapshotMember{
+			{UserCode: "E2", UserName: "Bob", OrgCode: "B1"},
+			{UserCode: "E1", UserName: "Alice", OrgCode: "B"},
+		},
+	}
+
+	plan := DiffSyncState(prev, desired, DiffOptions{})
+
+	var creates, updates, deletes, members []string
+	for _, change := range plan.OrgCreates {
+		creates = append(creates, change.Org.Code)
+	}
+	for _, change := range plan.OrgUpdates {
+		updates = append(updates, change.Org.Code+":"+ActionName(change.Action))
+	}
+	for _, change := range plan.OrgDeletes {
+		deletes = append(deletes, change.Org.Code)
+	}
+	for _, change := range plan.Members {
+		members = append(members, change.Member.UserCode+":"+ActionName(change.Action))
+	}
+
+	expect := func(name string, got, want []string) {
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: got %v, want %v", name, got, want)
+		}
+	}
+	// F上次创建失败，重新创建，不删除
+	expect("creates", creates, []string{"B", "B1", "F"})
+	expect("updates", updates, []string{"A:update"})
+	expect("deletes", deletes, []string{"X2", "X1", "X"})
+	// E9上次创建失败，不删除
+	expect("members", members, []string{"E1:move", "E2:create", "E3:delete"})
+
+	if plan.OrgCreates[2].Org.FatherId != "a" || plan.OrgCreates[0].Org.OrgId != "o" {
+		t.Error("creates should use the applied ids: ", plan.OrgCreates)
+	}
+	if plan.Members[0].Member.Id != "u1" || plan.Members[0].Member.DepId != "" || plan.Members[0].Old.DepId != "a" {
+		t.Error("moved member should keep its id and get the new department id: ", plan.Members[0])
+	}
+}
+
+// 直属上级为所在部门的负责人，本人是负责人时向上查找，新建的人员没有直属上级时不需要更新
+func TestDiffSyncStateManager(t *testing.T) {
+	prev := SyncState{
+		Orgs: []SnapshotOrg{
+			{Code: diffRoot, Name: diffRoot, Root: true, OrgId: "o"},
+			{Code: "A", Name: "A", ParentCode: diffRoot, OrgId: "o", DepId: "a"},
+			{Code: "A1", Name: "A1", ParentCode: "A", OrgId: "o", DepId: "a1"},
+		},
+		Members: []SnapshotMember{
+			{UserCode: "E1", UserName: "Alice", OrgCode: "A", Id: "u1", OrgId: "o", DepId: "a"},
+			{UserCode: "E2", UserName: "Bob", OrgCode: "A1", Id: "u2", OrgId: "o", DepId: "a1", ManagerCode: "E1", ManagerId: "u1"},
+			{UserCode: "E3", UserName: "Carol", OrgCode: "A1", Id: "u3", OrgId: "o", DepId: "a1", ManagerCode: "E1", ManagerId: "u1"},
+		},
+	}
+	desired := SyncState{
+		Orgs: []SnapshotOrg{
+			{Code: diffRoot, Name: diffRoot, Root: true},
+			{Code: "A", Name: "A", ParentCode: diffRoot, LeaderCode: "E1"},
+			{Code: "A1", Name: "A1", ParentCode: "A", LeaderCode: "E2"},
+		},
+		Members: []SnapshotMember{
+			{UserCode: "E1", UserName: "Alice", OrgCode: "A"},
+			{UserCode: "E2", UserName: "Bob", OrgCode: "A1"},
+			{UserCode: "E3", UserName: "Carol", OrgCode: "A1"},
+			{UserCode: "E4", UserName: "Dave", OrgCode: "A"},
+		},
+	}
+
+	if plan := DiffSyncState(prev, desired, DiffOptions{}); len(plan.Members) != 1 || plan.Members[0].Action != 1<<0 {
+		t.Fatal("managers should not be compared unless enabled: ", plan.Members)
+	}
+
+	var members []string
+	plan := DiffSyncState(prev, desired, DiffOptions{Manager: true})
+	for _, change := range plan.Members {
+		members = append(members, change.Member.UserCode+":"+strconv.Itoa(change.Action)+":"+change.ManagerCode)
+	}
+	// E1是A的负责人，A以上没有负责人；E2是A1的负责人，向上查找到E1；E4新建时同时更新直属上级
+	if want := []string{"E3:16:E2", "E4:17:E1"}; !reflect.DeepEqual(members, want) {
+		t.Errorf("members: got %v, want %v", members, want)
+	}
+	if plan.Members[0].Member.ManagerCode != "E1" {
+		t.Error("resolved member should keep the applied manager: ", plan.Members[0].Member)
+	}
+}
+
+// 随机数据的比对满足所有性质
+func TestDiffSyncStateProperties(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		prev, desired := genPrevState(r), genDesiredState(r)
+		checkDiffProperties(t, r, prev, desired, DiffOptions{Leader: i%2 == 0, Manager: i%3 == 0})
+
+		// 没有变化时再次比对为空
+		if plan := DiffSyncState(desired, desired, DiffOptions{}); len(plan.OrgDeletes) > 0 || len(plan.OrgUpdates) > 0 {
+			t.Fatal("diff against itself should not update or delete: ", plan)
+		}
+	}
+}
+
+func FuzzDiffSyncState(f *testing.F) {
+	f.Add([]byte{0}, false, false)
+	f.Add([]byte{1, 2, 3, 4, 5, 6, 7, 8, 9}, true, false)
+	f.Add([]byte("OneAuth-Agent diff engine"), true, true)
+
+	f.Fuzz(func(t *testing.T, data []byte, leader, manager bool) {
+		source := &byteSource{data: data}
+		prev, desired := genPrevState(source), genDesiredState(source)
+		checkDiffProperties(t, rand.New(rand.NewSource(int64(len(data)))), prev, desired, DiffOptions{Leader: leader, Manager: manager})
+	})
+}
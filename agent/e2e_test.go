@@ -0,0 +1,507 @@
+package agent
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/CipherChina/OneAuth-Agent/mock"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	e2eAppKey    = "agent-appkey"
+	e2eAppSecret = "agent-appsecret"
+	e2eToken     = "Bearer e2e-token"
+	e2eRoot      = "Company"
+	e2eDefault   = "Default"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// 端到端测试环境，包含两个模拟服务
+type e2eEnv struct {
+	t       *testing.T
+	datapub *mock.Datapub
+	oneauth *mock.Oneauth
+	config  *Config
+	syncer  *Syncer
+}
+
+// 启动模拟服务并生成指向它们的agent配置
+func newE2EEnv(t *testing.T) *e2eEnv {
+	env := &e2eEnv{
+		t:       t,
+		datapub: mock.NewDatapub(e2eAppKey, e2eAppSecret),
+		oneauth: mock.NewOneauth(e2eToken),
+	}
+	t.Cleanup(env.datapub.Close)
+	t.Cleanup(env.oneauth.Close)
+
+	config := DefaultConfig()
+	config.System.Fiber = "4"
+	config.System.Snapshot.Path = filepath.Join(t.TempDir(), "snapshot.json")
+	config.System.Report.Path = filepath.Join(t.TempDir(), "reports")
+	config.Database.Host, config.Database.Port = env.datapub.HostPort()
+	config.Database.User.Appkey = e2eAppKey
+	config.Database.User.Appsecret = e2eAppSecret
+	config.Database.DefaultTree = e2eDefault
+	config.Database.Schedule = StringList{"every 1h"}
+	config.Oneauth.Token = e2eToken
+	config.Oneauth.RootName = e2eRoot
+	config.Oneauth.Upstream.Ssl = "false"
+	config.Oneauth.Upstream.Host, config.Oneauth.Upstream.Port = env.oneauth.HostPort()
+	config.Oneauth.Retry = RetryInfo{Attempts: 3, Backoff: "1ms", MaxBackoff: "5ms"}
+	if err := config.Init(); err != nil {
+		t.Fatal("config init: ", err)
+	}
+	env.config = config
+
+	return env
+}
+
+// 模拟进程启动后加载基准数据
+func (env *e2eEnv) start() {
+	env.t.Helper()
+	env.syncer = NewDefaultSyncer(env.config)
+	if err := env.syncer.LoadBaseline(context.Background()); err != nil {
+		env.t.Fatal("load baseline: ", err)
+	}
+}
+
+// 模拟进程重启，丢弃内存中的数据
+func (env *e2eEnv) restart() {
+	env.t.Helper()
+	env.start()
+}
+
+func (env *e2eEnv) sync() {
+	env.t.Helper()
+	if err := env.syncer.Sync(context.Background(), "test"); err != nil {
+		env.t.Fatal("sync: ", err)
+	}
+
+	status, _, _ := env.syncer.GetSyncStatus()
+	if len(status.Errors) > 0 {
+		env.t.Fatal("sync task errors: ", status.Errors)
+	}
+}
+
+// oneauth中的部门树，key为部门外部编码，value为父级外部编码/部门名
+func (env *e2eEnv) tree() map[string]string {
+	tree := make(map[string]string)
+	for _, dep := range env.oneauth.Departments() {
+		tree[dep.OriginId] = env.oneauth.OriginId(dep.ParentId) + "/" + dep.Name
+	}
+	return tree
+}
+
+// oneauth中的人员，key为工号，value为姓名|账号|邮箱|部门外部编码
+func (env *e2eEnv) users() map[string]string {
+	users := make(map[string]string)
+	for _, user := range env.oneauth.Users() {
+		dep := user.DepId
+		if len(dep) == 0 {
+			dep = user.OrgId
+		}
+		users[user.EmployeeId] = strings.Join([]string{user.DisplayName, user.Account, user.Email, env.oneauth.OriginId(dep)}, "|")
+	}
+	return users
+}
+
+func (env *e2eEnv) assertTree(want map[string]string) {
+	env.t.Helper()
+	if got := env.tree(); !reflect.DeepEqual(got, want) {
+		env.t.Fatalf("oneauth tree\n got: %v\nwant: %v", got, want)
+	}
+}
+
+func (env *e2eEnv) assertUsers(want map[string]string) {
+	env.t.Helper()
+	if got := env.users(); !reflect.DeepEqual(got, want) {
+		env.t.Fatalf("oneauth users\n got: %v\nwant: %v", got, want)
+	}
+}
+
+func (env *e2eEnv) assertNoWrites() {
+	env.t.Helper()
+	if writes := env.oneauth.Writes(); len(writes) > 0 {
+		sort.Strings(writes)
+		env.t.Fatal("unexpected oneauth writes: ", writes)
+	}
+}
+
+func org(code, name, parent string) mock.Org {
+	return mock.Org{OrgUnitCode: code, OrgUnitName: name, UpperOrgUnitCode: parent, Status: "1", UpdateDate: "2024-01-01 00:00:00"}
+}
+
+func emp(code, name, oaid, orgCode string) mock.Emp {
+	return mock.Emp{UserCode: code, UserName: name, OAID: oaid, Email: oaid + "@example.com", OrgCode: orgCode, Status: "1",
+		UpdateDate: "2024-01-01 00:00:00"}
+}
+
+func baseOrgs() []mock.Org {
+	return []mock.Org{
+		org("A", "Sales", ""),
+		org("A1", "Sales East", "A"),
+		org("A2", "Sales West", "A"),
+		org("B", "R&D", ""),
+		org("B1", "Platform", "B"),
+	}
+}
+
+func baseEmps() []mock.Emp {
+	return []mock.Emp{
+		emp("E1", "Alice", "alice", "A"),
+		emp("E2", "Bob", "bob", "A1"),
+		emp("E3", "Carol", "carol", "A2"),
+		emp("E4", "Dave", "dave", "B1"),
+		emp("E5", "Eve", "eve", "B1"),
+	}
+}
+
+var baseTree = map[string]string{
+	"A":        e2eRoot + "/Sales",
+	"A1":       "A/Sales East",
+	"A2":       "A/Sales West",
+	"B":        e2eRoot + "/R&D",
+	"B1":       "B/Platform",
+	e2eDefault: e2eRoot + "/" + e2eDefault,
+}
+
+var baseUsers = map[string]string{
+	"E1": "Alice|alice|alice@example.com|A",
+	"E2": "Bob|bob|bob@example.com|A1",
+	"E3": "Carol|carol|carol@example.com|A2",
+	"E4": "Dave|dave|dave@example.com|B1",
+	"E5": "Eve|eve|eve@example.com|B1",
+}
+
+func copyMap(src map[string]string) map[string]string {
+	dst := make(map[string]string, len(src))
+	for k, v := range src {
+		dst[k] = v
+	}
+	return dst
+}
+
+// 空的oneauth第一次同步后和主数据一致，再次同步不产生写操作
+func TestE2EInitialSync(t *testing.T) {
+	env := newE2EEnv(t)
+	env.datapub.SetOrgs(baseOrgs())
+	env.datapub.SetEmps(baseEmps())
+
+	env.start()
+	env.sync()
+
+	if orgs := env.oneauth.Orgs(); len(orgs) != 1 || orgs[0].OriginId != e2eRoot || orgs[0].Name != e2eRoot {
+		t.Fatal("unexpected oneauth roots: ", orgs)
+	}
+	env.assertTree(baseTree)
+	env.assertUsers(baseUsers)
+
+	env.oneauth.ResetRequests()
+	env.sync()
+	env.assertNoWrites()
+}
+
+// 主数据变更后同步，oneauth最终状态和主数据一致
+func TestE2EScriptedChanges(t *testing.T) {
+	env := newE2EEnv(t)
+	env.datapub.SetOrgs(baseOrgs())
+	env.datapub.SetEmps(baseEmps())
+	env.start()
+	env.sync()
+
+	// 改名、移动到已有部门、移动到新建部门下、删除部门
+	env.datapub.SetOrgs([]mock.Org{
+		org("A", "Sales & Marketing", ""),
+		org("A1", "Sales East", "B"),
+		org("C", "Operations", ""),
+		org("A2", "Sales West", "C"),
+		org("B", "R&D", ""),
+	})
+	// 新增、修改、移动和删除人员
+	env.datapub.SetEmps([]mock.Emp{
+		emp("E1", "Alice", "alice", "A"),
+		emp("E2", "Bob", "bob", "A1"),
+		emp("E3", "Carol Smith", "carol", "A2"),
+		emp("E4", "Dave", "dave", "C"),
+		emp("E6", "Frank", "frank", "C"),
+	})
+	env.sync()
+
+	tree := map[string]string{
+		"A":        e2eRoot + "/Sales & Marketing",
+		"A1":       "B/Sales East",
+		"A2":       "C/Sales West",
+		"B":        e2eRoot + "/R&D",
+		"C":        e2eRoot + "/Operations",
+		e2eDefault: e2eRoot + "/" + e2eDefault,
+	}
+	env.assertTree(tree)
+	users := map[string]string{
+		"E1": "Alice|alice|alice@example.com|A",
+		"E2": "Bob|bob|bob@example.com|A1",
+		"E3": "Carol Smith|carol|carol@example.com|A2",
+		"E4": "Dave|dave|dave@example.com|C",
+		"E6": "Frank|frank|frank@example.com|C",
+	}
+	env.assertUsers(users)
+
+	// 移回顶层
+	env.datapub.SetOrgs([]mock.Org{
+		org("A", "Sales & Marketing", ""),
+		org("A1", "Sales East", ""),
+		org("C", "Operations", ""),
+		org("A2", "Sales West", "C"),
+		org("B", "R&D", ""),
+	})
+	env.sync()
+
+	tree["A1"] = e2eRoot + "/Sales East"
+	env.assertTree(tree)
+	env.assertUsers(users)
+
+	env.oneauth.ResetRequests()
+	env.sync()
+	env.assertNoWrites()
+}
+
+// 重启后从快照或oneauth加载基准数据，都不会产生多余的写操作
+func TestE2ERestart(t *testing.T) {
+	env := newE2EEnv(t)
+	env.datapub.SetOrgs(baseOrgs())
+	env.datapub.SetEmps(baseEmps())
+	env.start()
+	env.sync()
+
+	// 从快照恢复
+	env.restart()
+	env.oneauth.ResetRequests()
+	env.sync()
+	env.assertNoWrites()
+
+	// 快照不存在时从oneauth读取
+	os.Remove(env.config.System.Snapshot.Path)
+	env.restart()
+	env.oneauth.ResetRequests()
+	env.sync()
+	env.assertNoWrites()
+	env.assertTree(baseTree)
+	env.assertUsers(baseUsers)
+
+	// 从oneauth读取基准数据后的变更
+	os.Remove(env.config.System.Snapshot.Path)
+	env.restart()
+	env.datapub.SetOrgs(append(baseOrgs()[:4], org("B1", "Platform Team", "A")))
+	env.sync()
+
+	tree := copyMap(baseTree)
+	tree["B1"] = "A/Platform Team"
+	env.assertTree(tree)
+	env.assertUsers(baseUsers)
+}
+
+// 下级部门排在上级部门之前时，上级部门使用本部门数据中的名字
+func TestE2EChildListedBeforeParent(t *testing.T) {
+	env := newE2EEnv(t)
+	env.datapub.SetOrgs([]mock.Org{
+		org("A", "Sales", ""),
+		org("A11", "Key Accounts", "A1"),
+		org("A1", "Sales East", "A"),
+		org("B", "R&D", ""),
+	})
+	env.start()
+	env.sync()
+
+	env.assertTree(map[string]string{
+		"A":        e2eRoot + "/Sales",
+		"A1":       "A/Sales East",
+		"A11":      "A1/Key Accounts",
+		"B":        e2eRoot + "/R&D",
+		e2eDefault: e2eRoot + "/" + e2eDefault,
+	})
+}
+
+// 顶层部门排在下级部门之后时，仍然创建在根节点下
+func TestE2ETopLevelListedAfterChildren(t *testing.T) {
+	env := newE2EEnv(t)
+	env.datapub.SetOrgs([]mock.Org{
+		org("A1", "Sales East", "A"),
+		org("A2", "Sales West", "A"),
+		org("A", "Sales", ""),
+		org("B1", "Platform", "B"),
+		org("B", "R&D", ""),
+	})
+	env.datapub.SetEmps(baseEmps())
+	env.start()
+	env.sync()
+
+	env.assertTree(baseTree)
+	env.assertUsers(baseUsers)
+}
+
+// 部门移动到已有部门下，基准数据来自快照或oneauth时都使用新的上级部门
+func TestE2EMoveOrg(t *testing.T) {
+	env := newE2EEnv(t)
+	env.datapub.SetOrgs(baseOrgs())
+	env.datapub.SetEmps(baseEmps())
+	env.start()
+	env.sync()
+
+	env.datapub.SetOrgs([]mock.Org{
+		org("A", "Sales", ""),
+		org("A2", "Sales West", "A"),
+		org("B", "R&D", ""),
+		org("B1", "Platform", "B"),
+		org("A1", "Sales East", "B"),
+	})
+	env.sync()
+
+	tree := copyMap(baseTree)
+	tree["A1"] = "B/Sales East"
+	env.assertTree(tree)
+	env.assertUsers(baseUsers)
+
+	// 快照不存在时从oneauth读取基准数据
+	os.Remove(env.config.System.Snapshot.Path)
+	env.restart()
+	env.datapub.SetOrgs(baseOrgs())
+	env.sync()
+
+	env.assertTree(baseTree)
+	env.assertUsers(baseUsers)
+}
+
+// oneauth中已有的数据会被对齐到主数据
+func TestE2EExistingOneauthData(t *testing.T) {
+	env := newE2EEnv(t)
+	orgId := env.oneauth.AddOrg(e2eRoot, e2eRoot)
+	a := env.oneauth.AddDepartment(orgId, "", "Old Sales", "A")
+	env.oneauth.AddDepartment(orgId, a, "Obsolete", "X")
+	env.oneauth.AddUser(mock.OneauthUser{Account: "alice", DisplayName: "Alice", Email: "old@example.com", EmployeeId: "E1",
+		OrgId: orgId, DepId: a, Status: 1})
+	env.oneauth.AddUser(mock.OneauthUser{Account: "zed", DisplayName: "Zed", EmployeeId: "E9", OrgId: orgId, DepId: a, Status: 1})
+
+	env.datapub.SetOrgs(baseOrgs())
+	env.datapub.SetEmps(baseEmps())
+	env.start()
+	env.sync()
+
+	env.assertTree(baseTree)
+	env.assertUsers(baseUsers)
+	if orgs := env.oneauth.Orgs(); len(orgs) != 1 {
+		t.Fatal("root should be reused, got: ", orgs)
+	}
+}
+
+// 删除数量超过阈值时放弃同步，oneauth数据保持不变
+func TestE2ESafetyAbort(t *testing.T) {
+	env := newE2EEnv(t)
+	env.config.Database.Safety.MaxUserDeletePercent = 50
+	env.datapub.SetOrgs(baseOrgs())
+	env.datapub.SetEmps(baseEmps())
+	env.start()
+	env.sync()
+
+	env.datapub.SetEmps(baseEmps()[:1])
+	env.oneauth.ResetRequests()
+	err := env.syncer.Sync(context.Background(), "test")
+	if _, ok := err.(*SafetyError); !ok {
+		t.Fatal("expected safety error, got: ", err)
+	}
+	env.assertNoWrites()
+	env.assertUsers(baseUsers)
+}
+
+// 签名错误时主数据接口拒绝请求，同步失败
+func TestE2EDatapubSignCheck(t *testing.T) {
+	env := newE2EEnv(t)
+	env.datapub.SetOrgs(baseOrgs())
+	env.datapub.SetEmps(baseEmps())
+	env.start()
+
+	env.config.Database.User.Appsecret = "wrong-secret"
+	env.syncer.Source = NewDatapubSource(&env.config.Database)
+	if err := env.syncer.Sync(context.Background(), "test"); err == nil {
+		t.Fatal("sync with wrong sign should fail")
+	}
+	if users := env.oneauth.Users(); len(users) != 0 {
+		t.Fatal("no user should be created, got: ", users)
+	}
+}
+
+// oneauth临时故障时重试后同步成功
+func TestE2ERetryTransientFailure(t *testing.T) {
+	env := newE2EEnv(t)
+	env.datapub.SetOrgs(baseOrgs())
+	env.datapub.SetEmps(baseEmps())
+	env.oneauth.FailNext(http.MethodPost, "/api/v1/account/user", http.StatusServiceUnavailable, 2)
+	env.oneauth.FailNext(http.MethodPut, "/api/v1/account/org/", http.StatusBadGateway, 1)
+
+	env.start()
+	env.sync()
+	env.assertTree(baseTree)
+	env.assertUsers(baseUsers)
+}
+
+// 部门负责人同步为部门主管和人员直属上级
+func TestE2ELeaders(t *testing.T) {
+	env := newE2EEnv(t)
+	env.config.Database.Leader = LeaderInfo{Department: true, User: true}
+
+	orgs := baseOrgs()
+	orgs[0].LeaderCode = "E1"
+	orgs[4].LeaderCode = "E4"
+	env.datapub.SetOrgs(orgs)
+	env.datapub.SetEmps(baseEmps())
+	env.start()
+	env.sync()
+
+	managers := func() (map[string]string, map[string]string) {
+		deps := make(map[string]string)
+		for _, dep := range env.oneauth.Departments() {
+			deps[dep.OriginId] = env.oneauth.EmployeeId(dep.ManagerId)
+		}
+		users := make(map[string]string)
+		for _, user := range env.oneauth.Users() {
+			users[user.EmployeeId] = env.oneauth.EmployeeId(user.ManagerId)
+		}
+		return deps, users
+	}
+
+	deps, users := managers()
+	wantDeps := map[string]string{"A": "E1", "A1": "", "A2": "", "B": "", "B1": "E4", e2eDefault: ""}
+	wantUsers := map[string]string{"E1": "", "E2": "E1", "E3": "E1", "E4": "", "E5": "E4"}
+	if !reflect.DeepEqual(deps, wantDeps) || !reflect.DeepEqual(users, wantUsers) {
+		t.Fatalf("managers\n got: %v %v\nwant: %v %v", deps, users, wantDeps, wantUsers)
+	}
+
+	env.oneauth.ResetRequests()
+	env.sync()
+	env.assertNoWrites()
+
+	// 负责人变更
+	orgs[0].LeaderCode = "E2"
+	orgs[4].LeaderCode = ""
+	env.datapub.SetOrgs(orgs)
+	env.sync()
+
+	deps, users = managers()
+	wantDeps["A"], wantDeps["B1"] = "E2", ""
+	wantUsers = map[string]string{"E1": "E2", "E2": "", "E3": "E2", "E4": "", "E5": ""}
+	if !reflect.DeepEqual(deps, wantDeps) || !reflect.DeepEqual(users, wantUsers) {
+		t.Fatalf("managers\n got: %v %v\nwant: %v %v", deps, users, wantDeps, wantUsers)
+	}
+}
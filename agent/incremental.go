@@ -0,0 +1,147 @@
+package agent
+
+import (
+	"context"
+	"time"
+)
+
+// 判断本次是否需要全量同步
+func (s *Syncer) IsFullSyncDue(now time.Time) bool {
+	incremental := s.Config.Database.Incremental
+	if !incremental.Enable {
+		return true
+	}
+
+	// 没有上一次的全量数据时无法合并增量
+	if s.sourceOrgsBak == nil || s.sourceEmpsBak == nil || s.lastFullSyncBak.IsZero() {
+		return true
+	}
+
+	return incremental.FullIntervalDuration > 0 && now.Sub(s.lastFullSyncBak) >= incremental.FullIntervalDuration
+}
+
+// 获取组织架构数据中updateDate的最大值
+func MaxOrgUpdateDate(orgs []DataApiOrgNode) string {
+	var max string
+	for _, org := range orgs {
+		if org.UpdateDate > max {
+			max = org.UpdateDate
+		}
+	}
+	return max
+}
+
+// 获取人员数据中updateDate的最大值
+func MaxEmpUpdateDate(emps []DataApiEmpNode) string {
+	var max string
+	for _, emp := range emps {
+		if emp.UpdateDate > max {
+			max = emp.UpdateDate
+		}
+	}
+	return max
+}
+
+// 将updateDate不早于watermark的组织变更合并到上一次的全量数据中
+// 与watermark同一秒的变更可能发生在上一次拉取之后，重复合并不影响结果
+func MergeOrgChanges(base, changes []DataApiOrgNode, watermark string) ([]DataApiOrgNode, int) {
+	merged := make([]DataApiOrgNode, len(base))
+	copy(merged, base)
+
+	index := make(map[string]int, len(merged))
+	for i, org := range merged {
+		index[org.OrgUnitCode] = i
+	}
+
+	count := 0
+	for _, org := range changes {
+		if org.UpdateDate < watermark {
+			continue
+		}
+
+		count++
+		if i, ok := index[org.OrgUnitCode]; ok {
+			merged[i] = org
+		} else {
+			index[org.OrgUnitCode] = len(merged)
+			merged = append(merged, org)
+		}
+	}
+
+	return merged, count
+}
+
+// 将updateDate不早于watermark的人员变更合并到上一次的全量数据中
+func MergeEmpChanges(base, changes []DataApiEmpNode, watermark string) ([]DataApiEmpNode, int) {
+	merged := make([]DataApiEmpNode, len(base))
+	copy(merged, base)
+
+	index := make(map[string]int, len(merged))
+	for i, emp := range merged {
+		index[emp.UserCode] = i
+	}
+
+	count := 0
+	for _, emp := range changes {
+		if emp.UpdateDate < watermark {
+			continue
+		}
+
+		count++
+		if i, ok := index[emp.UserCode]; ok {
+			merged[i] = emp
+		} else {
+			index[emp.UserCode] = len(merged)
+			merged = append(merged, emp)
+		}
+	}
+
+	return merged, count
+}
+
+// 拉取主数据，增量同步时只拉取变更数据并合并到上一次的全量数据中
+func (s *Syncer) FetchSourceData(ctx context.Context) ([]DataApiOrgNode, []DataApiEmpNode, error) {
+	s.fullSync = s.IsFullSyncDue(time.Now())
+
+	var orgSince, empSince string
+	if !s.fullSync {
+		orgSince = s.orgWatermarkBak
+		empSince = s.empWatermarkBak
+	}
+
+	// 获取所有组织
+	orgs, err := s.Source.FetchOrgs(ctx, orgSince)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 获取所有人员
+	emps, err := s.Source.FetchEmps(ctx, empSince)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !s.fullSync {
+		var orgChanges, empChanges int
+		orgs, orgChanges = MergeOrgChanges(s.sourceOrgsBak, orgs, s.orgWatermarkBak)
+		emps, empChanges = MergeEmpChanges(s.sourceEmpsBak, emps, s.empWatermarkBak)
+		s.log.Info("[incremental] merge changes since org ", s.orgWatermarkBak, ", emp ", s.empWatermarkBak,
+			", org changes: ", orgChanges, ", emp changes: ", empChanges)
+	} else {
+		s.log.Info("[incremental] full sync")
+	}
+
+	s.orgWatermark = MaxOrgUpdateDate(orgs)
+	s.empWatermark = MaxEmpUpdateDate(emps)
+
+	return orgs, emps, nil
+}
+
+// 同步成功后保存增量同步状态
+func (s *Syncer) RestoreIncrementalState() {
+	s.orgWatermarkBak = s.orgWatermark
+	s.empWatermarkBak = s.empWatermark
+	if s.fullSync {
+		s.lastFullSyncBak = time.Now()
+	}
+}
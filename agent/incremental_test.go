@@ -0,0 +1,80 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 与上一次水位同一秒的变更也会被合并，早于水位的数据被忽略
+func TestMergeChangesAtWatermark(t *testing.T) {
+	watermark := "2024-01-01 00:00:00"
+	orgs, count := MergeOrgChanges(
+		[]DataApiOrgNode{{OrgUnitCode: "A", OrgUnitName: "Sales", UpdateDate: watermark}},
+		[]DataApiOrgNode{
+			{OrgUnitCode: "A", OrgUnitName: "Sales Global", UpdateDate: watermark},
+			{OrgUnitCode: "B", OrgUnitName: "R&D", UpdateDate: "2023-12-31 23:59:59"},
+		}, watermark)
+	if count != 1 || len(orgs) != 1 || orgs[0].OrgUnitName != "Sales Global" {
+		t.Fatal("org change at watermark should be merged, got: ", count, orgs)
+	}
+
+	emps, count := MergeEmpChanges(
+		[]DataApiEmpNode{{UserCode: "E1", UserName: "Alice", UpdateDate: watermark}},
+		[]DataApiEmpNode{{UserCode: "E1", UserName: "Alice Smith", UpdateDate: watermark}}, watermark)
+	if count != 1 || len(emps) != 1 || emps[0].UserName != "Alice Smith" {
+		t.Fatal("emp change at watermark should be merged, got: ", count, emps)
+	}
+}
+
+// 增量同步拉取与水位同一秒的变更
+func TestIncrementalSyncAtWatermark(t *testing.T) {
+	env := newE2EEnv(t)
+	env.config.Database.Incremental = IncrementalInfo{Enable: true, Param: "since", FullIntervalDuration: time.Hour}
+	env.datapub.UpdateParam = "since"
+	env.datapub.SetOrgs(baseOrgs())
+	env.datapub.SetEmps(baseEmps())
+	env.start()
+	env.sync()
+
+	orgs := baseOrgs()
+	orgs[0].OrgUnitName = "Sales Global"
+	env.datapub.SetOrgs(orgs)
+	env.sync()
+
+	if env.syncer.fullSync {
+		t.Fatal("second sync should be incremental")
+	}
+	tree := copyMap(baseTree)
+	tree["A"] = e2eRoot + "/Sales Global"
+	env.assertTree(tree)
+}
+
+// 增量同步只拉取变更，不检查主数据总数的减少；之后的全量同步发现总数减少过多时中止
+func TestIncrementalSourceDrop(t *testing.T) {
+	env := newE2EEnv(t)
+	env.config.Database.Incremental = IncrementalInfo{Enable: true, Param: "since", FullIntervalDuration: time.Hour}
+	env.config.Database.Safety.MaxSourceDropPercent = 50
+	env.datapub.UpdateParam = "since"
+	env.datapub.SetOrgs(baseOrgs())
+	env.datapub.SetEmps(baseEmps())
+	env.start()
+	env.sync()
+
+	env.datapub.SetOrgs(baseOrgs()[:1])
+	env.datapub.SetEmps(baseEmps()[:1])
+	env.sync()
+	if env.syncer.fullSync {
+		t.Fatal("second sync should be incremental")
+	}
+	env.assertTree(baseTree)
+	env.assertUsers(baseUsers)
+
+	env.config.Database.Incremental.FullIntervalDuration = time.Nanosecond
+	err := env.syncer.Sync(context.Background(), "test")
+	if safetyErr, ok := err.(*SafetyError); !ok || !strings.Contains(safetyErr.Error(), "source orgs dropped from 5 to 1") {
+		t.Fatal("full sync should be aborted by source drop, got: ", err)
+	}
+	env.assertTree(baseTree)
+}
@@ -0,0 +1,95 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"sort"
+)
+
+// 主数据中部门的负责人工号
+func DesiredLeaderCode(node *DataOrgMemNode) string {
+	if node.Value == nil {
+		return ""
+	}
+
+	return node.Value.LeaderCode
+}
+
+// 直属上级的oneauth用户id，上级未同步到oneauth时返回空
+func (s *Syncer) managerId(code string) string {
+	if manager, ok := s.members[code]; ok {
+		return manager.Id
+	}
+
+	return ""
+}
+
+// 更新部门负责人，需要在人员同步完成后执行，保证负责人已有oneauth用户id
+func (s *Syncer) ProcessLeaderTaskQueue(ctx context.Context) {
+	var codes []string
+	for code, node := range s.orgMap {
+		if node.Action&(1<<4) != 0 {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+
+	s.log.Info("[oneauth] update org leader count: ", len(codes))
+
+	for _, code := range codes {
+		// 收到退出请求时不再执行剩余任务
+		if s.stopTask(ctx) {
+			break
+		}
+
+		node := s.orgMap[code]
+		// 部门未创建成功
+		if len(node.DepId) == 0 {
+			continue
+		}
+
+		leaderCode, managerId := DesiredLeaderCode(node), ""
+		if len(leaderCode) > 0 {
+			leader, ok := s.members[leaderCode]
+			if !ok || len(leader.Id) == 0 {
+				s.log.Warn("[oneauth] org [", node.NodeCode, ", ", node.NodeName, "] leader ", leaderCode, " not synced to oneauth")
+				s.RecordSyncResult("org", "leader", node.NodeCode, errors.New("org "+node.NodeCode+" leader "+leaderCode+" not synced to oneauth"))
+				continue
+			}
+			managerId = leader.Id
+		}
+
+		s.log.Debug("[oneauth] Update org leader: ", node.NodeCode, ", ", node.NodeName, ", ", node.LeaderCode, " -> ", leaderCode)
+		err := s.Target.SetDepartmentManager(ctx, node, managerId)
+		s.RecordSyncResult("org", "leader", node.NodeCode, err)
+		if err != nil {
+			continue
+		}
+
+		node.LeaderCode = leaderCode
+		node.ManagerId = managerId
+		node.Action &^= 1 << 4
+	}
+}
+
+// 生成直属上级更新任务队列，只保留已创建成功且直属上级已同步到oneauth的人员
+func (s *Syncer) CreateUserManagerTaskQueue(managers *Queue) *Queue {
+	queue := New()
+	for managers.Len() > 0 {
+		user := managers.Pop().(*DataApiEmpNode)
+		// 人员未创建成功
+		if len(user.Id) == 0 {
+			continue
+		}
+
+		if code := user.NewManager; len(code) > 0 && len(s.managerId(code)) == 0 {
+			s.log.Warn("[oneauth] user [", user.UserCode, ", ", user.UserName, "] manager ", code, " not synced to oneauth")
+			continue
+		}
+
+		user.Action = 1 << 4
+		queue.Push(user)
+	}
+
+	return queue
+}
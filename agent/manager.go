@@ -0,0 +1,69 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// 管理一个进程内的多个同步任务，每个任务有独立的数据和备份，同一任务不会重叠执行
+type Manager struct {
+	Config  *Config
+	Syncers []*Syncer
+
+	reloadLock sync.Mutex // 保证同一时间只有一次重新加载
+}
+
+// 为配置中的每个任务创建同步器，config需要已经通过Init初始化
+func NewManager(config *Config) *Manager {
+	m := &Manager{Config: config}
+
+	// 同一个oneauth租户的任务共享限流器，总请求速率不随任务数增加
+	limiters := make(map[string]map[string]*TokenBucket)
+	for _, job := range config.JobConfigs() {
+		// 单任务时任务配置就是顶层配置，同步器使用单独的副本，重新加载时两份配置分别由各自的锁保护
+		if job == config {
+			copied := *config
+			copied.jobs = []*Config{&copied}
+			job = &copied
+		}
+		syncer := NewDefaultSyncer(job)
+		if target, ok := syncer.Target.(*OneauthTarget); ok {
+			key := rateLimitKey(&job.Oneauth)
+			if _, ok := limiters[key]; !ok {
+				limiters[key] = target.limiters
+			}
+			target.limiters = limiters[key]
+		}
+		m.Syncers = append(m.Syncers, syncer)
+	}
+	return m
+}
+
+// 按任务名查找同步器，不存在时返回nil
+func (m *Manager) Job(name string) *Syncer {
+	for _, syncer := range m.Syncers {
+		if syncer.Name() == name {
+			return syncer
+		}
+	}
+	return nil
+}
+
+// 加载所有任务的比对基准数据
+func (m *Manager) LoadBaseline(ctx context.Context) error {
+	for _, syncer := range m.Syncers {
+		if err := syncer.LoadBaseline(ctx); err != nil {
+			return fmt.Errorf("job %s: %v", syncer.Name(), err)
+		}
+	}
+	return nil
+}
+
+// 按各任务自己的同步计划启动定时同步，任务之间互不等待
+func (m *Manager) Start() {
+	for _, syncer := range m.Syncers {
+		s := syncer
+		InitTimer(func() { s.Sync(context.Background(), "timer") }, s.Config.Database.Schedules)
+	}
+}
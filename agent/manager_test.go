@@ -0,0 +1,304 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 两个任务分别同步到两个oneauth，状态和快照互相独立
+func TestManagerJobs(t *testing.T) {
+	envs := []*e2eEnv{newE2EEnv(t), newE2EEnv(t)}
+	envs[0].datapub.SetOrgs(baseOrgs())
+	envs[0].datapub.SetEmps(baseEmps())
+	envs[1].datapub.SetOrgs(baseOrgs()[3:])
+	envs[1].datapub.SetEmps(baseEmps()[3:])
+
+	dir := t.TempDir()
+	yaml := fmt.Sprintf(`
+system:
+  fiber: "4"
+  snapshot:
+    path: %s
+  report:
+    path: %s
+  admin:
+    listen: 127.0.0.1:0
+    token: admin-token
+jobs:
+`, filepath.Join(dir, "snapshot.json"), filepath.Join(dir, "reports"))
+	for i, env := range envs {
+		dbHost, dbPort := env.datapub.HostPort()
+		oaHost, oaPort := env.oneauth.HostPort()
+		yaml += fmt.Sprintf(`  - name: bu%d
+    database:
+      host: %s
+      port: "%s"
+      user:
+        appkey: %s
+        appsecret: %s
+      defaulttree: %s
+      schedule: every 1h
+    oneauth:
+      token: %s
+      rootname: %s
+      upstream:
+        ssl: "false"
+        host: %s
+        port: "%s"
+      retry:
+        backoff: 1ms
+        maxbackoff: 5ms
+`, i+1, dbHost, dbPort, e2eAppKey, e2eAppSecret, e2eDefault, e2eToken, e2eRoot, oaHost, oaPort)
+	}
+
+	path := filepath.Join(dir, "OneAuth.yaml")
+	if err := ioutil.WriteFile(path, []byte(yaml), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal("load config: ", err)
+	}
+
+	manager := NewManager(config)
+	if len(manager.Syncers) != 2 || manager.Job("bu1") == nil || manager.Job("bu2") == nil {
+		t.Fatal("expected jobs bu1 and bu2, got: ", len(manager.Syncers))
+	}
+	if manager.Job("bu1").Config.Oneauth.Retry.Attempts != 3 {
+		t.Fatal("job config should use default retry attempts")
+	}
+	if p1, p2 := manager.Job("bu1").Config.System.Snapshot.Path, manager.Job("bu2").Config.System.Snapshot.Path; p1 == p2 {
+		t.Fatal("jobs should use different snapshot files: ", p1)
+	}
+
+	if err := manager.LoadBaseline(context.Background()); err != nil {
+		t.Fatal("load baseline: ", err)
+	}
+
+	var wg sync.WaitGroup
+	for _, syncer := range manager.Syncers {
+		wg.Add(1)
+		go func(s *Syncer) {
+			defer wg.Done()
+			if err := s.Sync(context.Background(), "test"); err != nil {
+				t.Error("sync ", s.Name(), ": ", err)
+			}
+		}(syncer)
+	}
+	wg.Wait()
+
+	envs[0].assertTree(baseTree)
+	envs[0].assertUsers(baseUsers)
+	envs[1].assertTree(map[string]string{"B": baseTree["B"], "B1": baseTree["B1"], e2eDefault: baseTree[e2eDefault]})
+	envs[1].assertUsers(map[string]string{"E4": baseUsers["E4"], "E5": baseUsers["E5"]})
+	for _, name := range []string{"bu1", "bu2"} {
+		if reports, _ := filepath.Glob(filepath.Join(dir, "reports", name+"-*.json")); len(reports) != 1 {
+			t.Fatal("each job should write its own report, got: ", reports)
+		}
+	}
+
+	admin := manager.AdminHandler()
+	request := func(method, url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		req.Header.Set("Authorization", "Bearer admin-token")
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := request(http.MethodGet, "/status")
+	var status struct {
+		Jobs map[string]map[string]interface{} `json:"jobs"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil || len(status.Jobs) != 2 {
+		t.Fatal("status should list both jobs, got: ", rec.Body.String())
+	}
+
+	if rec := request(http.MethodPost, "/sync"); rec.Code != http.StatusBadRequest {
+		t.Fatal("sync without job should be rejected, got: ", rec.Code)
+	}
+	if rec := request(http.MethodGet, "/plan?job=bu3"); rec.Code != http.StatusNotFound {
+		t.Fatal("plan of unknown job should be not found, got: ", rec.Code)
+	}
+	if rec := request(http.MethodGet, "/plan?job=bu2&format=text"); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), e2eRoot) {
+		t.Fatal("plan of bu2 failed: ", rec.Code, rec.Body.String())
+	}
+}
+
+// 任务名必须唯一，配置了jobs时不能再使用顶层的database和oneauth
+func TestConfigJobsValidation(t *testing.T) {
+	config := DefaultConfig()
+	config.Jobs = []JobConfig{{Name: "a"}, {Name: "a"}}
+	if err := config.Init(); err == nil || !strings.Contains(err.Error(), "duplicated") {
+		t.Fatal("duplicated job name should fail, got: ", err)
+	}
+
+	config = DefaultConfig()
+	config.Database.Host = "datapub"
+	config.Jobs = []JobConfig{{Name: "a"}}
+	if err := config.Init(); err == nil || !strings.Contains(err.Error(), "inside jobs") {
+		t.Fatal("top level database with jobs should fail, got: ", err)
+	}
+}
+
+// 同一个oneauth租户的任务共享限流器，限流配置不一致时报错
+func TestManagerSharedRateLimit(t *testing.T) {
+	job := func(name, host, rate string) string {
+		return fmt.Sprintf(`- name: %s
+  database:
+    host: datapub
+    port: "443"
+    user: {appkey: key, appsecret: secret}
+    defaulttree: Default
+  oneauth:
+    token: token
+    rootname: Root
+    upstream: {host: %s, port: "443"}
+    ratelimit:
+      create: {rate: %s, burst: 1}
+`, name, host, rate)
+	}
+
+	config, err := ParseConfig([]byte("jobs:\n" + job("a", "oneauth", "5") + job("b", "oneauth", "5") + job("c", "other", "5")))
+	if err != nil {
+		t.Fatal("parse config: ", err)
+	}
+	manager := NewManager(config)
+	limiters := func(name string) map[string]*TokenBucket {
+		return manager.Job(name).Target.(*OneauthTarget).limiters
+	}
+	if limiters("a")["create"] != limiters("b")["create"] {
+		t.Fatal("jobs on the same oneauth should share limiters")
+	}
+	if limiters("a")["create"] == limiters("c")["create"] {
+		t.Fatal("jobs on different oneauth should not share limiters")
+	}
+
+	_, err = ParseConfig([]byte("jobs:\n" + job("a", "oneauth", "5") + job("b", "oneauth", "10")))
+	if err == nil || !strings.Contains(err.Error(), "jobs[1].oneauth.ratelimit: must be the same as job a") {
+		t.Fatal("different rate limits on the same oneauth should fail, got: ", err)
+	}
+}
+
+// 等待令牌时被取消的请求归还令牌，不影响之后的同步
+func TestTokenBucketRefundOnCancel(t *testing.T) {
+	bucket := NewTokenBucket(1, 1)
+	if wait, err := bucket.Wait(context.Background()); err != nil || wait != 0 {
+		t.Fatal("first token should be available, got: ", wait, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := bucket.Wait(ctx); err != context.Canceled {
+		t.Fatal("wait should be cancelled, got: ", err)
+	}
+
+	// 没有归还时需要等待2秒
+	if wait := bucket.reserve(); wait > 1100*time.Millisecond {
+		t.Fatal("cancelled wait should refund its token, next wait: ", wait)
+	}
+}
+
+// 管理接口只接受带Bearer前缀的正确token，未配置token时拒绝所有请求，/metrics不需要token
+func TestAdminAuth(t *testing.T) {
+	env := newE2EEnv(t)
+	env.config.System.Admin.Token = "admin-token"
+	manager := &Manager{Config: env.config}
+	admin := manager.AdminHandler()
+
+	get := func(path, header string) int {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if len(header) > 0 {
+			req.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+		admin.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	status := func(header string) int {
+		return get("/status", header)
+	}
+
+	for header, code := range map[string]int{
+		"Bearer admin-token": http.StatusOK,
+		"admin-token":        http.StatusUnauthorized,
+		"Bearer other":       http.StatusUnauthorized,
+		"":                   http.StatusUnauthorized,
+	} {
+		if got := status(header); got != code {
+			t.Errorf("authorization %q: expected %d, got %d", header, code, got)
+		}
+	}
+
+	env.config.System.Admin.Token = ""
+	for _, header := range []string{"", "Bearer ", "Bearer"} {
+		if got := status(header); got != http.StatusUnauthorized {
+			t.Errorf("empty admin token should reject %q, got %d", header, got)
+		}
+	}
+	if got := get("/metrics", ""); got != http.StatusOK {
+		t.Error("metrics should not require the admin token, got: ", got)
+	}
+}
+
+// 创建人员的请求被取消后还需要一段时间才返回
+type slowStopTarget struct {
+	Target
+	stopDelay time.Duration
+	started   chan struct{}
+	once      sync.Once
+}
+
+func (t *slowStopTarget) CreateUser(ctx context.Context, user *DataApiEmpNode) (string, error) {
+	t.once.Do(func() { close(t.started) })
+	<-ctx.Done()
+	time.Sleep(t.stopDelay)
+	return "", ctx.Err()
+}
+
+// 超时取消后等待所有任务记录已完成的任务并保存快照，而不只是超时时正在等待的任务
+func TestManagerShutdownWaitsForAllJobs(t *testing.T) {
+	envs := []*e2eEnv{newE2EEnv(t), newE2EEnv(t)}
+	manager := &Manager{Config: envs[0].config}
+	targets := make([]*slowStopTarget, len(envs))
+	for i, env := range envs {
+		env.datapub.SetOrgs(baseOrgs())
+		env.datapub.SetEmps(baseEmps())
+		env.start()
+		targets[i] = &slowStopTarget{Target: env.syncer.Target, stopDelay: time.Duration(i*300+50) * time.Millisecond,
+			started: make(chan struct{})}
+		env.syncer.Target = targets[i]
+		manager.Syncers = append(manager.Syncers, env.syncer)
+	}
+
+	synced := make(chan error, len(envs))
+	for i, env := range envs {
+		go func(s *Syncer) { synced <- s.Sync(context.Background(), "test") }(env.syncer)
+		<-targets[i].started
+	}
+
+	if manager.Shutdown(50 * time.Millisecond) {
+		t.Fatal("shutdown should report the cancelled syncs as incomplete")
+	}
+	for i, syncer := range manager.Syncers {
+		marker, err := syncer.ReadSyncMarker()
+		if err != nil || marker == nil || !strings.Contains(marker.Error, "canceled") || !marker.Snapshot {
+			t.Fatal("job ", i, " should record the cancel and save the snapshot before shutdown returns: ", marker, err)
+		}
+	}
+	for range envs {
+		if err := <-synced; err == nil {
+			t.Fatal("cancelled sync should fail")
+		}
+	}
+}
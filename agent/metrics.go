@@ -0,0 +1,196 @@
+package agent
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// 请求耗时统计区间，单位秒
+var DefaultLatencyBuckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// 同步耗时统计区间，单位秒
+var SyncDurationBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600}
+
+// 同一指标下按标签区分的一组数据
+type metricSeries struct {
+	labelValues []string
+	value       float64
+	counts      []uint64 // 直方图每个区间的计数
+	sum         float64
+	count       uint64
+}
+
+// prometheus格式的指标，kind为counter、gauge或histogram
+type MetricVec struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*metricSeries
+}
+
+var metricsRegistry []*MetricVec
+
+func newMetric(kind, name, help string, buckets []float64, labels ...string) *MetricVec {
+	m := &MetricVec{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: make(map[string]*metricSeries)}
+	metricsRegistry = append(metricsRegistry, m)
+	return m
+}
+
+func (m *MetricVec) get(labelValues []string) *metricSeries {
+	key := strings.Join(labelValues, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &metricSeries{labelValues: append([]string(nil), labelValues...)}
+		if m.kind == "histogram" {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// 计数器加1
+func (m *MetricVec) Inc(labelValues ...string) {
+	m.Add(1, labelValues...)
+}
+
+func (m *MetricVec) Add(v float64, labelValues ...string) {
+	m.mu.Lock()
+	m.get(labelValues).value += v
+	m.mu.Unlock()
+}
+
+// 设置gauge的值
+func (m *MetricVec) Set(v float64, labelValues ...string) {
+	m.mu.Lock()
+	m.get(labelValues).value = v
+	m.mu.Unlock()
+}
+
+// 直方图记录一次观测值
+func (m *MetricVec) Observe(v float64, labelValues ...string) {
+	m.mu.Lock()
+	s := m.get(labelValues)
+	for i, bound := range m.buckets {
+		if v <= bound {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.count++
+	m.mu.Unlock()
+}
+
+func formatMetricValue(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func formatMetricLabels(names, values []string, extraName, extraValue string) string {
+	var pairs []string
+	for i, name := range names {
+		pairs = append(pairs, name+"=\""+escapeLabelValue(values[i])+"\"")
+	}
+	if len(extraName) > 0 {
+		pairs = append(pairs, extraName+"=\""+extraValue+"\"")
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func escapeLabelValue(v string) string {
+	v = strings.Replace(v, `\`, `\\`, -1)
+	v = strings.Replace(v, `"`, `\"`, -1)
+	return strings.Replace(v, "\n", `\n`, -1)
+}
+
+// 按prometheus文本格式输出指标
+func (m *MetricVec) WriteText(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := m.series[key]
+		if m.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, formatMetricLabels(m.labels, s.labelValues, "", ""), formatMetricValue(s.value))
+			continue
+		}
+
+		for i, bound := range m.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatMetricLabels(m.labels, s.labelValues, "le", formatMetricValue(bound)), s.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, formatMetricLabels(m.labels, s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, formatMetricLabels(m.labels, s.labelValues, "", ""), formatMetricValue(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, formatMetricLabels(m.labels, s.labelValues, "", ""), s.count)
+	}
+}
+
+// 同步任务相关指标
+var MetricSyncTasks = newMetric("counter", "oneauth_agent_sync_tasks_total",
+	"Org and user tasks executed against OneAuth.", nil, "job", "kind", "action", "result")
+var MetricSyncRuns = newMetric("counter", "oneauth_agent_sync_runs_total",
+	"Sync runs by result.", nil, "job", "result")
+var MetricSyncDuration = newMetric("histogram", "oneauth_agent_sync_duration_seconds",
+	"Duration of sync runs.", SyncDurationBuckets, "job")
+var MetricTaskDuration = newMetric("histogram", "oneauth_agent_task_duration_seconds",
+	"Duration of single org and user tasks including retries.", DefaultLatencyBuckets, "job", "kind", "action")
+var MetricUserConcurrency = newMetric("gauge", "oneauth_agent_user_task_concurrency",
+	"Current number of user tasks allowed to run concurrently.", nil, "job")
+var MetricSyncRunning = newMetric("gauge", "oneauth_agent_sync_running",
+	"Whether a sync is currently running.", nil, "job")
+var MetricLastSuccess = newMetric("gauge", "oneauth_agent_last_success_timestamp_seconds",
+	"Unix time of the last successful sync.", nil, "job")
+
+// 主数据相关指标
+var MetricSourceCount = newMetric("gauge", "oneauth_agent_source_items",
+	"Orgs and users returned by the source, total and valid after filtering.", nil, "job", "kind", "stage")
+var MetricSourceLatency = newMetric("histogram", "oneauth_agent_source_request_duration_seconds",
+	"Latency of master data API requests.", DefaultLatencyBuckets, "endpoint")
+var MetricSourceRequests = newMetric("counter", "oneauth_agent_source_requests_total",
+	"Master data API requests by response code.", nil, "endpoint", "code")
+
+// oneauth接口相关指标
+var MetricUpstreamLatency = newMetric("histogram", "oneauth_agent_upstream_request_duration_seconds",
+	"Latency of OneAuth API requests.", DefaultLatencyBuckets, "endpoint")
+var MetricUpstreamRequests = newMetric("counter", "oneauth_agent_upstream_requests_total",
+	"OneAuth API requests by response code.", nil, "endpoint", "code")
+var MetricRateLimitWait = newMetric("histogram", "oneauth_agent_ratelimit_wait_seconds",
+	"Time spent waiting for the client-side rate limiter.", DefaultLatencyBuckets, "class")
+
+// GET /metrics 输出prometheus格式指标
+func MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	for _, m := range metricsRegistry {
+		m.WriteText(w)
+	}
+}
+
+// 获取响应码标签，请求未得到响应时为error
+func metricCode(code int) string {
+	if code == 0 {
+		return "error"
+	}
+	return strconv.Itoa(code)
+}
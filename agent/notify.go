@@ -0,0 +1,311 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// 通知事件
+const (
+	NotifyFailure = "failure" // 同步失败或被中断
+	NotifyPartial = "partial" // 同步完成，但失败的任务数超过maxerrors
+	NotifySafety  = "safety"  // 删除数量超过阈值，同步被中止
+)
+
+// 通知中最多列出的失败任务数，完整列表见同步报告
+const maxNotifyFailures = 20
+
+// webhook请求头，签名为请求体的HMAC-SHA256，格式为sha256=十六进制
+const (
+	WebhookEventHeader     = "X-OneAuth-Event"
+	WebhookSignatureHeader = "X-OneAuth-Signature"
+)
+
+// 一次通知的内容，同时作为webhook的请求体和通知模板的数据
+type Notification struct {
+	Event       string                    `json:"event"`
+	Job         string                    `json:"job"`
+	Trigger     string                    `json:"trigger"`
+	StartTime   time.Time                 `json:"startTime"`
+	EndTime     time.Time                 `json:"endTime"`
+	Error       string                    `json:"error,omitempty"`
+	FailedTasks int                       `json:"failedTasks"`
+	Planned     map[string]map[string]int `json:"planned"`
+	Applied     map[string]map[string]int `json:"applied"`
+	Failed      map[string]map[string]int `json:"failed"`
+	Failures    []ReportFailure           `json:"failures,omitempty"` // 最多maxNotifyFailures条
+	Report      string                    `json:"report,omitempty"`   // 同步报告文件路径
+	Summary     string                    `json:"summary"`            // 按模板生成的文本
+}
+
+// 标题
+func (n *Notification) Subject() string {
+	return "[OneAuth-Agent] sync " + n.Event + ": " + n.Job
+}
+
+// 通知渠道
+type Notifier interface {
+	Notify(ctx context.Context, n *Notification) error
+}
+
+// 解析通知模板，模板数据为Notification
+func ParseNotifyTemplate(text string) (*template.Template, error) {
+	return template.New("notify").Funcs(template.FuncMap{
+		"time":    func(t time.Time) string { return t.Format("2006-01-02 15:04:05") },
+		"kinds":   func() []string { return []string{"org", "user"} },
+		"actions": func() []string { return PlanActions },
+	}).Option("missingkey=zero").Parse(text)
+}
+
+var defaultNotifyTemplate = template.Must(ParseNotifyTemplate(`OneAuth sync {{.Event}}, job: {{.Job}}
+Trigger: {{.Trigger}}
+Start:   {{time .StartTime}}
+End:     {{time .EndTime}}
+{{- if .Error}}
+Error:   {{.Error}}
+{{- end}}
+Failed tasks: {{.FailedTasks}}
+{{- $n := .}}
+{{range $kind := kinds}}{{range $action := actions}}{{with index $n.Planned $kind $action}}
+{{$kind}} {{$action}}: planned {{.}}, applied {{index $n.Applied $kind $action}}, failed {{index $n.Failed $kind $action}}
+{{- end}}{{end}}{{end}}
+{{- if .Failures}}
+
+Failures:
+{{- range .Failures}}
+  {{.Kind}} {{.Action}} {{.Code}}: {{.Error}}
+{{- end}}
+{{- if gt .FailedTasks (len .Failures)}}
+  ... {{.FailedTasks}} failures in total
+{{- end}}
+{{- end}}
+{{- if .Report}}
+
+Report: {{.Report}}
+{{- end}}
+`))
+
+// 判断本次同步是否需要通知，返回通知事件，不需要通知时返回空
+func (s *Syncer) notifyEvent(report *SyncReport, err error) string {
+	var safetyErr *SafetyError
+	switch {
+	case errors.As(err, &safetyErr):
+		return NotifySafety
+	case errors.Is(err, ErrSyncInterrupted) && s.Stopping():
+		// 进程退出导致的中断不通知，下次启动时会补齐
+		return ""
+	case err != nil:
+		return NotifyFailure
+	case len(report.Failures) > s.Config.System.Notify.MaxErrors:
+		return NotifyPartial
+	}
+	return ""
+}
+
+// 根据同步报告生成通知内容
+func (s *Syncer) BuildNotification(event string, report *SyncReport, reportPath string) (*Notification, error) {
+	n := &Notification{
+		Event:       event,
+		Job:         report.Job,
+		Trigger:     report.Trigger,
+		StartTime:   report.StartTime,
+		EndTime:     report.EndTime,
+		Error:       report.Error,
+		FailedTasks: len(report.Failures),
+		Planned:     report.Planned,
+		Applied:     report.Applied,
+		Failed:      report.Failed,
+		Failures:    report.Failures,
+		Report:      reportPath,
+	}
+	if len(n.Failures) > maxNotifyFailures {
+		n.Failures = n.Failures[:maxNotifyFailures]
+	}
+
+	tmpl := s.Config.System.Notify.template
+	if tmpl == nil {
+		tmpl = defaultNotifyTemplate
+	}
+	var summary bytes.Buffer
+	if err := tmpl.Execute(&summary, n); err != nil {
+		return nil, err
+	}
+	n.Summary = summary.String()
+	return n, nil
+}
+
+// 按配置生成通知渠道
+func NewNotifiers(config *NotifyInfo) []Notifier {
+	var notifiers []Notifier
+	if len(config.Webhook.Url) > 0 {
+		notifiers = append(notifiers, &WebhookNotifier{Config: &config.Webhook})
+	}
+	if len(config.Smtp.Host) > 0 {
+		notifiers = append(notifiers, &SmtpNotifier{Config: &config.Smtp})
+	}
+	return notifiers
+}
+
+// 同步失败、被删除保护中止或失败的任务过多时发送通知，每个渠道单独计算超时
+func (s *Syncer) NotifySyncResult(report *SyncReport, reportPath string, err error) {
+	notifiers := NewNotifiers(&s.Config.System.Notify)
+	if len(notifiers) == 0 {
+		return
+	}
+
+	event := s.notifyEvent(report, err)
+	if len(event) == 0 {
+		return
+	}
+
+	n, buildErr := s.BuildNotification(event, report, reportPath)
+	if buildErr != nil {
+		s.log.Error("[notify] render notification error: ", buildErr)
+		return
+	}
+
+	for _, notifier := range notifiers {
+		ctx, cancel := context.WithTimeout(context.Background(), s.Config.System.Notify.TimeoutDuration)
+		if err := notifier.Notify(ctx, n); err != nil {
+			s.log.Error("[notify] send ", event, " notification error: ", err)
+		} else {
+			s.log.Info("[notify] ", event, " notification sent by ", fmt.Sprintf("%T", notifier))
+		}
+		cancel()
+	}
+}
+
+// 以json格式POST通知到webhook
+type WebhookNotifier struct {
+	Config *WebhookInfo
+	Client *http.Client // 为空时使用http.DefaultClient
+}
+
+// 计算webhook请求体的签名
+func WebhookSignature(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (w *WebhookNotifier) Notify(ctx context.Context, n *Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.Config.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookEventHeader, n.Event)
+	if len(w.Config.Secret) > 0 {
+		req.Header.Set(WebhookSignatureHeader, WebhookSignature(string(w.Config.Secret), body))
+	}
+
+	client := w.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		rspBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("webhook response code: %d, rspbody: %s", resp.StatusCode, rspBody)
+	}
+	return nil
+}
+
+// 通过smtp发送纯文本邮件，服务器支持时使用STARTTLS
+type SmtpNotifier struct {
+	Config *SmtpInfo
+}
+
+func (m *SmtpNotifier) Notify(ctx context.Context, n *Notification) error {
+	config := m.Config
+	conn, err := new(net.Dialer).DialContext(ctx, "tcp", net.JoinHostPort(config.Host, config.Port))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	client, err := smtp.NewClient(conn, config.Host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: config.Host}); err != nil {
+			return err
+		}
+	}
+	if len(config.Username) > 0 {
+		if err := client.Auth(smtp.PlainAuth("", config.Username, string(config.Password), config.Host)); err != nil {
+			return err
+		}
+	}
+
+	// 显示名只用于邮件头，MAIL FROM和RCPT TO只能是邮箱地址
+	if err := client.Mail(config.FromAddress.Address); err != nil {
+		return err
+	}
+	for _, to := range config.ToAddresses {
+		if err := client.Rcpt(to.Address); err != nil {
+			return err
+		}
+	}
+
+	data, err := client.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := data.Write(m.message(n)); err != nil {
+		return err
+	}
+	if err := data.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// 生成邮件内容，正文按行使用CRLF
+func (m *SmtpNotifier) message(n *Notification) []byte {
+	var b bytes.Buffer
+	to := make([]string, 0, len(m.Config.ToAddresses))
+	for _, address := range m.Config.ToAddresses {
+		to = append(to, address.String())
+	}
+	fmt.Fprintf(&b, "From: %s\r\n", m.Config.FromAddress.String())
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", n.Subject()))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(strings.ReplaceAll(n.Summary, "\r\n", "\n"), "\n", "\r\n"))
+	return b.Bytes()
+}
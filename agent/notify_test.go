@@ -0,0 +1,162 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/CipherChina/OneAuth-Agent/mock"
+)
+
+const notifyWebhookSecret = "hook-secret"
+
+// 配置webhook和邮件通知，返回两个模拟接收端
+func (env *e2eEnv) notifiers() (*mock.Webhook, *mock.Smtp) {
+	webhook, smtp := mock.NewWebhook(), mock.NewSmtp()
+	env.t.Cleanup(webhook.Close)
+	env.t.Cleanup(smtp.Close)
+
+	notify := &env.config.System.Notify
+	notify.Webhook = WebhookInfo{Url: webhook.Url(), Secret: notifyWebhookSecret}
+	notify.Smtp = SmtpInfo{From: "agent@example.com", To: StringList{"ops@example.com", "dev@example.com"}}
+	notify.Smtp.Host, notify.Smtp.Port = smtp.HostPort()
+	if err := env.config.Init(); err != nil {
+		env.t.Fatal("config init: ", err)
+	}
+	return webhook, smtp
+}
+
+// 校验webhook签名并解析通知内容
+func parseWebhook(t *testing.T, request mock.WebhookRequest) *Notification {
+	t.Helper()
+	if request.Header.Get(WebhookSignatureHeader) != WebhookSignature(notifyWebhookSecret, request.Body) {
+		t.Fatal("webhook signature mismatch: ", request.Header.Get(WebhookSignatureHeader))
+	}
+
+	n := new(Notification)
+	if err := json.Unmarshal(request.Body, n); err != nil {
+		t.Fatal("parse webhook body: ", err)
+	}
+	if request.Header.Get(WebhookEventHeader) != n.Event {
+		t.Fatal("event header should match body, got: ", request.Header.Get(WebhookEventHeader))
+	}
+	return n
+}
+
+// 失败的任务数超过maxerrors时通知，通知包含失败任务和报告路径
+func TestNotifyPartialFailure(t *testing.T) {
+	env := newE2EEnv(t)
+	webhook, smtp := env.notifiers()
+	env.config.System.Notify.MaxErrors = 1
+	env.config.System.Fiber = "1"
+	env.datapub.SetOrgs(baseOrgs())
+	env.datapub.SetEmps(baseEmps())
+	env.oneauth.FailNext(http.MethodPost, "/api/v1/account/user", http.StatusBadRequest, 1)
+	env.start()
+
+	if err := env.syncer.Sync(context.Background(), "test"); err != nil {
+		t.Fatal("sync: ", err)
+	}
+	if len(webhook.Requests()) != 0 || len(smtp.Mails()) != 0 {
+		t.Fatal("failures within maxerrors should not notify")
+	}
+
+	env.datapub.SetEmps(append(baseEmps(), emp("E6", "Frank", "frank", "A"), emp("E7", "Grace", "grace", "B")))
+	env.oneauth.FailNext(http.MethodPost, "/api/v1/account/user", http.StatusBadRequest, 2)
+	if err := env.syncer.Sync(context.Background(), "test"); err != nil {
+		t.Fatal("sync: ", err)
+	}
+
+	requests := webhook.Requests()
+	if len(requests) != 1 {
+		t.Fatal("expected one webhook request, got: ", len(requests))
+	}
+	n := parseWebhook(t, requests[0])
+	if n.Event != NotifyPartial || n.FailedTasks != 2 || len(n.Failures) != 2 || !strings.HasSuffix(n.Report, ".json") {
+		t.Fatal("unexpected notification: ", string(requests[0].Body))
+	}
+	// 按顺序执行人员任务，上一次创建失败的E1重试时再次失败，E6失败，E7成功
+	var codes []string
+	for _, failure := range n.Failures {
+		codes = append(codes, failure.Action+" "+failure.Code)
+	}
+	sort.Strings(codes)
+	if strings.Join(codes, ",") != "create E1,create E6" {
+		t.Fatal("unexpected failures: ", codes)
+	}
+	if !strings.Contains(n.Summary, "user create E1") || !strings.Contains(n.Summary, "user create E6") ||
+		!strings.Contains(n.Summary, "user create: planned 3, applied 1, failed 2") {
+		t.Fatal("summary should list the failures, got: ", n.Summary)
+	}
+
+	mails := smtp.Mails()
+	if len(mails) != 1 || mails[0].From != "agent@example.com" || len(mails[0].To) != 2 {
+		t.Fatal("expected one mail to both recipients, got: ", mails)
+	}
+	if !strings.Contains(mails[0].Data, "Subject: [OneAuth-Agent] sync partial: default\r\n") ||
+		!strings.Contains(mails[0].Data, "user create E1") || !strings.Contains(mails[0].Data, "user create E6") {
+		t.Fatal("unexpected mail: ", mails[0].Data)
+	}
+}
+
+// 带显示名的发件人和收件人只在邮件头中使用，smtp命令中只使用邮箱地址
+func TestNotifyMailDisplayName(t *testing.T) {
+	env := newE2EEnv(t)
+	_, smtp := env.notifiers()
+	env.config.System.Notify.Smtp.From = "OneAuth Agent <agent@example.com>"
+	env.config.System.Notify.Smtp.To = StringList{"Ops Team <ops@example.com>", "dev@example.com"}
+	if err := env.config.Init(); err != nil {
+		t.Fatal("config init: ", err)
+	}
+	env.config.Database.User.Appsecret = "wrong-secret"
+	env.start()
+
+	if err := env.syncer.Sync(context.Background(), "test"); err == nil {
+		t.Fatal("sync with wrong sign should fail")
+	}
+
+	mails := smtp.Mails()
+	if len(mails) != 1 || mails[0].From != "agent@example.com" || strings.Join(mails[0].To, ",") != "ops@example.com,dev@example.com" {
+		t.Fatal("envelope should only use the email addresses, got: ", mails)
+	}
+	if !strings.Contains(mails[0].Data, "From: \"OneAuth Agent\" <agent@example.com>\r\n") ||
+		!strings.Contains(mails[0].Data, "To: \"Ops Team\" <ops@example.com>, <dev@example.com>\r\n") {
+		t.Fatal("headers should keep the display names, got: ", mails[0].Data)
+	}
+}
+
+// 被删除保护中止和同步失败时通知
+func TestNotifySafetyAndFailure(t *testing.T) {
+	env := newE2EEnv(t)
+	webhook, _ := env.notifiers()
+	env.config.Database.Safety.MaxUserDeletePercent = 50
+	env.datapub.SetOrgs(baseOrgs())
+	env.datapub.SetEmps(baseEmps())
+	env.start()
+	env.sync()
+
+	env.datapub.SetEmps(baseEmps()[:1])
+	if _, ok := env.syncer.Sync(context.Background(), "test").(*SafetyError); !ok {
+		t.Fatal("sync should be aborted by safety threshold")
+	}
+
+	env.config.Database.User.Appsecret = "wrong-secret"
+	env.syncer.Source = NewDatapubSource(&env.config.Database)
+	if err := env.syncer.Sync(context.Background(), "test"); err == nil {
+		t.Fatal("sync with wrong sign should fail")
+	}
+
+	requests := webhook.Requests()
+	if len(requests) != 2 {
+		t.Fatal("expected two notifications, got: ", len(requests))
+	}
+	if n := parseWebhook(t, requests[0]); n.Event != NotifySafety || !strings.Contains(n.Summary, "safety threshold") {
+		t.Fatal("expected safety notification, got: ", n.Event, n.Summary)
+	}
+	if n := parseWebhook(t, requests[1]); n.Event != NotifyFailure || len(n.Error) == 0 {
+		t.Fatal("expected failure notification, got: ", n.Event, n.Error)
+	}
+}
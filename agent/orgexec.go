@@ -0,0 +1,213 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// 依赖的部门任务失败，本任务被跳过，下次同步时重试
+var ErrDependencyFailed = errors.New("dependency failed")
+
+// 部门任务，依赖的任务都成功后才执行
+type orgTask struct {
+	node    *DataOrgMemNode
+	action  string     // 记录执行结果的操作名，如create、update+move
+	waiting int        // 还没有完成的依赖任务数
+	next    []*orgTask // 依赖本任务的任务
+	skipped bool       // 依赖的任务失败，不再执行
+}
+
+// 部门任务的依赖关系，同一个部门只有一个任务
+type orgTaskGraph struct {
+	tasks  []*orgTask
+	byCode map[string]*orgTask
+}
+
+func newOrgTaskGraph() *orgTaskGraph {
+	return &orgTaskGraph{byCode: make(map[string]*orgTask)}
+}
+
+func (g *orgTaskGraph) add(node *DataOrgMemNode, action string) *orgTask {
+	task := &orgTask{node: node, action: action}
+	g.tasks = append(g.tasks, task)
+	g.byCode[node.NodeCode] = task
+	return task
+}
+
+// task在dep执行成功后执行，任意一个为空或者两者相同时忽略
+func (g *orgTaskGraph) depend(task, dep *orgTask) {
+	if task == nil || dep == nil || dep == task {
+		return
+	}
+	for _, next := range dep.next {
+		if next == task {
+			return
+		}
+	}
+	dep.next = append(dep.next, task)
+	task.waiting++
+}
+
+// 部门在上一次同步时的父部门编码，删除任务的部门不在本次的部门树中
+func orgParentCode(node *DataOrgMemNode) string {
+	if node.parent != nil {
+		return node.parent.NodeCode
+	}
+	if node.Value != nil {
+		return node.Value.UpperOrgUnitCode
+	}
+	return ""
+}
+
+// 部门接口的并发数
+func (s *Syncer) orgWorkers(count int) int {
+	workers, err := strconv.Atoi(s.Config.System.OrgFiber)
+	if err != nil || workers < 1 {
+		workers = 1
+	}
+	if workers > count {
+		workers = count
+	}
+	return workers
+}
+
+// 按依赖关系并发执行部门任务，没有依赖关系的任务同时执行
+// 任务失败时跳过所有直接和间接依赖它的任务，记录为失败；收到退出请求时不再开始新的任务
+func (s *Syncer) runOrgTasks(ctx context.Context, graph *orgTaskGraph, run func(task *orgTask) error) {
+	if len(graph.tasks) == 0 {
+		return
+	}
+
+	// 每个任务最多入队一次，队列不会阻塞
+	ready := make(chan *orgTask, len(graph.tasks))
+	var pending sync.WaitGroup
+	for _, task := range graph.tasks {
+		if task.waiting == 0 {
+			pending.Add(1)
+			ready <- task
+		}
+	}
+
+	var lock sync.Mutex
+	finish := func(task *orgTask, err error) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		if err != nil {
+			s.skipOrgTasks(task, task)
+			return
+		}
+		for _, next := range task.next {
+			next.waiting--
+			if next.waiting == 0 && !next.skipped {
+				// 在本任务完成前入队，pending不会提前归零
+				pending.Add(1)
+				ready <- next
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < s.orgWorkers(len(graph.tasks)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range ready {
+				// 收到退出请求时不再执行，依赖它的任务也不会执行
+				if !s.stopTask(ctx) {
+					start := time.Now()
+					err := run(task)
+					MetricTaskDuration.Observe(time.Since(start).Seconds(), s.Name(), "org", task.action)
+					s.RecordSyncResult("org", task.action, task.node.NodeCode, err)
+					finish(task, err)
+				}
+				pending.Done()
+			}
+		}()
+	}
+
+	pending.Wait()
+	close(ready)
+	wg.Wait()
+}
+
+// 跳过所有依赖task的任务，failed为最初失败的任务
+func (s *Syncer) skipOrgTasks(failed, task *orgTask) {
+	for _, next := range task.next {
+		if next.skipped {
+			continue
+		}
+		next.skipped = true
+
+		s.log.Warn("[oneauth] skip org ", next.action, " [", next.node.NodeCode, ", ", next.node.NodeName, "], ",
+			failed.action, " ", failed.node.NodeCode, " failed")
+		err := fmt.Errorf("%w: %s %s", ErrDependencyFailed, failed.action, failed.node.NodeCode)
+		s.RecordSyncResult("org", next.action, next.node.NodeCode, err)
+		s.skipOrgTasks(failed, next)
+	}
+}
+
+// 创建部门，父部门和根节点已经在本任务之前创建
+func (s *Syncer) createOrg(ctx context.Context, task *DataOrgMemNode) error {
+	if task.parent != nil {
+		s.log.Debug("[oneauth] Create new org: [", task.NodeCode, ", ", task.NodeName,
+			"], parent: [", task.parent.NodeCode, ", ", task.parent.NodeName, "]")
+	} else {
+		s.log.Debug("[oneauth] Create new org: [", task.NodeCode, ", ", task.NodeName, "], parent: [nil]")
+	}
+
+	if task.Root == true {
+		orgId, err := s.Target.CreateRoot(ctx, task)
+		if IsOneauthConflict(err) {
+			orgId, err = s.adoptRoot(ctx, task, err)
+		}
+		if err != nil {
+			s.log.Error("[Oneauth] Create root org error: ", err)
+			return err
+		}
+
+		task.OrgId = orgId
+		s.log.Info("[Oneauth] get orgid: ", orgId)
+		return nil
+	}
+
+	// 根节点本次新建时，使用新的根节点id
+	if len(task.OrgId) == 0 && s.realOrg != nil {
+		task.OrgId = s.realOrg.OrgId
+	}
+	// 父部门在本节点之前创建，使用父部门当前的id
+	if task.parent != nil {
+		task.FatherId = task.parent.DepId
+	}
+
+	depId, err := s.Target.CreateDepartment(ctx, task)
+	if IsOneauthConflict(err) {
+		depId, err = s.adoptDepartment(ctx, task, err)
+	}
+	if err != nil {
+		return err
+	}
+
+	task.DepId = depId
+	return nil
+}
+
+// 更新部门名字或移动部门，新的父部门已经在本任务之前创建
+func (s *Syncer) updateOrg(ctx context.Context, task *DataOrgMemNode) error {
+	s.log.Debug("[oneauth] Update org: ", task.NodeCode, ", ", task.NodeName, ", ", task.Action)
+
+	if task.Action&(1<<2) != 0 && len(task.FatherId) == 0 {
+		father, ok := s.orgMap[task.parent.NodeCode]
+		if !ok || len(father.DepId) == 0 {
+			s.log.Error("[Oneauth] move can't find father, node: ", task.NodeCode, ", ", task.NodeName, ", father: ", task.parent.NodeCode)
+			return errors.New("move " + task.NodeCode + " can't find father " + task.parent.NodeCode)
+		}
+		task.FatherId = father.DepId
+	}
+
+	return s.Target.UpdateDepartment(ctx, task)
+}
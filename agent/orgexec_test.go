@@ -0,0 +1,113 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// 父部门创建失败时跳过子部门和其中的人员，不会把子部门创建到根节点下，下次同步时一起重试
+func TestOrgCreateSkipsChildren(t *testing.T) {
+	env := newE2EEnv(t)
+	env.datapub.SetOrgs(baseOrgs())
+	env.datapub.SetEmps(baseEmps())
+	env.start()
+	env.sync()
+
+	env.datapub.SetOrgs(append(baseOrgs(), org("C", "Legal", ""), org("C1", "Contracts", "C"), org("C2", "Patents", "C1"),
+		org("D", "Support", "")))
+	env.datapub.SetEmps(append(baseEmps(), emp("E6", "Frank", "frank", "C1")))
+	// 单并发时按计划顺序先创建C，C的创建请求失败
+	env.config.System.OrgFiber = "1"
+	env.oneauth.FailNext(http.MethodPost, "/api/v1/account/org/", http.StatusBadRequest, 1)
+	env.oneauth.ResetRequests()
+	if err := env.syncer.Sync(context.Background(), "test"); err != nil {
+		t.Fatal("sync: ", err)
+	}
+
+	tree := copyMap(baseTree)
+	tree["D"] = e2eRoot + "/Support"
+	env.assertTree(tree)
+	env.assertUsers(baseUsers)
+	if creates := strings.Count(strings.Join(env.oneauth.Writes(), ","), "POST"); creates != 2 {
+		t.Fatal("children of the failed org should not be sent, got writes: ", env.oneauth.Writes())
+	}
+	for _, write := range env.oneauth.Writes() {
+		if strings.HasPrefix(write, http.MethodPost+" /api/v1/account/user") {
+			t.Fatal("users in the skipped org should not be created, got: ", write)
+		}
+	}
+
+	var skipped, skippedUsers []string
+	env.syncer.statusLock.Lock()
+	for _, result := range env.syncer.results {
+		if !errors.Is(result.err, ErrDependencyFailed) {
+			continue
+		}
+		if result.kind == "user" {
+			skippedUsers = append(skippedUsers, result.action+" "+result.code)
+			if !strings.Contains(result.err.Error(), "org C1") {
+				t.Error("user skip should name its org, got: ", result.err)
+			}
+			continue
+		}
+		skipped = append(skipped, result.code)
+		if !strings.Contains(result.err.Error(), "create C") {
+			t.Error("skip should name the failed org, got: ", result.err)
+		}
+	}
+	env.syncer.statusLock.Unlock()
+	if strings.Join(skipped, ",") != "C1,C2" {
+		t.Fatal("descendants of the failed org should be skipped, got: ", skipped)
+	}
+	if strings.Join(skippedUsers, ",") != "create E6" {
+		t.Fatal("users in the skipped org should be skipped, got: ", skippedUsers)
+	}
+
+	env.sync()
+	tree["C"] = e2eRoot + "/Legal"
+	tree["C1"] = "C/Contracts"
+	tree["C2"] = "C1/Patents"
+	env.assertTree(tree)
+	users := copyMap(baseUsers)
+	users["E6"] = "Frank|frank|frank@example.com|C1"
+	env.assertUsers(users)
+}
+
+// 删除部门时先删除子部门，子部门删除失败时保留父部门，下次同步时重试
+func TestOrgDeleteLeafFirst(t *testing.T) {
+	env := newE2EEnv(t)
+	env.datapub.SetOrgs(append(baseOrgs(), org("A11", "Sales North", "A1"), org("A12", "Sales South", "A1")))
+	env.datapub.SetEmps(baseEmps())
+	env.start()
+	env.sync()
+
+	env.datapub.SetOrgs(baseOrgs()[3:])
+	env.datapub.SetEmps(baseEmps()[3:])
+	env.oneauth.FailNext(http.MethodDelete, "/api/v1/account/org/", http.StatusBadRequest, 1)
+	if err := env.syncer.Sync(context.Background(), "test"); err != nil {
+		t.Fatal("sync: ", err)
+	}
+
+	status, _, _ := env.syncer.GetSyncStatus()
+	if status.Failed["org"]["delete"] < 2 {
+		t.Fatal("failed delete and its parents should be reported, got: ", status.Errors)
+	}
+	if _, ok := env.tree()["A"]; !ok {
+		t.Fatal("parent should be kept when a child delete failed")
+	}
+	for _, err := range status.Errors {
+		if strings.Contains(err, "department has sub departments") {
+			t.Fatal("parent should not be deleted before its children: ", err)
+		}
+	}
+
+	env.sync()
+	env.assertTree(map[string]string{
+		"B":        e2eRoot + "/R&D",
+		"B1":       "B/Platform",
+		e2eDefault: e2eRoot + "/" + e2eDefault,
+	})
+}
@@ -0,0 +1,383 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 同步计划中的操作类型，按执行顺序排列
+var PlanActions = []string{"create", "update", "move", "update+move", "leader", "delete"}
+
+// 部门变更计划
+type PlanOrgItem struct {
+	Code      string `json:"code"`
+	Name      string `json:"name"`
+	Path      string `json:"path"`                // 同步后的部门路径
+	OldName   string `json:"oldName,omitempty"`   // 更新前的名字
+	OldPath   string `json:"oldPath,omitempty"`   // 更新或移动前的部门路径
+	DepId     string `json:"depId,omitempty"`     // oneauth部门id，新建的部门为空
+	Leader    string `json:"leader,omitempty"`    // 部门负责人工号
+	OldLeader string `json:"oldLeader,omitempty"` // 更新前的部门负责人工号
+
+	LastStatus string `json:"lastStatus,omitempty"` // 上一次同步失败或没有执行时，为上一次的同步状态
+	LastError  string `json:"lastError,omitempty"`
+}
+
+// 人员变更计划
+type PlanUserItem struct {
+	UserCode    string `json:"userCode"`
+	UserName    string `json:"userName"`
+	OAID        string `json:"oaid"`
+	Email       string `json:"email"`
+	Path        string `json:"path"` // 同步后所在部门路径
+	OldUserName string `json:"oldUserName,omitempty"`
+	OldOAID     string `json:"oldOaid,omitempty"`
+	OldEmail    string `json:"oldEmail,omitempty"`
+	OldPath     string `json:"oldPath,omitempty"`
+	Id          string `json:"id,omitempty"`         // oneauth用户id，新建的用户为空
+	Manager     string `json:"manager,omitempty"`    // 直属上级工号
+	OldManager  string `json:"oldManager,omitempty"` // 更新前的直属上级工号
+
+	LastStatus string `json:"lastStatus,omitempty"` // 上一次同步失败或没有执行时，为上一次的同步状态
+	LastError  string `json:"lastError,omitempty"`
+}
+
+// 一次同步的完整变更计划，按操作类型分组
+type SyncPlan struct {
+	GeneratedAt time.Time                 `json:"generatedAt"`
+	RootName    string                    `json:"rootName"`
+	Summary     map[string]map[string]int `json:"summary"`
+	Orgs        map[string][]PlanOrgItem  `json:"orgs"`
+	Users       map[string][]PlanUserItem `json:"users"`
+}
+
+// 将操作位转换为计划中的操作类型
+func ActionName(action int) string {
+	switch {
+	case action&(1<<3) != 0:
+		return "delete"
+	case action&(1<<0) != 0:
+		return "create"
+	case action&(1<<1) != 0 && action&(1<<2) != 0:
+		return "update+move"
+	case action&(1<<1) != 0:
+		return "update"
+	case action&(1<<2) != 0:
+		return "move"
+	case action&(1<<4) != 0:
+		return "leader"
+	}
+
+	return ""
+}
+
+// 获取新组织架构中部门的完整路径
+func OrgNodePath(node *DataOrgMemNode) string {
+	var names []string
+	for ; node != nil; node = node.parent {
+		names = append(names, node.NodeName)
+	}
+
+	for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+		names[i], names[j] = names[j], names[i]
+	}
+
+	return strings.Join(names, "/")
+}
+
+// 获取比对基准数据中部门的完整路径，基准数据为oneauth数据或上一次同步的备份
+func (s *Syncer) BaselineOrgPath(code string) string {
+	if s.upstreamExtraKey != nil {
+		var names []string
+		// 限制深度，防止oneauth数据出现环
+		for depth := 0; depth < 64; depth++ {
+			node, ok := s.upstreamExtraKey[code]
+			if !ok {
+				break
+			}
+
+			names = append([]string{node.Name}, names...)
+			if len(node.FatherCode) == 0 {
+				break
+			}
+			code = node.FatherCode
+		}
+
+		return strings.Join(names, "/")
+	}
+
+	if node, ok := s.orgMapBak[code]; ok {
+		return OrgNodePath(node)
+	}
+
+	return ""
+}
+
+// 获取比对基准数据中部门的名字
+func (s *Syncer) BaselineOrgName(code string) string {
+	if s.upstreamExtraKey != nil {
+		if node, ok := s.upstreamExtraKey[code]; ok {
+			return node.Name
+		}
+		return ""
+	}
+
+	if node, ok := s.orgMapBak[code]; ok {
+		return node.NodeName
+	}
+
+	return ""
+}
+
+// 基准数据中部门id与外部编码的对应关系
+func (s *Syncer) baselineDepCodes() map[string]string {
+	codes := make(map[string]string)
+	if s.upstreamExtraKey != nil {
+		for code, node := range s.upstreamExtraKey {
+			codes[node.DepId] = code
+		}
+		return codes
+	}
+
+	for code, node := range s.orgMapBak {
+		if len(node.DepId) > 0 {
+			codes[node.DepId] = code
+		}
+	}
+	if s.realOrgBak != nil && len(s.realOrgBak.OrgId) > 0 {
+		codes[s.realOrgBak.OrgId] = s.realOrgBak.NodeCode
+	}
+
+	return codes
+}
+
+// 根据任务队列生成同步计划，不会改变队列内容
+func (s *Syncer) BuildSyncPlan(tasks *SyncTasks) *SyncPlan {
+	plan := new(SyncPlan)
+	plan.GeneratedAt = time.Now()
+	plan.RootName = s.Config.Oneauth.RootName
+	plan.Orgs = make(map[string][]PlanOrgItem)
+	plan.Users = make(map[string][]PlanUserItem)
+	plan.Summary = map[string]map[string]int{"org": {}, "user": {}}
+
+	addOrg := func(v interface{}) bool {
+		task := v.(*DataOrgMemNode)
+		if task.Action&(1<<4) != 0 {
+			item := PlanOrgItem{Code: task.NodeCode, Name: task.NodeName, Path: OrgNodePath(task), DepId: task.DepId,
+				Leader: DesiredLeaderCode(task), OldLeader: task.LeaderCode}
+			item.LastStatus, item.LastError = s.lastOrgStatus(task.NodeCode)
+			plan.Orgs["leader"] = append(plan.Orgs["leader"], item)
+			plan.Summary["org"]["leader"]++
+		}
+
+		// 负责人变更单独统计
+		action := ActionName(task.Action &^ (1 << 4))
+		if len(action) == 0 {
+			return true
+		}
+
+		item := PlanOrgItem{Code: task.NodeCode, Name: task.NodeName, DepId: task.DepId}
+		if action == "delete" {
+			item.Path = s.BaselineOrgPath(task.NodeCode)
+		} else {
+			item.Path = OrgNodePath(task)
+		}
+
+		if task.Action&(1<<1|1<<2) != 0 {
+			item.OldPath = s.BaselineOrgPath(task.NodeCode)
+			if task.Action&(1<<1) != 0 {
+				item.OldName = s.BaselineOrgName(task.NodeCode)
+			}
+		}
+
+		item.LastStatus, item.LastError = s.lastOrgStatus(task.NodeCode)
+		plan.Orgs[action] = append(plan.Orgs[action], item)
+		plan.Summary["org"][action]++
+		return true
+	}
+
+	tasks.OrgNew.Range(addOrg)
+	tasks.OrgUpdate.Range(addOrg)
+	tasks.OrgDel.Range(addOrg)
+
+	// 人员的旧数据
+	baseUsers := s.membersBak
+	if s.upstreamUsers != nil {
+		baseUsers = s.upstreamUsers
+	}
+	depCodes := s.baselineDepCodes()
+	depPath := func(orgId, depId string) string {
+		if len(depId) == 0 {
+			depId = orgId
+		}
+		if code, ok := depCodes[depId]; ok {
+			return s.BaselineOrgPath(code)
+		}
+		return ""
+	}
+
+	tasks.Users.Range(func(v interface{}) bool {
+		task := v.(*DataApiEmpNode)
+		action := ActionName(task.Action)
+		if len(action) == 0 {
+			return true
+		}
+
+		item := PlanUserItem{UserCode: task.UserCode, UserName: task.UserName, OAID: task.OAID, Email: task.Email, Id: task.Id}
+		if action == "delete" {
+			item.Path = depPath(task.OrgId, task.DepId)
+		} else if father, ok := s.orgMap[task.OrgCode]; ok {
+			item.Path = OrgNodePath(father)
+		}
+
+		if old, ok := baseUsers[task.UserCode]; ok && action != "delete" {
+			item.Id = old.Id
+			if task.Action&(1<<1) != 0 {
+				item.OldUserName = old.UserName
+				item.OldOAID = old.OAID
+				item.OldEmail = old.Email
+			}
+			if task.Action&(1<<2) != 0 {
+				item.OldPath = depPath(old.OrgId, old.DepId)
+			}
+		}
+
+		item.LastStatus, item.LastError = s.lastUserStatus(task.UserCode)
+		plan.Users[action] = append(plan.Users[action], item)
+		plan.Summary["user"][action]++
+		return true
+	})
+
+	// 直属上级在人员同步完成后更新，单独统计
+	for _, change := range tasks.Diff.Members {
+		if change.Action&(1<<4) == 0 {
+			continue
+		}
+
+		user := change.Member
+		item := PlanUserItem{UserCode: user.UserCode, UserName: user.UserName, OAID: user.OAID, Email: user.Email, Id: user.Id,
+			Manager: change.ManagerCode, OldManager: change.Old.ManagerCode}
+		if father, ok := s.orgMap[user.OrgCode]; ok {
+			item.Path = OrgNodePath(father)
+		}
+
+		item.LastStatus, item.LastError = s.lastUserStatus(user.UserCode)
+		plan.Users["leader"] = append(plan.Users["leader"], item)
+		plan.Summary["user"]["leader"]++
+	}
+
+	// 队列按执行顺序排列，计划按部门路径和工号展示
+	for _, items := range plan.Orgs {
+		sort.Slice(items, func(i, j int) bool {
+			if items[i].Path != items[j].Path {
+				return items[i].Path < items[j].Path
+			}
+			return items[i].Code < items[j].Code
+		})
+	}
+	for _, items := range plan.Users {
+		sort.Slice(items, func(i, j int) bool { return items[i].UserCode < items[j].UserCode })
+	}
+
+	return plan
+}
+
+// 生成可读的文本格式计划
+func (plan *SyncPlan) Text() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "OneAuth sync plan, root: %s, generated at %s\n", plan.RootName, plan.GeneratedAt.Format("2006-01-02 15:04:05"))
+	for _, kind := range []string{"org", "user"} {
+		var counts []string
+		for _, action := range PlanActions {
+			counts = append(counts, fmt.Sprintf("%s %d", action, plan.Summary[kind][action]))
+		}
+		fmt.Fprintf(&b, "  %-5s %s\n", kind+":", strings.Join(counts, ", "))
+	}
+
+	for _, action := range PlanActions {
+		items := plan.Orgs[action]
+		if len(items) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&b, "\n[org %s] %d\n", action, len(items))
+		for _, item := range items {
+			switch action {
+			case "create", "delete":
+				fmt.Fprintf(&b, "  %s (%s)\n", item.Path, item.Code)
+			case "leader":
+				fmt.Fprintf(&b, "  %s (%s) leader: %s -> %s\n", item.Path, item.Code, item.OldLeader, item.Leader)
+			default:
+				fmt.Fprintf(&b, "  %s -> %s (%s)\n", item.OldPath, item.Path, item.Code)
+			}
+			writeLastStatus(&b, item.LastStatus, item.LastError)
+		}
+	}
+
+	for _, action := range PlanActions {
+		items := plan.Users[action]
+		if len(items) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(&b, "\n[user %s] %d\n", action, len(items))
+		for _, item := range items {
+			if action == "leader" {
+				fmt.Fprintf(&b, "  %s %s %s manager: %s -> %s\n", item.UserCode, item.UserName, item.Path, item.OldManager, item.Manager)
+				writeLastStatus(&b, item.LastStatus, item.LastError)
+				continue
+			}
+
+			fmt.Fprintf(&b, "  %s %s <%s> %s %s\n", item.UserCode, item.UserName, item.OAID, item.Email, item.Path)
+			if len(item.OldUserName) > 0 || len(item.OldOAID) > 0 || len(item.OldEmail) > 0 {
+				fmt.Fprintf(&b, "      was: %s <%s> %s\n", item.OldUserName, item.OldOAID, item.OldEmail)
+			}
+			if len(item.OldPath) > 0 {
+				fmt.Fprintf(&b, "      from: %s\n", item.OldPath)
+			}
+			writeLastStatus(&b, item.LastStatus, item.LastError)
+		}
+	}
+
+	return b.String()
+}
+
+// 上一次同步失败或没有执行的变更，在计划中标记为重试
+func writeLastStatus(b *strings.Builder, status, err string) {
+	if len(status) == 0 {
+		return
+	}
+	if len(err) > 0 {
+		fmt.Fprintf(b, "      retry: last sync %s: %s\n", status, err)
+	} else {
+		fmt.Fprintf(b, "      retry: last sync %s\n", status)
+	}
+}
+
+// 将计划写入文本文件和json文件，文件名为prefix.txt和prefix.json
+func (plan *SyncPlan) WriteFiles(prefix string) error {
+	if err := ioutil.WriteFile(prefix+".txt", []byte(plan.Text()), 0644); err != nil {
+		log.Error("[plan] write text plan error: ", err)
+		return err
+	}
+
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		log.Error("[plan] marshal json plan error: ", err)
+		return err
+	}
+
+	if err := ioutil.WriteFile(prefix+".json", data, 0644); err != nil {
+		log.Error("[plan] write json plan error: ", err)
+		return err
+	}
+
+	return nil
+}
@@ -1,4 +1,4 @@
-package main
+package agent
 
 type (
 	//Queue 队列
@@ -68,3 +68,12 @@ func (this *Queue) Pop() interface{} {
 	this.length--
 	return n.value
 }
+
+//遍历队列元素，不做出队操作，f返回false时停止遍历
+func (this *Queue) Range(f func(v interface{}) bool) {
+	for n := this.top; n != nil; n = n.next {
+		if !f(n.value) {
+			return
+		}
+	}
+}
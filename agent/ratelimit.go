@@ -0,0 +1,149 @@
+package agent
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// 令牌桶限流器，rate为每秒生成的令牌数，burst为桶容量
+type TokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &TokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// 预留一个令牌，返回需要等待的时间
+func (b *TokenBucket) reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	// 令牌可以为负数，表示已经被后续等待的请求预留
+	b.tokens--
+	if b.tokens >= 0 {
+		return 0
+	}
+
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// 归还预留但没有使用的令牌
+func (b *TokenBucket) refund() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens++
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+// 阻塞等待直到获取令牌，返回实际等待时间，ctx取消时返回错误
+func (b *TokenBucket) Wait(ctx context.Context) (time.Duration, error) {
+	if b == nil || b.rate <= 0 {
+		return 0, nil
+	}
+
+	wait := b.reserve()
+	if wait > 0 {
+		if err := sleepContext(ctx, wait); err != nil {
+			// 取消的请求不会发出，令牌留给之后的请求
+			b.refund()
+			return wait, err
+		}
+	}
+
+	return wait, nil
+}
+
+// 等待指定时间，ctx取消时提前返回错误
+func sleepContext(ctx context.Context, wait time.Duration) error {
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// oneauth接口分类，用于按类别限流
+var oneauthApiClass = map[string]string{
+	"GetAllRoots":          "read",
+	"GetAllOrgs":           "read",
+	"GetAllMembers":        "read",
+	"CreateOrgRoot":        "create",
+	"CreateOrgDepartment":  "create",
+	"CreateUser":           "create",
+	"UpdateOrgRoot":        "update",
+	"UpdateOrgDepartment":  "update",
+	"MoveOrgDepartment":    "update",
+	"UpdateUser":           "update",
+	"MoveUser":             "update",
+	"SetDepartmentManager": "update",
+	"UpdateUserManager":    "update",
+	"DeleteOrgDepartment":  "delete",
+	"DelUser":              "delete",
+}
+
+// 获取接口类别，未登记的接口按请求方法分类
+func OneauthApiClass(api, method string) string {
+	if class, ok := oneauthApiClass[api]; ok {
+		return class
+	}
+
+	switch method {
+	case http.MethodGet:
+		return "read"
+	case http.MethodPost:
+		return "create"
+	case http.MethodDelete:
+		return "delete"
+	}
+
+	return "update"
+}
+
+// 根据配置创建各类oneauth接口的限流器，rate为0的类别不限流
+func NewRateLimiters(limits RateLimitInfo) map[string]*TokenBucket {
+	return map[string]*TokenBucket{
+		"read":   NewTokenBucket(limits.Read.Rate, limits.Read.Burst),
+		"create": NewTokenBucket(limits.Create.Rate, limits.Create.Burst),
+		"update": NewTokenBucket(limits.Update.Rate, limits.Update.Burst),
+		"delete": NewTokenBucket(limits.Delete.Rate, limits.Delete.Burst),
+	}
+}
+
+// 限流器的共享范围，oneauth地址和token相同的任务属于同一个租户，共享一组限流器
+func rateLimitKey(config *OneAuthConfig) string {
+	return config.BaseUrl + "|" + string(config.Token)
+}
+
+// 请求oneauth前按接口类别等待令牌
+func (t *OneauthTarget) WaitUpstreamLimit(ctx context.Context, class string) error {
+	wait, err := t.limiters[class].Wait(ctx)
+	if wait > 0 {
+		MetricRateLimitWait.Observe(wait.Seconds(), class)
+	}
+	return err
+}
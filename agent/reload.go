@@ -0,0 +1,261 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 可以在运行中重新加载的配置项，其他配置项修改后需要重启
+var reloadablePaths = []string{"system.fiber", "system.orgfiber", "system.adaptive.", "system.log.level", "database.filter.", "database.syncou", "database.safety."}
+
+var jobPathPrefix = regexp.MustCompile(`^jobs\[\d+\]\.`)
+
+// 一个配置项的修改
+type ConfigChange struct {
+	Path string
+	Old  string
+	New  string
+}
+
+func (c ConfigChange) String() string {
+	return c.Path + ": " + c.Old + " -> " + c.New
+}
+
+// 修改后是否可以热加载
+func (c ConfigChange) Reloadable() bool {
+	path := jobPathPrefix.ReplaceAllString(c.Path, "")
+	for _, reloadable := range reloadablePaths {
+		if path == reloadable || strings.HasSuffix(reloadable, ".") && strings.HasPrefix(path, reloadable) {
+			return true
+		}
+	}
+	return false
+}
+
+// 比较两份配置文件中的配置项，返回所有修改过的项，敏感配置项的值会被隐藏
+func DiffConfig(old, new *Config) []ConfigChange {
+	oldValues, newValues := make(map[string]string), make(map[string]string)
+	flattenConfig("", reflect.ValueOf(*old), oldValues)
+	flattenConfig("", reflect.ValueOf(*new), newValues)
+
+	paths := make([]string, 0, len(newValues))
+	for path := range oldValues {
+		paths = append(paths, path)
+	}
+	for path := range newValues {
+		if _, ok := oldValues[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var changes []ConfigChange
+	for _, path := range paths {
+		oldValue, oldOk := oldValues[path]
+		newValue, newOk := newValues[path]
+		if oldOk && newOk && oldValue == newValue {
+			continue
+		}
+
+		change := ConfigChange{Path: path, Old: oldValue, New: newValue}
+		if !oldOk {
+			change.Old = "(none)"
+		}
+		if !newOk {
+			change.New = "(none)"
+		}
+		if strings.HasPrefix(oldValue, secretMark) || strings.HasPrefix(newValue, secretMark) {
+			change.Old, change.New = redacted, redacted
+		}
+		changes = append(changes, change)
+	}
+
+	return changes
+}
+
+// 敏感配置项在比较时的前缀，输出时隐藏
+const secretMark = "\x00secret:"
+
+// 按yaml路径展开配置项，不包括派生字段
+func flattenConfig(path string, value reflect.Value, out map[string]string) {
+	if value.Type() == reflect.TypeOf(Secret("")) {
+		out[path] = secretMark + value.String()
+		return
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+			if len(field.PkgPath) > 0 || name == "-" {
+				continue
+			}
+			if len(name) == 0 {
+				name = strings.ToLower(field.Name)
+			}
+			if len(path) > 0 {
+				name = path + "." + name
+			}
+			flattenConfig(name, value.Field(i), out)
+		}
+
+	case reflect.Slice:
+		if value.Type().Elem().Kind() == reflect.Struct {
+			for i := 0; i < value.Len(); i++ {
+				flattenConfig(path+"["+strconv.Itoa(i)+"]", value.Index(i), out)
+			}
+			return
+		}
+		out[path] = fmt.Sprintf("%q", value.Interface())
+
+	default:
+		out[path] = fmt.Sprint(value.Interface())
+	}
+}
+
+// 复制可以热加载的配置项
+func applyReloadable(dst, src *Config) {
+	dst.System.Fiber = src.System.Fiber
+	dst.System.OrgFiber = src.System.OrgFiber
+	dst.System.Adaptive = src.System.Adaptive
+	dst.System.Log.Level = src.System.Log.Level
+	dst.Database.Filter = src.Database.Filter
+	dst.Database.SyncOu = src.Database.SyncOu
+	dst.Database.Safety = src.Database.Safety
+	for i := range dst.Jobs {
+		dst.Jobs[i].Database.Filter = src.Jobs[i].Database.Filter
+		dst.Jobs[i].Database.SyncOu = src.Jobs[i].Database.SyncOu
+		dst.Jobs[i].Database.Safety = src.Jobs[i].Database.Safety
+	}
+}
+
+// 应用新的配置，config需要已经通过Init初始化
+// 只要有一项修改需要重启就不应用任何修改，正在同步的任务在下一次同步开始时应用新的配置，不等待本次同步结束
+func (m *Manager) Reload(config *Config) ([]ConfigChange, error) {
+	m.reloadLock.Lock()
+	defer m.reloadLock.Unlock()
+
+	changes := DiffConfig(m.Config, config)
+	var restart []string
+	for _, change := range changes {
+		if !change.Reloadable() {
+			restart = append(restart, change.Path)
+		}
+	}
+	if len(restart) > 0 {
+		return changes, fmt.Errorf("changes require restart: %s", strings.Join(restart, ", "))
+	}
+
+	// 任务的名字和顺序没有变化，按顺序一一对应
+	// 没有修改时也替换同步中保存的配置，之前重新加载的修改已经被改回
+	jobs := config.JobConfigs()
+	for i, syncer := range m.Syncers {
+		syncer.reloadConfig(jobs[i])
+	}
+	if len(changes) == 0 {
+		return nil, nil
+	}
+	applyReloadable(m.Config, config)
+
+	level, _ := strconv.Atoi(config.System.Log.Level)
+	log.SetLevel(log.Level(level))
+	return changes, nil
+}
+
+// 保存新的配置，没有同步在执行时立即应用，否则在下一次同步开始时应用
+// 不等待同步锁，避免重新加载阻塞信号处理和退出
+func (s *Syncer) reloadConfig(config *Config) {
+	s.reloadLock.Lock()
+	s.reloadPending = config
+	s.reloadLock.Unlock()
+
+	if !s.lock.TryLock() {
+		s.log.Info("[config] sync is running, apply reloaded config when the next sync starts")
+		return
+	}
+	s.applyReloadedConfig()
+	s.lock.Unlock()
+}
+
+// 应用保存的配置，调用方需要持有同步锁
+func (s *Syncer) applyReloadedConfig() {
+	s.reloadLock.Lock()
+	defer s.reloadLock.Unlock()
+
+	if s.reloadPending != nil {
+		applyReloadable(s.Config, s.reloadPending)
+		s.reloadPending = nil
+
+		// 人员接口的连接池按并发数创建，并发数变化后同步调整
+		if target, ok := s.Target.(*OneauthTarget); ok {
+			target.SetUserConcurrency(s.Config.System.Fiber)
+		}
+	}
+}
+
+// 重新读取配置文件，配置无效或需要重启时保留当前配置
+func (m *Manager) ReloadFile(path string) error {
+	config, err := LoadConfig(path)
+	if err != nil {
+		log.Error("[config] reload ", path, " failed, keep current config: ", err)
+		return err
+	}
+
+	for _, warning := range config.Warnings() {
+		log.Warn("[config] ", warning)
+	}
+
+	changes, err := m.Reload(config)
+	for _, change := range changes {
+		log.Info("[config] changed ", change)
+	}
+	if err != nil {
+		log.Error("[config] reload ", path, " refused, keep current config: ", err)
+		return err
+	}
+
+	if len(changes) == 0 {
+		log.Info("[config] reload ", path, ": no changes")
+	} else {
+		log.Info("[config] reload ", path, " success, ", len(changes), " change(s) applied")
+	}
+	return nil
+}
+
+// 定时检查配置文件的修改时间和大小，有变化时重新加载
+func (m *Manager) WatchConfig(path string, interval time.Duration) {
+	stat, err := os.Stat(path)
+	if err != nil {
+		log.Error("[config] watch ", path, " error: ", err)
+		return
+	}
+
+	go func() {
+		modTime, size := stat.ModTime(), stat.Size()
+		for {
+			time.Sleep(interval)
+
+			stat, err := os.Stat(path)
+			if err != nil {
+				log.Warn("[config] watch ", path, " error: ", err)
+				continue
+			}
+			if stat.ModTime().Equal(modTime) && stat.Size() == size {
+				continue
+			}
+
+			modTime, size = stat.ModTime(), stat.Size()
+			log.Info("[config] ", path, " changed, reload")
+			m.ReloadFile(path)
+		}
+	}()
+}
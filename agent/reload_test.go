@@ -0,0 +1,161 @@
+package agent
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const reloadTestConfig = `system:
+  fiber: "%FIBER%"
+database:
+  host: datapub
+  port: "443"
+  user:
+    appkey: key
+    appsecret: secret
+  defaulttree: Default
+  syncou: %SYNCOU%
+  filter:
+    unitcode: [%FILTER%]
+oneauth:
+  token: %TOKEN%
+  rootname: Root
+  upstream:
+    host: %HOST%
+    port: "443"
+`
+
+func writeReloadConfig(t *testing.T, path string, values map[string]string) {
+	data := reloadTestConfig
+	for _, key := range []string{"FIBER", "SYNCOU", "FILTER", "TOKEN", "HOST"} {
+		data = strings.ReplaceAll(data, "%"+key+"%", values[key])
+	}
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// 只应用可以热加载的配置项，需要重启的修改整体拒绝，同步中时在下一次同步开始时应用
+func TestManagerReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "OneAuth.yaml")
+	values := map[string]string{"FIBER": "4", "SYNCOU": "A", "FILTER": "X", "TOKEN": "token", "HOST": "oneauth"}
+	writeReloadConfig(t, path, values)
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal("load config: ", err)
+	}
+	manager := NewManager(config)
+	job := manager.Syncers[0].Config
+
+	values["FIBER"], values["SYNCOU"], values["FILTER"] = "8", "B", "X, Y"
+	writeReloadConfig(t, path, values)
+
+	// 同步中时不等待同步结束，也不修改配置
+	syncer := manager.Syncers[0]
+	syncer.lock.Lock()
+	if err := manager.ReloadFile(path); err != nil {
+		t.Fatal("reload: ", err)
+	}
+	if job.System.Fiber != "4" || len(job.Database.Filter.Filter) != 1 {
+		t.Fatal("config should not be reloaded during sync")
+	}
+
+	// 下一次同步开始时应用
+	syncer.applyReloadedConfig()
+	syncer.lock.Unlock()
+	if job.System.Fiber != "8" || job.Database.SyncOu != "B" || job.Database.Filter.Filter["Y"] != "1" {
+		t.Fatal("config not reloaded: ", job.System.Fiber, job.Database.SyncOu, job.Database.Filter.Filter)
+	}
+
+	// 没有同步时立即应用
+	values["FIBER"] = "6"
+	writeReloadConfig(t, path, values)
+	if err := manager.ReloadFile(path); err != nil || job.System.Fiber != "6" {
+		t.Fatal("config should be reloaded immediately, got: ", job.System.Fiber, err)
+	}
+
+	// 人员接口的连接池随并发数调整
+	transport := syncer.Target.(*OneauthTarget).userClient.Transport.(*http.Transport)
+	if transport.MaxIdleConnsPerHost != 6 {
+		t.Fatal("user connection pool should follow the reloaded fiber, got: ", transport.MaxIdleConnsPerHost)
+	}
+
+	values["FILTER"], values["HOST"], values["TOKEN"] = "Z", "oneauth2", "token2"
+	writeReloadConfig(t, path, values)
+	if err := manager.ReloadFile(path); err == nil || !strings.Contains(err.Error(), "oneauth.upstream.host") ||
+		!strings.Contains(err.Error(), "oneauth.token") {
+		t.Fatal("changing oneauth host should require restart, got: ", err)
+	}
+	if job.Database.Filter.Filter["Z"] == "1" || job.Oneauth.Upstream.Host != "oneauth" {
+		t.Fatal("refused reload should keep the current config")
+	}
+
+	newConfig, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal("load config: ", err)
+	}
+	for _, change := range DiffConfig(manager.Config, newConfig) {
+		if change.Path == "oneauth.token" && (change.Old != redacted || change.New != redacted) {
+			t.Fatal("secret change should be redacted: ", change)
+		}
+	}
+
+	values["HOST"], values["TOKEN"], values["FIBER"] = "oneauth", "token", "0"
+	writeReloadConfig(t, path, values)
+	if err := manager.ReloadFile(path); err == nil || !strings.Contains(err.Error(), "system.fiber") {
+		t.Fatal("invalid config should be rejected, got: ", err)
+	}
+	if job.System.Fiber != "6" {
+		t.Fatal("invalid reload should keep the current config")
+	}
+}
+
+// 单任务时管理器和同步器的配置互相独立，同步开始时应用配置和重新加载可以同时进行
+func TestManagerReloadWhileSyncStarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "OneAuth.yaml")
+	values := map[string]string{"FIBER": "4", "SYNCOU": "A", "FILTER": "X", "TOKEN": "token", "HOST": "oneauth"}
+	writeReloadConfig(t, path, values)
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal("load config: ", err)
+	}
+	manager := NewManager(config)
+	syncer := manager.Syncers[0]
+	if syncer.Config == manager.Config {
+		t.Fatal("syncer should not share the manager config")
+	}
+
+	values["FIBER"] = "8"
+	writeReloadConfig(t, path, values)
+	reloaded, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal("load config: ", err)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			syncer.lock.Lock()
+			syncer.applyReloadedConfig()
+			syncer.lock.Unlock()
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if _, err := manager.Reload(reloaded); err != nil {
+			t.Fatal("reload: ", err)
+		}
+	}
+	wg.Wait()
+
+	if manager.Config.System.Fiber != "8" || syncer.Config.System.Fiber != "8" {
+		t.Fatal("both configs should be reloaded, got: ", manager.Config.System.Fiber, syncer.Config.System.Fiber)
+	}
+}
@@ -0,0 +1,438 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 报告文件名中的时间格式，文件名为任务名-时间.json和任务名-时间.html
+const reportTimeFormat = "20060102-150405.000"
+
+// 单个任务的执行结果
+type taskResult struct {
+	kind   string
+	action string
+	code   string
+	err    error
+}
+
+// 本次拉取的数据中没有同步的部门或人员
+type ReportSkipped struct {
+	Kind   string `json:"kind"` // org或user
+	Code   string `json:"code"`
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// 主数据数量统计
+type ReportCounts struct {
+	SourceOrgs   int `json:"sourceOrgs"`   // 主数据接口返回的部门数
+	SourceUsers  int `json:"sourceUsers"`  // 主数据接口返回的人员数
+	ValidOrgs    int `json:"validOrgs"`    // 过滤后需要同步的部门数，包括默认部门和虚拟上级部门
+	ValidUsers   int `json:"validUsers"`   // 过滤后需要同步的人员数
+	SkippedOrgs  int `json:"skippedOrgs"`  // 被过滤的部门数
+	SkippedUsers int `json:"skippedUsers"` // 被过滤的人员数
+}
+
+// 部门变更及执行结果
+type ReportOrgChange struct {
+	Action string `json:"action"`
+	PlanOrgItem
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// 人员变更及执行结果
+type ReportUserChange struct {
+	Action string `json:"action"`
+	PlanUserItem
+	Result string `json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// 执行失败的任务，oneauth返回错误时记录响应码和响应内容
+type ReportFailure struct {
+	Kind       string `json:"kind"`
+	Action     string `json:"action"`
+	Code       string `json:"code"`
+	Error      string `json:"error"`
+	StatusCode int    `json:"statusCode,omitempty"`
+	Body       string `json:"body,omitempty"`
+}
+
+// 一次同步的完整报告
+type SyncReport struct {
+	Job       string                    `json:"job"`
+	Trigger   string                    `json:"trigger"`
+	StartTime time.Time                 `json:"startTime"`
+	EndTime   time.Time                 `json:"endTime"`
+	Success   bool                      `json:"success"`
+	Error     string                    `json:"error,omitempty"`
+	FullSync  bool                      `json:"fullSync"`
+	Counts    ReportCounts              `json:"counts"`
+	Planned   map[string]map[string]int `json:"planned"`
+	Applied   map[string]map[string]int `json:"applied"`
+	Failed    map[string]map[string]int `json:"failed"`
+	Orgs      []ReportOrgChange         `json:"orgs"`
+	Users     []ReportUserChange        `json:"users"`
+	Failures  []ReportFailure           `json:"failures"`
+	Skipped   []ReportSkipped           `json:"skipped"`
+}
+
+// 根据本次同步的计划和执行结果生成报告，plan为空表示同步在生成任务前失败
+func (s *Syncer) BuildSyncReport(plan *SyncPlan) *SyncReport {
+	status, _, _ := s.GetSyncStatus()
+	s.statusLock.Lock()
+	results := append([]taskResult(nil), s.results...)
+	s.statusLock.Unlock()
+
+	report := &SyncReport{
+		Job:       s.Name(),
+		Trigger:   status.Trigger,
+		StartTime: status.StartTime,
+		EndTime:   status.EndTime,
+		Success:   status.Success,
+		Error:     status.Error,
+		FullSync:  s.fullSync,
+		Planned:   status.Planned,
+		Applied:   status.Applied,
+		Failed:    status.Failed,
+	}
+
+	// 同一个任务可能执行多次，以最后一次结果为准；部门任务按组合的操作名记录，如update+move，拆分后分别记录
+	executed := make(map[string]error, len(results))
+	for _, result := range results {
+		for _, name := range strings.Split(result.action, "+") {
+			executed[result.kind+" "+name+" "+result.code] = result.err
+		}
+		if result.err != nil {
+			report.Failures = append(report.Failures, newReportFailure(result))
+		}
+	}
+
+	resolve := func(kind, action, code string) (string, string) {
+		var result string
+		var errs []string
+		for _, name := range strings.Split(action, "+") {
+			err, ok := executed[kind+" "+name+" "+code]
+			switch {
+			case !ok:
+				result = StatusPending
+			case err != nil:
+				errs = append(errs, err.Error())
+			}
+		}
+		if len(errs) > 0 {
+			return StatusFailed, strings.Join(errs, "; ")
+		}
+		if len(result) > 0 {
+			return result, ""
+		}
+		return StatusApplied, ""
+	}
+
+	if plan != nil {
+		for _, action := range PlanActions {
+			for _, item := range plan.Orgs[action] {
+				change := ReportOrgChange{Action: action, PlanOrgItem: item}
+				change.Result, change.Error = resolve("org", action, item.Code)
+				report.Orgs = append(report.Orgs, change)
+			}
+			for _, item := range plan.Users[action] {
+				change := ReportUserChange{Action: action, PlanUserItem: item}
+				change.Result, change.Error = resolve("user", action, item.UserCode)
+				report.Users = append(report.Users, change)
+			}
+		}
+	}
+
+	report.Skipped = append(report.Skipped, s.skippedOrgs...)
+	report.Skipped = append(report.Skipped, s.skippedUsers...)
+	sort.SliceStable(report.Skipped, func(i, j int) bool {
+		if report.Skipped[i].Kind != report.Skipped[j].Kind {
+			return report.Skipped[i].Kind < report.Skipped[j].Kind
+		}
+		return report.Skipped[i].Code < report.Skipped[j].Code
+	})
+
+	report.Counts = ReportCounts{
+		SourceOrgs:   s.sourceOrgCount,
+		SourceUsers:  s.sourceEmpCount,
+		ValidOrgs:    s.validOrgCount,
+		ValidUsers:   s.validEmpCount,
+		SkippedOrgs:  len(s.skippedOrgs),
+		SkippedUsers: len(s.skippedUsers),
+	}
+
+	return report
+}
+
+func newReportFailure(result taskResult) ReportFailure {
+	failure := ReportFailure{Kind: result.kind, Action: result.action, Code: result.code, Error: result.err.Error()}
+
+	var oneauthErr *OneauthError
+	if errors.As(result.err, &oneauthErr) {
+		failure.StatusCode = oneauthErr.StatusCode
+		failure.Body = oneauthErr.Body
+	}
+	return failure
+}
+
+// 主数据人员不同步的原因，有效人员返回空
+func EmpSkipReason(emp *DataApiEmpNode) string {
+	switch {
+	case emp.Status != "1":
+		return "status is " + emp.Status
+	case len(emp.UserName) == 0:
+		return "user name is empty"
+	case len(emp.OAID) == 0:
+		return "OAID is empty"
+	}
+	return ""
+}
+
+// 主数据部门本身不同步的原因，有效部门返回空
+func (s *Syncer) orgSkipReason(org *DataApiOrgNode) string {
+	switch {
+	case s.ProcessOrgFilter(org.OrgUnitCode, org.OrgUnitName):
+		return "filtered by database.filter"
+	case org.Status != "1":
+		return "status is " + org.Status
+	case len(org.OrgUnitName) == 0:
+		return "org name is empty"
+	}
+	return ""
+}
+
+// 主数据中没有同步的部门及原因，需要在组织架构处理完成后调用
+func (s *Syncer) SkippedOrgs(orgs []DataApiOrgNode) []ReportSkipped {
+	sources := make(map[string]*DataApiOrgNode, len(orgs))
+	for i := range orgs {
+		sources[orgs[i].OrgUnitCode] = &orgs[i]
+	}
+
+	var skipped []ReportSkipped
+	for i := range orgs {
+		org := &orgs[i]
+		if _, ok := s.orgMap[org.OrgUnitCode]; ok {
+			continue
+		}
+
+		item := ReportSkipped{Kind: "org", Code: org.OrgUnitCode, Name: org.OrgUnitName}
+		item.Reason = s.orgSkipReason(org)
+
+		// 本身有效时查找被过滤的上级部门，限制深度防止数据出现环
+		parent := sources[org.UpperOrgUnitCode]
+		for depth := 0; len(item.Reason) == 0 && parent != nil && depth < 64; depth++ {
+			if reason := s.orgSkipReason(parent); len(reason) > 0 {
+				item.Reason = "parent " + parent.OrgUnitCode + " " + reason
+			}
+			parent = sources[parent.UpperOrgUnitCode]
+		}
+		if len(item.Reason) == 0 {
+			item.Reason = "outside database.syncou " + s.Config.Database.SyncOu
+		}
+
+		skipped = append(skipped, item)
+	}
+
+	return skipped
+}
+
+// 写入本次同步的json和html报告，并按配置清理过期的报告，返回json报告的路径，未配置报告目录时返回空
+func (s *Syncer) WriteSyncReport(report *SyncReport) (string, error) {
+	config := &s.Config.System.Report
+	if len(config.Path) == 0 {
+		return "", nil
+	}
+
+	if err := os.MkdirAll(config.Path, 0755); err != nil {
+		s.log.Error("[report] create report dir error: ", err)
+		return "", err
+	}
+
+	prefix := filepath.Join(config.Path, s.Name()+"-"+report.StartTime.Format(reportTimeFormat))
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		s.log.Error("[report] marshal report error: ", err)
+		return "", err
+	}
+	if err := ioutil.WriteFile(prefix+".json", data, 0644); err != nil {
+		s.log.Error("[report] write json report error: ", err)
+		return "", err
+	}
+
+	if err := report.WriteHtml(prefix + ".html"); err != nil {
+		s.log.Error("[report] write html report error: ", err)
+		return "", err
+	}
+
+	s.log.Info("[report] sync report written to ", prefix+".json")
+	s.CleanSyncReports(time.Now())
+	return prefix + ".json", nil
+}
+
+// 删除超过保留数量或保留时间的报告，只处理本任务的报告
+func (s *Syncer) CleanSyncReports(now time.Time) {
+	config := &s.Config.System.Report
+	files, err := ioutil.ReadDir(config.Path)
+	if err != nil {
+		s.log.Warn("[report] read report dir error: ", err)
+		return
+	}
+
+	pattern := regexp.MustCompile(`^` + regexp.QuoteMeta(s.Name()) + `-(\d{8}-\d{6}\.\d{3})\.(json|html)$`)
+	reports := make(map[string][]string)
+	var stamps []string
+	for _, file := range files {
+		match := pattern.FindStringSubmatch(file.Name())
+		if match == nil {
+			continue
+		}
+		if _, ok := reports[match[1]]; !ok {
+			stamps = append(stamps, match[1])
+		}
+		reports[match[1]] = append(reports[match[1]], file.Name())
+	}
+
+	// 时间格式按字符串排序即为时间顺序，最新的在前
+	sort.Sort(sort.Reverse(sort.StringSlice(stamps)))
+	for i, stamp := range stamps {
+		expired := config.Keep > 0 && i >= config.Keep
+		if config.MaxAgeDuration > 0 {
+			if t, err := time.ParseInLocation(reportTimeFormat, stamp, time.Local); err == nil && now.Sub(t) > config.MaxAgeDuration {
+				expired = true
+			}
+		}
+		if !expired {
+			continue
+		}
+
+		for _, name := range reports[stamp] {
+			if err := os.Remove(filepath.Join(config.Path, name)); err != nil && !os.IsNotExist(err) {
+				s.log.Warn("[report] remove expired report error: ", err)
+			}
+		}
+	}
+}
+
+// 将报告写入静态html文件
+func (report *SyncReport) WriteHtml(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := reportTemplate.Execute(file, report); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+var reportTemplate = template.Must(template.New("report").Funcs(template.FuncMap{
+	"time":    func(t time.Time) string { return t.Format("2006-01-02 15:04:05") },
+	"count":   func(counts map[string]map[string]int, kind, action string) int { return counts[kind][action] },
+	"kinds":   func() []string { return []string{"org", "user"} },
+	"actions": func() []string { return PlanActions },
+}).Parse(reportHtml))
+
+const reportHtml = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>OneAuth sync report {{.Job}} {{time .StartTime}}</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; margin: 20px; }
+table { border-collapse: collapse; margin-bottom: 20px; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+th { background: #f0f0f0; }
+.applied { color: #080; }
+.failed { color: #c00; }
+.pending { color: #888; }
+pre { margin: 0; white-space: pre-wrap; word-break: break-all; }
+</style>
+</head>
+<body>
+<h1>OneAuth sync report</h1>
+<table>
+<tr><th>Job</th><td>{{.Job}}</td></tr>
+<tr><th>Trigger</th><td>{{.Trigger}}</td></tr>
+<tr><th>Start</th><td>{{time .StartTime}}</td></tr>
+<tr><th>End</th><td>{{time .EndTime}}</td></tr>
+<tr><th>Full sync</th><td>{{.FullSync}}</td></tr>
+<tr><th>Result</th><td>{{if .Success}}<span class="applied">success</span>{{else}}<span class="failed">failed</span> {{.Error}}{{end}}</td></tr>
+</table>
+
+<h2>Source</h2>
+<table>
+<tr><th></th><th>Source</th><th>Valid</th><th>Skipped</th></tr>
+<tr><th>Org</th><td>{{.Counts.SourceOrgs}}</td><td>{{.Counts.ValidOrgs}}</td><td>{{.Counts.SkippedOrgs}}</td></tr>
+<tr><th>User</th><td>{{.Counts.SourceUsers}}</td><td>{{.Counts.ValidUsers}}</td><td>{{.Counts.SkippedUsers}}</td></tr>
+</table>
+
+<h2>Summary</h2>
+<table>
+<tr><th></th><th>Action</th><th>Planned</th><th>Applied</th><th>Failed</th></tr>
+{{- $report := .}}
+{{- range $kind := kinds}}{{range $action := actions}}
+<tr><th>{{$kind}}</th><td>{{$action}}</td><td>{{count $report.Planned $kind $action}}</td><td>{{count $report.Applied $kind $action}}</td><td>{{count $report.Failed $kind $action}}</td></tr>
+{{- end}}{{end}}
+</table>
+
+<h2>Org changes ({{len .Orgs}})</h2>
+{{- if .Orgs}}
+<table>
+<tr><th>Action</th><th>Code</th><th>Before</th><th>After</th><th>Result</th></tr>
+{{- range .Orgs}}
+<tr><td>{{.Action}}</td><td>{{.Code}}</td>
+<td>{{if eq .Action "leader"}}leader: {{.OldLeader}}{{else if eq .Action "create"}}{{else}}{{.OldPath}}{{end}}</td>
+<td>{{if eq .Action "leader"}}leader: {{.Leader}}{{else if eq .Action "delete"}}{{else}}{{.Path}}{{end}}</td>
+<td class="{{.Result}}">{{.Result}}{{if .Error}}<pre>{{.Error}}</pre>{{end}}{{if .LastStatus}}<br>retry, last sync {{.LastStatus}}{{end}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+
+<h2>User changes ({{len .Users}})</h2>
+{{- if .Users}}
+<table>
+<tr><th>Action</th><th>Code</th><th>Before</th><th>After</th><th>Result</th></tr>
+{{- range .Users}}
+<tr><td>{{.Action}}</td><td>{{.UserCode}}</td>
+<td>{{if eq .Action "leader"}}manager: {{.OldManager}}{{else if eq .Action "create"}}{{else}}{{if .OldUserName}}{{.OldUserName}} &lt;{{.OldOAID}}&gt; {{.OldEmail}}<br>{{end}}{{if .OldPath}}{{.OldPath}}{{else if eq .Action "delete"}}{{.UserName}} &lt;{{.OAID}}&gt; {{.Path}}{{end}}{{end}}</td>
+<td>{{if eq .Action "leader"}}manager: {{.Manager}}{{else if eq .Action "delete"}}{{else}}{{.UserName}} &lt;{{.OAID}}&gt; {{.Email}}<br>{{.Path}}{{end}}</td>
+<td class="{{.Result}}">{{.Result}}{{if .Error}}<pre>{{.Error}}</pre>{{end}}{{if .LastStatus}}<br>retry, last sync {{.LastStatus}}{{end}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+
+<h2>Failures ({{len .Failures}})</h2>
+{{- if .Failures}}
+<table>
+<tr><th>Task</th><th>Code</th><th>Status</th><th>Error</th><th>OneAuth response</th></tr>
+{{- range .Failures}}
+<tr><td>{{.Kind}} {{.Action}}</td><td>{{.Code}}</td><td>{{if .StatusCode}}{{.StatusCode}}{{end}}</td><td><pre>{{.Error}}</pre></td><td><pre>{{.Body}}</pre></td></tr>
+{{- end}}
+</table>
+{{- end}}
+
+<h2>Skipped ({{len .Skipped}})</h2>
+{{- if .Skipped}}
+<table>
+<tr><th>Kind</th><th>Code</th><th>Name</th><th>Reason</th></tr>
+{{- range .Skipped}}
+<tr><td>{{.Kind}}</td><td>{{.Code}}</td><td>{{.Name}}</td><td>{{.Reason}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+</body>
+</html>
+`
@@ -0,0 +1,199 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readReports(t *testing.T, dir string) []*SyncReport {
+	t.Helper()
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	var reports []*SyncReport
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		report := new(SyncReport)
+		if err := json.Unmarshal(data, report); err != nil {
+			t.Fatal("parse report ", path, ": ", err)
+		}
+		if _, err := os.Stat(strings.TrimSuffix(path, ".json") + ".html"); err != nil {
+			t.Fatal("html report should be written with json report: ", err)
+		}
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// 报告包含数量统计、变更前后的值、oneauth返回的错误和被过滤的数据
+func TestSyncReport(t *testing.T) {
+	env := newE2EEnv(t)
+	orgs := append(baseOrgs(), org("C", "Legal", ""), org("C1", "Legal East", "C"))
+	emps := append(baseEmps(), emp("E6", "Frank", "frank", "C1"), emp("E7", "Grace", "", "A"))
+	env.datapub.SetOrgs(orgs)
+	env.datapub.SetEmps(emps)
+	env.config.Database.Filter.Filter["C"] = "1"
+	env.oneauth.FailNext(http.MethodPost, "/api/v1/account/user", http.StatusBadRequest, 1)
+	env.start()
+
+	if err := env.syncer.Sync(context.Background(), "test"); err != nil {
+		t.Fatal("sync: ", err)
+	}
+
+	dir := env.config.System.Report.Path
+	reports := readReports(t, dir)
+	if len(reports) != 1 {
+		t.Fatal("expected one report, got: ", len(reports))
+	}
+	report := reports[0]
+
+	counts := ReportCounts{SourceOrgs: 7, SourceUsers: 7, ValidOrgs: 6, ValidUsers: 5, SkippedOrgs: 2, SkippedUsers: 2}
+	if report.Counts != counts || !report.Success || report.Job != DefaultJobName {
+		t.Fatal("unexpected report summary: ", report.Counts, report.Success, report.Job)
+	}
+
+	if len(report.Failures) != 1 || report.Failures[0].StatusCode != http.StatusBadRequest ||
+		!strings.Contains(report.Failures[0].Body, "injected failure") {
+		t.Fatal("failure should keep the oneauth response: ", report.Failures)
+	}
+	results := make(map[string]int)
+	for _, change := range report.Users {
+		results[change.Action+" "+change.Result]++
+	}
+	if results["create applied"] != 4 || results["create failed"] != 1 {
+		t.Fatal("unexpected user results: ", results)
+	}
+
+	skipped := make(map[string]string)
+	for _, item := range report.Skipped {
+		skipped[item.Code] = item.Reason
+	}
+	expected := map[string]string{
+		"C":  "filtered by database.filter",
+		"C1": "parent C filtered by database.filter",
+		"E6": "org C1 is not synced",
+		"E7": "OAID is empty",
+	}
+	for code, reason := range expected {
+		if skipped[code] != reason {
+			t.Errorf("%s: expected skip reason %q, got %q", code, reason, skipped[code])
+		}
+	}
+
+	// 再次同步时重试失败的创建，重命名的部门记录变更前后的名字
+	orgs[0].OrgUnitName = "Sales Global"
+	env.datapub.SetOrgs(orgs)
+	os.RemoveAll(dir)
+	if err := env.syncer.Sync(context.Background(), "test"); err != nil {
+		t.Fatal("sync: ", err)
+	}
+
+	report = readReports(t, dir)[0]
+	if len(report.Orgs) != 1 || report.Orgs[0].Code != "A" || report.Orgs[0].OldName != "Sales" ||
+		report.Orgs[0].Name != "Sales Global" || report.Orgs[0].Result != StatusApplied {
+		t.Fatal("rename should be reported with old and new name: ", report.Orgs)
+	}
+}
+
+// 同一个任务中改名并移动的部门，按任务的执行结果记录失败或成功
+func TestSyncReportUpdateAndMove(t *testing.T) {
+	env := newE2EEnv(t)
+	env.datapub.SetOrgs(baseOrgs())
+	env.datapub.SetEmps(baseEmps())
+	env.start()
+	env.sync()
+
+	orgs := baseOrgs()
+	orgs[1] = org("A1", "Sales East Coast", "B")
+	env.datapub.SetOrgs(orgs)
+	dir := env.config.System.Report.Path
+	for _, expected := range []string{StatusFailed, StatusApplied} {
+		if expected == StatusFailed {
+			env.oneauth.FailNext(http.MethodPut, "/api/v1/account/org/", http.StatusBadRequest, 1)
+		}
+		os.RemoveAll(dir)
+		if err := env.syncer.Sync(context.Background(), "test"); err != nil {
+			t.Fatal("sync: ", err)
+		}
+
+		report := readReports(t, dir)[0]
+		if len(report.Orgs) != 1 || report.Orgs[0].Action != "update+move" || report.Orgs[0].Code != "A1" ||
+			report.Orgs[0].Result != expected {
+			t.Fatalf("update+move should be reported as %s, got: %+v", expected, report.Orgs)
+		}
+	}
+
+	tree := copyMap(baseTree)
+	tree["A1"] = "B/Sales East Coast"
+	env.assertTree(tree)
+}
+
+// 每个任务只保留配置数量的报告，超过保留时间的报告被删除
+func TestCleanSyncReports(t *testing.T) {
+	dir := t.TempDir()
+	config := DefaultConfig()
+	config.System.Report = ReportInfo{Path: dir, Keep: 2, MaxAgeDuration: 24 * time.Hour}
+	syncer := NewSyncer(config, nil, nil)
+
+	now := time.Now()
+	var names []string
+	for _, age := range []time.Duration{48 * time.Hour, 3 * time.Hour, 2 * time.Hour, time.Hour} {
+		stamp := now.Add(-age).Format(reportTimeFormat)
+		names = append(names, DefaultJobName+"-"+stamp+".json", DefaultJobName+"-"+stamp+".html")
+	}
+	// 其他任务的报告和无关文件不受影响
+	other := "default2-" + now.Add(-48*time.Hour).Format(reportTimeFormat) + ".json"
+	names = append(names, other, "notes.txt")
+	for _, name := range names {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	syncer.CleanSyncReports(now)
+
+	files, _ := ioutil.ReadDir(dir)
+	var left []string
+	for _, file := range files {
+		left = append(left, file.Name())
+	}
+	expected := append(names[4:8:8], other, "notes.txt")
+	sort.Strings(expected)
+	if strings.Join(left, ",") != strings.Join(expected, ",") {
+		t.Fatal("unexpected reports left: ", left)
+	}
+}
+
+// 拉取主数据失败时，报告中的数量和被过滤的数据不沿用上一次同步
+func TestSyncReportFetchFailure(t *testing.T) {
+	env := newE2EEnv(t)
+	env.datapub.SetOrgs(baseOrgs())
+	env.datapub.SetEmps(append(baseEmps(), emp("E7", "Grace", "", "A")))
+	env.start()
+	env.sync()
+
+	dir := env.config.System.Report.Path
+	if report := readReports(t, dir)[0]; report.Counts.SourceUsers == 0 || len(report.Skipped) == 0 {
+		t.Fatal("first report should count the fetched data: ", report.Counts)
+	}
+
+	os.RemoveAll(dir)
+	env.datapub.FailNext(100)
+	if err := env.syncer.Sync(context.Background(), "test"); err == nil {
+		t.Fatal("sync should fail when the source fetch fails")
+	}
+
+	report := readReports(t, dir)[0]
+	if report.Success || report.Counts != (ReportCounts{}) || len(report.Skipped) != 0 {
+		t.Fatal("failed fetch should not report previous counts: ", report.Counts, report.Skipped)
+	}
+}
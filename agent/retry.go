@@ -0,0 +1,168 @@
+package agent
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Retry-After最长等待时间，防止服务端返回异常值导致长时间阻塞
+const maxRetryAfter = 5 * time.Minute
+
+// oneauth接口调用失败的错误信息
+type OneauthError struct {
+	Api        string // 接口模板名
+	Method     string
+	Url        string
+	StatusCode int    // http响应码，请求未得到响应时为0
+	Body       string // 响应内容
+	Err        error  // 网络错误
+	RetryAfter time.Duration
+}
+
+func (e *OneauthError) Error() string {
+	if e.Err != nil {
+		return "oneauth [" + e.Url + "] request error: " + e.Err.Error()
+	}
+
+	return "oneauth [" + e.Url + "] response code: " + strconv.Itoa(e.StatusCode) + ", rspbody: " + e.Body
+}
+
+func (e *OneauthError) Unwrap() error {
+	return e.Err
+}
+
+// 对象已存在
+func (e *OneauthError) IsConflict() bool {
+	if e.StatusCode == http.StatusConflict {
+		return true
+	}
+
+	if e.StatusCode == http.StatusBadRequest {
+		body := strings.ToLower(e.Body)
+		return strings.Contains(body, "already exist") || strings.Contains(body, "duplicate") || strings.Contains(e.Body, "已存在")
+	}
+
+	return false
+}
+
+// 对象不存在
+func (e *OneauthError) IsNotFound() bool {
+	return e.StatusCode == http.StatusNotFound
+}
+
+// token无效或无权限
+func (e *OneauthError) IsAuthFailed() bool {
+	return e.StatusCode == http.StatusUnauthorized || e.StatusCode == http.StatusForbidden
+}
+
+// 网络错误、限流或服务端错误，稍后重试可能成功
+func (e *OneauthError) IsTransient() bool {
+	return e.Err != nil || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// 限流或服务端错误，需要降低请求速度
+func (e *OneauthError) IsThrottled() bool {
+	return e.Err == nil && (e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500)
+}
+
+// 判断错误是否为oneauth返回的对象已存在
+func IsOneauthConflict(err error) bool {
+	var oneauthErr *OneauthError
+	return errors.As(err, &oneauthErr) && oneauthErr.IsConflict()
+}
+
+// 判断错误是否为oneauth返回的对象不存在
+func IsOneauthNotFound(err error) bool {
+	var oneauthErr *OneauthError
+	return errors.As(err, &oneauthErr) && oneauthErr.IsNotFound()
+}
+
+// 判断错误是否为oneauth认证失败
+func IsOneauthAuthFailed(err error) bool {
+	var oneauthErr *OneauthError
+	return errors.As(err, &oneauthErr) && oneauthErr.IsAuthFailed()
+}
+
+// 判断错误是否为oneauth限流或服务端错误
+func IsOneauthThrottled(err error) bool {
+	var oneauthErr *OneauthError
+	return errors.As(err, &oneauthErr) && oneauthErr.IsThrottled()
+}
+
+// 判断请求失败后能否重试，创建类接口不是幂等的，只有确认服务端未处理时才重试
+func ShouldRetry(err error) bool {
+	var oneauthErr *OneauthError
+	if !errors.As(err, &oneauthErr) || !oneauthErr.IsTransient() {
+		return false
+	}
+
+	idempotent := oneauthErr.Method != http.MethodPost
+	if oneauthErr.Err != nil {
+		if idempotent {
+			return true
+		}
+
+		// 连接未建立，请求一定没有发出
+		var opErr *net.OpError
+		return errors.As(oneauthErr.Err, &opErr) && opErr.Op == "dial"
+	}
+
+	switch oneauthErr.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	}
+
+	return idempotent
+}
+
+// 解析Retry-After响应头，支持秒数和http时间格式
+func ParseRetryAfter(value string) time.Duration {
+	if len(value) == 0 {
+		return 0
+	}
+
+	if sec, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+		if sec < 0 {
+			return 0
+		}
+		return time.Duration(sec) * time.Second
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
+
+// 计算第attempt次失败后的等待时间，指数退避并加入随机抖动
+func RetryBackoff(retry RetryInfo, attempt int, retryAfter time.Duration) time.Duration {
+	wait := retry.BackoffDuration
+	for i := 1; i < attempt && wait < retry.MaxBackoffDuration; i++ {
+		wait *= 2
+	}
+	if wait > retry.MaxBackoffDuration {
+		wait = retry.MaxBackoffDuration
+	}
+
+	// 在[wait/2, wait]之间随机，避免所有协程同时重试
+	if wait > 1 {
+		wait = wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+	}
+
+	if retryAfter > wait {
+		wait = retryAfter
+	}
+	if wait > maxRetryAfter {
+		wait = maxRetryAfter
+	}
+
+	return wait
+}
@@ -0,0 +1,160 @@
+package agent
+
+import (
+	"errors"
+	"time"
+)
+
+// 同步正在执行时再次触发返回的错误
+var ErrSyncRunning = errors.New("sync already in progress")
+
+// 进程退出中，不再开始新的同步
+var ErrShuttingDown = errors.New("agent is shutting down")
+
+// 同步因进程退出、取消或超时中断，剩余任务未执行
+var ErrSyncInterrupted = errors.New("sync interrupted")
+
+// 同步被取消或超时，errors.Is可以同时判断ErrSyncInterrupted和context的错误
+type interruptedError struct {
+	cause error
+}
+
+func (e *interruptedError) Error() string {
+	return ErrSyncInterrupted.Error() + ": " + e.cause.Error()
+}
+
+func (e *interruptedError) Is(target error) bool {
+	return target == ErrSyncInterrupted
+}
+
+func (e *interruptedError) Unwrap() error {
+	return e.cause
+}
+
+// 单次同步最多记录的错误条数
+const maxStatusErrors = 100
+
+// 一次同步的执行状态
+type SyncRunStatus struct {
+	Trigger   string                    `json:"trigger"` // 触发方式，timer或api
+	StartTime time.Time                 `json:"startTime"`
+	EndTime   time.Time                 `json:"endTime,omitempty"`
+	Running   bool                      `json:"running"`
+	Success   bool                      `json:"success"`
+	Error     string                    `json:"error,omitempty"`  // 导致同步中止的错误
+	Planned   map[string]map[string]int `json:"planned"`          // 计划执行的任务数，按org/user和操作类型统计
+	Applied   map[string]map[string]int `json:"applied"`          // 执行成功的任务数
+	Failed    map[string]map[string]int `json:"failed"`           // 执行失败的任务数
+	Errors    []string                  `json:"errors,omitempty"` // 任务执行失败的详细信息
+}
+
+// 开始记录新的同步状态
+func (s *Syncer) BeginSyncStatus(trigger string) {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+
+	MetricSyncRunning.Set(1, s.Name())
+	s.status = &SyncRunStatus{
+		Trigger:   trigger,
+		StartTime: time.Now(),
+		Running:   true,
+		Planned:   map[string]map[string]int{"org": {}, "user": {}},
+		Applied:   map[string]map[string]int{"org": {}, "user": {}},
+		Failed:    map[string]map[string]int{"org": {}, "user": {}},
+	}
+	s.completed = nil
+	s.results = nil
+}
+
+// 记录计划执行的任务数量
+func (s *Syncer) RecordSyncPlanned(summary map[string]map[string]int) {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+
+	if s.status == nil {
+		return
+	}
+
+	for kind, counts := range summary {
+		for action, count := range counts {
+			s.status.Planned[kind][action] = count
+		}
+	}
+}
+
+// 记录单个任务的执行结果，kind为org或user，code为部门或人员编码
+func (s *Syncer) RecordSyncResult(kind, action, code string, err error) {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+
+	if s.status == nil || !s.status.Running {
+		return
+	}
+
+	s.results = append(s.results, taskResult{kind: kind, action: action, code: code, err: err})
+	if err == nil {
+		MetricSyncTasks.Inc(s.Name(), kind, action, "success")
+		s.status.Applied[kind][action]++
+		s.completed = append(s.completed, kind+" "+action+" "+code)
+		return
+	}
+
+	MetricSyncTasks.Inc(s.Name(), kind, action, "failure")
+	s.status.Failed[kind][action]++
+	if len(s.status.Errors) < maxStatusErrors {
+		s.status.Errors = append(s.status.Errors, kind+" "+action+" "+code+": "+err.Error())
+	}
+}
+
+// 结束本次同步状态记录，err为导致同步中止的错误
+func (s *Syncer) EndSyncStatus(err error) {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+
+	if s.status == nil {
+		return
+	}
+
+	s.status.Running = false
+	s.status.EndTime = time.Now()
+	MetricSyncRunning.Set(0, s.Name())
+	MetricSyncDuration.Observe(s.status.EndTime.Sub(s.status.StartTime).Seconds(), s.Name())
+	if err != nil {
+		s.status.Error = err.Error()
+		MetricSyncRuns.Inc(s.Name(), "failure")
+		return
+	}
+
+	s.status.Success = true
+	s.lastSuccess = s.status.EndTime
+	MetricSyncRuns.Inc(s.Name(), "success")
+	MetricLastSuccess.Set(float64(s.lastSuccess.Unix()), s.Name())
+}
+
+// 获取同步状态副本，用于对外展示
+func (s *Syncer) GetSyncStatus() (SyncRunStatus, time.Time, bool) {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+
+	if s.status == nil {
+		return SyncRunStatus{}, s.lastSuccess, false
+	}
+
+	status := *s.status
+	status.Errors = append([]string(nil), s.status.Errors...)
+	status.Planned = copyCounts(s.status.Planned)
+	status.Applied = copyCounts(s.status.Applied)
+	status.Failed = copyCounts(s.status.Failed)
+	return status, s.lastSuccess, true
+}
+
+func copyCounts(src map[string]map[string]int) map[string]map[string]int {
+	dst := make(map[string]map[string]int, len(src))
+	for kind, counts := range src {
+		dst[kind] = make(map[string]int, len(counts))
+		for action, count := range counts {
+			dst[kind][action] = count
+		}
+	}
+	return dst
+}
@@ -0,0 +1,94 @@
+package agent
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 触发批量删除保护时返回的错误
+type SafetyError struct {
+	Reasons []string
+}
+
+func (e *SafetyError) Error() string {
+	return "sync aborted by safety threshold: " + strings.Join(e.Reasons, "; ")
+}
+
+// 判断删除数量是否超过数量或比例阈值
+func overThreshold(count, total, max, maxPercent int) bool {
+	if max > 0 && count > max {
+		return true
+	}
+
+	if maxPercent > 0 && total > 0 && count*100 > total*maxPercent {
+		return true
+	}
+
+	return false
+}
+
+// 判断主数据总数是否相比上次同步减少过多
+func sourceDropped(count, last, maxPercent int) bool {
+	if maxPercent <= 0 || last <= 0 || count >= last {
+		return false
+	}
+
+	return (last-count)*100 > last*maxPercent
+}
+
+// 检查本次同步的删除数量，超过阈值时返回SafetyError，调用方不能执行任务
+func (s *Syncer) CheckSyncSafety(tasks *SyncTasks) error {
+	safety := s.Config.Database.Safety
+	var reasons []string
+
+	// 比对基准数据的总量
+	baseOrgs, baseUsers := len(s.orgMapBak), len(s.membersBak)
+	if s.upstreamExtraKey != nil {
+		baseOrgs, baseUsers = len(s.upstreamExtraKey), len(s.upstreamUsers)
+	}
+
+	orgDelete := 0
+	tasks.OrgDel.Range(func(v interface{}) bool {
+		if v.(*DataOrgMemNode).Action&(1<<3) != 0 {
+			orgDelete++
+		}
+		return true
+	})
+
+	userDelete := 0
+	tasks.Users.Range(func(v interface{}) bool {
+		if v.(*DataApiEmpNode).Action&(1<<3) != 0 {
+			userDelete++
+		}
+		return true
+	})
+
+	if overThreshold(orgDelete, baseOrgs, safety.MaxOrgDelete, safety.MaxOrgDeletePercent) {
+		reasons = append(reasons, fmt.Sprintf("delete %d of %d orgs, limit %d / %d%%",
+			orgDelete, baseOrgs, safety.MaxOrgDelete, safety.MaxOrgDeletePercent))
+	}
+
+	if overThreshold(userDelete, baseUsers, safety.MaxUserDelete, safety.MaxUserDeletePercent) {
+		reasons = append(reasons, fmt.Sprintf("delete %d of %d users, limit %d / %d%%",
+			userDelete, baseUsers, safety.MaxUserDelete, safety.MaxUserDeletePercent))
+	}
+
+	// 增量同步时主数据只返回变更，合并后的总数不会减少，只在全量同步时检查主数据总数
+	if s.fullSync && sourceDropped(s.sourceOrgCount, s.sourceOrgCountBak, safety.MaxSourceDropPercent) {
+		reasons = append(reasons, fmt.Sprintf("source orgs dropped from %d to %d, limit %d%%",
+			s.sourceOrgCountBak, s.sourceOrgCount, safety.MaxSourceDropPercent))
+	}
+
+	if s.fullSync && sourceDropped(s.sourceEmpCount, s.sourceEmpCountBak, safety.MaxSourceDropPercent) {
+		reasons = append(reasons, fmt.Sprintf("source users dropped from %d to %d, limit %d%%",
+			s.sourceEmpCountBak, s.sourceEmpCount, safety.MaxSourceDropPercent))
+	}
+
+	if len(reasons) == 0 {
+		return nil
+	}
+
+	err := &SafetyError{Reasons: reasons}
+	s.log.Error("[safety] ", err.Error())
+	return err
+}
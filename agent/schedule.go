@@ -0,0 +1,209 @@
+package agent
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 同步计划，返回t之后的下一次执行时间
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+// 固定间隔执行，如every 30m
+type IntervalSchedule struct {
+	Interval time.Duration
+}
+
+func (s IntervalSchedule) Next(t time.Time) time.Time {
+	return t.Add(s.Interval)
+}
+
+// 每天固定时间执行，兼容readtime配置，下一次执行时间为第二天的指定时间
+type DailySchedule struct {
+	Offset   time.Duration // 相对0点的时间差
+	Location *time.Location
+}
+
+func (s DailySchedule) Next(t time.Time) time.Time {
+	next := t.In(s.Location).Add(time.Hour * 24)
+	next = time.Date(next.Year(), next.Month(), next.Day(), 0, 0, 0, 0, s.Location)
+	return next.Add(s.Offset)
+}
+
+// 标准5段cron表达式：分 时 日 月 周
+type CronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// 日和周都有限制时，满足其一即可
+	domStar, dowStar bool
+	Location         *time.Location
+}
+
+// cron每一段的取值范围
+type cronBounds struct {
+	min, max int
+}
+
+var cronFieldBounds = []cronBounds{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+var cronFieldNames = []string{"minute", "hour", "day of month", "month", "day of week"}
+
+// 解析cron的一段，支持*、a-b、*/n、a-b/n和逗号分隔的列表
+func parseCronField(field string, bounds cronBounds) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rangePart = part[:i]
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", part)
+			}
+		}
+
+		start, end := bounds.min, bounds.max
+		if rangePart != "*" {
+			if i := strings.Index(rangePart, "-"); i >= 0 {
+				var err1, err2 error
+				start, err1 = strconv.Atoi(rangePart[:i])
+				end, err2 = strconv.Atoi(rangePart[i+1:])
+				if err1 != nil || err2 != nil {
+					return 0, fmt.Errorf("invalid range %q", part)
+				}
+			} else {
+				var err error
+				if start, err = strconv.Atoi(rangePart); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+				// 单个值带步长时表示从该值开始到最大值
+				if step > 1 {
+					end = bounds.max
+				} else {
+					end = start
+				}
+			}
+		}
+
+		if start < bounds.min || end > bounds.max || start > end {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, bounds.min, bounds.max)
+		}
+
+		for i := start; i <= end; i += step {
+			bits |= 1 << uint(i)
+		}
+	}
+
+	return bits, nil
+}
+
+// 以*开头的一段不限制取值，带步长的*/n也按*处理，与标准cron的日、周规则一致
+func cronFieldStar(field string) bool {
+	return strings.HasPrefix(field, "*")
+}
+
+// 解析5段cron表达式
+func ParseCron(spec string, loc *time.Location) (*CronSchedule, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron %q must have 5 fields", spec)
+	}
+
+	var bits [5]uint64
+	for i, field := range fields {
+		var err error
+		if bits[i], err = parseCronField(field, cronFieldBounds[i]); err != nil {
+			return nil, fmt.Errorf("cron %q %s: %v", spec, cronFieldNames[i], err)
+		}
+	}
+
+	// 周日可以写成0或7
+	if bits[4]&(1<<7) != 0 {
+		bits[4] |= 1
+	}
+
+	return &CronSchedule{
+		minute:   bits[0],
+		hour:     bits[1],
+		dom:      bits[2],
+		month:    bits[3],
+		dow:      bits[4],
+		domStar:  cronFieldStar(fields[2]),
+		dowStar:  cronFieldStar(fields[4]),
+		Location: loc,
+	}, nil
+}
+
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	t = t.In(s.Location).Truncate(time.Minute).Add(time.Minute)
+
+	// 最多查找5年，防止2月30日这类永远无法满足的表达式死循环
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, s.Location)
+			continue
+		}
+
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, s.Location)
+			continue
+		}
+
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, s.Location)
+			continue
+		}
+
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+
+		return t
+	}
+
+	return time.Time{}
+}
+
+// 解析同步计划，支持5段cron表达式和every 30m格式的固定间隔
+func ParseSchedule(spec string, loc *time.Location) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "every ") {
+		interval, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "every ")))
+		if err != nil {
+			return nil, fmt.Errorf("interval %q: %v", spec, err)
+		}
+		if interval < time.Minute {
+			return nil, fmt.Errorf("interval %q must not be less than 1m", spec)
+		}
+		return IntervalSchedule{Interval: interval}, nil
+	}
+
+	return ParseCron(spec, loc)
+}
+
+// 获取所有计划中最近的下一次执行时间
+func NextScheduleTime(schedules []Schedule, now time.Time) time.Time {
+	var next time.Time
+	for _, schedule := range schedules {
+		t := schedule.Next(now)
+		if t.IsZero() {
+			continue
+		}
+		if next.IsZero() || t.Before(next) {
+			next = t
+		}
+	}
+
+	return next
+}
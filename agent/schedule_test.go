@@ -0,0 +1,76 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseScheduleErrors(t *testing.T) {
+	cases := []struct {
+		spec, expected string
+	}{
+		{"", "must have 5 fields"},
+		{"* * * *", "must have 5 fields"},
+		{"* * * * * *", "must have 5 fields"},
+		{"60 * * * *", "minute: \"60\" out of range 0-59"},
+		{"* 24 * * *", "hour: \"24\" out of range 0-23"},
+		{"* * 0 * *", "day of month: \"0\" out of range 1-31"},
+		{"* * * 13 *", "month: \"13\" out of range 1-12"},
+		{"* * * * 8", "day of week: \"8\" out of range 0-7"},
+		{"5-1 * * * *", "out of range"},
+		{"*/0 * * * *", "invalid step"},
+		{"a * * * *", "invalid value"},
+		{"1-b * * * *", "invalid range"},
+		{"every 30s", "must not be less than 1m"},
+		{"every often", "interval \"every often\""},
+	}
+	for _, c := range cases {
+		_, err := ParseSchedule(c.spec, time.UTC)
+		if err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("ParseSchedule(%q): expected %q, got %v", c.spec, c.expected, err)
+		}
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	cst := time.FixedZone("CST", 8*3600)
+	date := func(loc *time.Location, year int, month time.Month, day, hour, min int) time.Time {
+		return time.Date(year, month, day, hour, min, 0, 0, loc)
+	}
+
+	cases := []struct {
+		spec     string
+		loc      *time.Location
+		from     time.Time
+		expected time.Time
+	}{
+		{"every 30m", time.UTC, date(time.UTC, 2024, 1, 1, 10, 15), date(time.UTC, 2024, 1, 1, 10, 45)},
+		{"*/15 * * * *", time.UTC, date(time.UTC, 2024, 1, 1, 10, 15), date(time.UTC, 2024, 1, 1, 10, 30)},
+		// 跨月、跨年和闰年
+		{"0 0 1 * *", time.UTC, date(time.UTC, 2024, 1, 31, 12, 0), date(time.UTC, 2024, 2, 1, 0, 0)},
+		{"30 23 31 12 *", time.UTC, date(time.UTC, 2024, 12, 31, 23, 30), date(time.UTC, 2025, 12, 31, 23, 30)},
+		{"0 0 29 2 *", time.UTC, date(time.UTC, 2023, 3, 1, 0, 0), date(time.UTC, 2024, 2, 29, 0, 0)},
+		{"0 0 30 2 *", time.UTC, date(time.UTC, 2024, 1, 1, 0, 0), time.Time{}},
+		// 周日可以写成7
+		{"0 9 * * 7", time.UTC, date(time.UTC, 2024, 10, 1, 0, 0), date(time.UTC, 2024, 10, 6, 9, 0)},
+		// 日和周都有限制时满足其一即可：2024-10-13为周日，2024-10-18为周五
+		{"0 0 13 * 5", time.UTC, date(time.UTC, 2024, 10, 12, 0, 0), date(time.UTC, 2024, 10, 13, 0, 0)},
+		{"0 0 13 * 5", time.UTC, date(time.UTC, 2024, 10, 14, 0, 0), date(time.UTC, 2024, 10, 18, 0, 0)},
+		// 带步长的*不算限制，日和周需要同时满足：2024-10-07为周一，2024-12-01为周日
+		{"0 0 */2 * 1", time.UTC, date(time.UTC, 2024, 10, 1, 0, 0), date(time.UTC, 2024, 10, 7, 0, 0)},
+		{"0 0 1 * */2", time.UTC, date(time.UTC, 2024, 10, 2, 0, 0), date(time.UTC, 2024, 12, 1, 0, 0)},
+		// 按配置的时区计算
+		{"0 2 * * *", cst, date(time.UTC, 2024, 10, 1, 0, 0), date(cst, 2024, 10, 2, 2, 0)},
+		{"0 2 * * *", cst, date(time.UTC, 2024, 9, 30, 17, 0), date(cst, 2024, 10, 1, 2, 0)},
+	}
+	for _, c := range cases {
+		schedule, err := ParseSchedule(c.spec, c.loc)
+		if err != nil {
+			t.Fatalf("ParseSchedule(%q): %v", c.spec, err)
+		}
+		if next := schedule.Next(c.from); !next.Equal(c.expected) {
+			t.Errorf("%q next after %v: expected %v, got %v", c.spec, c.from, c.expected, next)
+		}
+	}
+}
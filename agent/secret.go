@@ -0,0 +1,61 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// 敏感配置项，打印、json和yaml输出时都会被隐藏
+type Secret string
+
+const redacted = "******"
+
+func (s Secret) String() string {
+	if len(s) == 0 {
+		return ""
+	}
+	return redacted
+}
+
+func (s Secret) GoString() string {
+	return fmt.Sprintf("%q", s.String())
+}
+
+func (s Secret) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+func (s Secret) MarshalYAML() (interface{}, error) {
+	return s.String(), nil
+}
+
+// 按环境变量、文件、配置文件中的值的顺序获取敏感配置项，返回值是否直接写在配置文件中
+func ResolveSecret(secret *Secret, env, file string) (bool, error) {
+	if len(env) > 0 {
+		value := os.Getenv(env)
+		if len(value) == 0 {
+			return false, fmt.Errorf("environment variable %s is empty", env)
+		}
+		*secret = Secret(value)
+		return false, nil
+	}
+
+	if len(file) > 0 {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return false, err
+		}
+
+		value := strings.TrimSpace(string(data))
+		if len(value) == 0 {
+			return false, fmt.Errorf("secret file %s is empty", file)
+		}
+		*secret = Secret(value)
+		return false, nil
+	}
+
+	return len(*secret) > 0, nil
+}
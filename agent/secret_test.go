@@ -0,0 +1,88 @@
+package agent
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+func secretTestConfig() *Config {
+	config := DefaultConfig()
+	config.Database.Host, config.Database.Port = "datapub", "443"
+	config.Database.User.Appkey = "appkey"
+	config.Database.DefaultTree = e2eDefault
+	config.Oneauth.RootName = e2eRoot
+	config.Oneauth.Upstream.Host, config.Oneauth.Upstream.Port = "oneauth", "443"
+	return config
+}
+
+// 环境变量和文件中的值覆盖配置文件，直接写在配置文件中时给出警告
+func TestSecretOverrides(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "appsecret")
+	if err := ioutil.WriteFile(file, []byte("file-secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_ONEAUTH_TOKEN", "env-token")
+
+	config := secretTestConfig()
+	config.Oneauth.Token = "inline-token"
+	config.Oneauth.TokenEnv = "TEST_ONEAUTH_TOKEN"
+	config.Database.User.Appsecret = "inline-secret"
+	config.Database.User.AppsecretFile = file
+	if err := config.Init(); err != nil {
+		t.Fatal("init: ", err)
+	}
+
+	if config.Oneauth.Token != "env-token" || config.Database.User.Appsecret != "file-secret" {
+		t.Fatal("secrets not overridden: ", string(config.Oneauth.Token), string(config.Database.User.Appsecret))
+	}
+	if len(config.Warnings()) != 0 {
+		t.Fatal("no warning expected, got: ", config.Warnings())
+	}
+
+	config = secretTestConfig()
+	config.Oneauth.Token = "inline-token"
+	config.Database.User.Appsecret = "inline-secret"
+	if err := config.Init(); err != nil {
+		t.Fatal("init: ", err)
+	}
+	if warnings := strings.Join(config.Warnings(), "\n"); !strings.Contains(warnings, "oneauth.token") ||
+		!strings.Contains(warnings, "database.user.appsecret") {
+		t.Fatal("inline secrets should be warned, got: ", warnings)
+	}
+
+	config = secretTestConfig()
+	config.Oneauth.TokenEnv = "TEST_ONEAUTH_TOKEN_UNSET"
+	config.Database.User.Appsecret = "inline-secret"
+	if err := config.Init(); err == nil || !strings.Contains(err.Error(), "TEST_ONEAUTH_TOKEN_UNSET") {
+		t.Fatal("unset environment variable should fail, got: ", err)
+	}
+}
+
+// 打印和导出配置时不包含敏感信息
+func TestSecretRedaction(t *testing.T) {
+	config := secretTestConfig()
+	config.Oneauth.Token = "token-value"
+	config.Database.User.Appsecret = "secret-value"
+	config.System.Admin.Token = "admin-value"
+	config.Jobs = nil
+	if err := config.Init(); err != nil {
+		t.Fatal("init: ", err)
+	}
+
+	jsonData, _ := json.Marshal(config)
+	yamlData, _ := yaml.Marshal(config)
+	dumps := []string{fmt.Sprint(*config), fmt.Sprintf("%+v", *config), fmt.Sprintf("%#v", *config), string(jsonData), string(yamlData)}
+	for _, dump := range dumps {
+		for _, secret := range []string{"token-value", "secret-value", "admin-value"} {
+			if strings.Contains(dump, secret) {
+				t.Fatalf("config dump contains %s: %s", secret, dump)
+			}
+		}
+	}
+}
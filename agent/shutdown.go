@@ -0,0 +1,161 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 同步标记文件的后缀，和快照文件放在一起
+const syncMarkerSuffix = ".running"
+
+// 未完成的同步记录，标记文件存在且没有保存快照时快照和oneauth的数据可能不一致
+type SyncMarker struct {
+	Trigger   string    `json:"trigger"`
+	StartTime time.Time `json:"startTime"`
+	EndTime   time.Time `json:"endTime,omitempty"`   // 为空表示进程在同步过程中异常退出
+	Error     string    `json:"error,omitempty"`     // 同步中断的原因
+	Completed []string  `json:"completed,omitempty"` // 已成功执行的任务，如user create E1
+	Failed    []string  `json:"failed,omitempty"`    // 执行失败的任务
+	Snapshot  bool      `json:"snapshot,omitempty"`  // 中断后已保存快照，快照中记录了已完成的任务和未完成任务的状态
+}
+
+func (s *Syncer) syncMarkerPath() string {
+	if len(s.Config.System.Snapshot.Path) == 0 {
+		return ""
+	}
+	return s.Config.System.Snapshot.Path + syncMarkerSuffix
+}
+
+// 写入同步标记，err为空时表示同步开始，否则记录中断原因、已完成的任务以及快照是否已保存
+func (s *Syncer) WriteSyncMarker(err error, snapshot bool) error {
+	path := s.syncMarkerPath()
+	if len(path) == 0 {
+		return nil
+	}
+
+	s.statusLock.Lock()
+	marker := SyncMarker{Snapshot: snapshot}
+	if s.status != nil {
+		marker.Trigger = s.status.Trigger
+		marker.StartTime = s.status.StartTime
+		if err != nil {
+			marker.EndTime = time.Now()
+			marker.Error = err.Error()
+			marker.Completed = append(marker.Completed, s.completed...)
+			marker.Failed = append(marker.Failed, s.status.Errors...)
+		}
+	}
+	s.statusLock.Unlock()
+
+	data, err := json.MarshalIndent(marker, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if dir := filepath.Dir(path); len(dir) > 0 {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			s.log.Error("[snapshot] create sync marker dir error: ", err)
+			return err
+		}
+	}
+
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		s.log.Error("[snapshot] write sync marker error: ", err)
+		return err
+	}
+	return nil
+}
+
+// 同步完成并保存快照后删除同步标记
+func (s *Syncer) RemoveSyncMarker() {
+	path := s.syncMarkerPath()
+	if len(path) == 0 {
+		return
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		s.log.Error("[snapshot] remove sync marker error: ", err)
+	}
+}
+
+// 读取上一次未完成的同步记录，不存在时返回nil
+func (s *Syncer) ReadSyncMarker() (*SyncMarker, error) {
+	path := s.syncMarkerPath()
+	if len(path) == 0 {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// 标记文件损坏时同样认为上一次同步未完成
+	marker := new(SyncMarker)
+	json.Unmarshal(data, marker)
+	return marker, nil
+}
+
+// 超时取消同步后，等待同步记录已完成任务的最长时间
+const shutdownCancelWait = 5 * time.Second
+
+// 停止所有任务，不再开始新的同步，等待正在执行的请求在timeout内完成，超时后取消这些请求
+// 返回所有任务最近一次同步是否完整执行，超时或有任务未完成时返回false
+func (m *Manager) Shutdown(timeout time.Duration) bool {
+	for _, syncer := range m.Syncers {
+		syncer.Stop()
+	}
+
+	// 每个任务同步结束后才能拿到锁
+	locked := make([]chan struct{}, len(m.Syncers))
+	for i, syncer := range m.Syncers {
+		locked[i] = make(chan struct{})
+		go func(s *Syncer, done chan struct{}) {
+			s.lock.Lock()
+			close(done)
+		}(syncer, locked[i])
+	}
+
+	deadline := time.After(timeout)
+	complete := true
+	for i, syncer := range m.Syncers {
+		select {
+		case <-locked[i]:
+		case <-deadline:
+			log.Error("[shutdown] sync still running after ", timeout, ", cancel running requests")
+			for _, s := range m.Syncers {
+				s.Cancel()
+			}
+
+			// 取消后仍需要一点时间记录已完成的任务和保存快照，剩余的任务共用等待时间
+			ctx, cancel := context.WithTimeout(context.Background(), shutdownCancelWait)
+			for j := i; j < len(m.Syncers); j++ {
+				select {
+				case <-locked[j]:
+				case <-ctx.Done():
+					log.Error("[shutdown] job ", m.Syncers[j].Name(), " did not stop after cancel, exit anyway")
+				}
+			}
+			cancel()
+			return false
+		}
+
+		status, _, ok := syncer.GetSyncStatus()
+		if ok && !status.Success {
+			syncer.log.Warn("[shutdown] last sync is incomplete: ", status.Error)
+			complete = false
+		}
+	}
+
+	log.Info("[shutdown] all jobs stopped, last sync complete: ", complete)
+	return complete
+}
@@ -0,0 +1,136 @@
+package agent
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// 第一次创建人员时模拟收到退出信号
+type stopOnCreateTarget struct {
+	Target
+	syncer *Syncer
+	once   sync.Once
+}
+
+func (t *stopOnCreateTarget) CreateUser(ctx context.Context, user *DataApiEmpNode) (string, error) {
+	t.once.Do(t.syncer.Stop)
+	return t.Target.CreateUser(ctx, user)
+}
+
+// 退出时正在执行的请求完成后不再执行新任务，记录已完成的任务并保存快照，重启后使用快照补齐剩余任务
+func TestShutdownDuringSync(t *testing.T) {
+	env := newE2EEnv(t)
+	env.datapub.SetOrgs(baseOrgs())
+	env.datapub.SetEmps(baseEmps())
+	env.config.System.Fiber = "1"
+	env.start()
+	env.syncer.Target = &stopOnCreateTarget{Target: env.syncer.Target, syncer: env.syncer}
+
+	if err := env.syncer.Sync(context.Background(), "test"); err != ErrSyncInterrupted {
+		t.Fatal("sync should be interrupted, got: ", err)
+	}
+	if len(env.oneauth.Users()) != 1 {
+		t.Fatal("only the in-flight create should finish, got users: ", len(env.oneauth.Users()))
+	}
+
+	marker, err := env.syncer.ReadSyncMarker()
+	if err != nil || marker == nil {
+		t.Fatal("sync marker should be kept: ", err)
+	}
+	if marker.Error != ErrSyncInterrupted.Error() || !strings.Contains(strings.Join(marker.Completed, ","), "user create") {
+		t.Fatal("marker should record completed tasks: ", marker)
+	}
+	if !marker.Snapshot {
+		t.Fatal("marker should record the saved snapshot: ", marker)
+	}
+
+	manager := &Manager{Config: env.config, Syncers: []*Syncer{env.syncer}}
+	if manager.Shutdown(time.Second) {
+		t.Fatal("shutdown should report the last sync as incomplete")
+	}
+	if err := env.syncer.Sync(context.Background(), "test"); err != ErrShuttingDown {
+		t.Fatal("sync after shutdown should be refused, got: ", err)
+	}
+
+	// 重启后快照记录了已完成的部分，不需要从oneauth重新读取
+	env.restart()
+	env.oneauth.ResetRequests()
+	env.sync()
+	for _, req := range env.oneauth.Requests() {
+		if strings.HasPrefix(req, "GET /api/v1/account/user") {
+			t.Fatal("users should not be read from oneauth after restart: ", req)
+		}
+	}
+	env.assertTree(baseTree)
+	env.assertUsers(baseUsers)
+	if marker, _ := env.syncer.ReadSyncMarker(); marker != nil {
+		t.Fatal("sync marker should be removed after a complete sync")
+	}
+
+	manager = &Manager{Config: env.config, Syncers: []*Syncer{env.syncer}}
+	if !manager.Shutdown(time.Second) {
+		t.Fatal("shutdown after a complete sync should report complete")
+	}
+}
+
+// 创建人员的请求一直等到被取消
+type slowCreateTarget struct {
+	Target
+	started chan struct{}
+	once    sync.Once
+}
+
+func (t *slowCreateTarget) CreateUser(ctx context.Context, user *DataApiEmpNode) (string, error) {
+	t.once.Do(func() { close(t.started) })
+	<-ctx.Done()
+	return "", ctx.Err()
+}
+
+// 同步被慢请求阻塞时，信号处理中的重新加载不等待同步结束，随后的退出在超时时间内返回
+func TestReloadAndShutdownDuringSlowSync(t *testing.T) {
+	env := newE2EEnv(t)
+	env.datapub.SetOrgs(baseOrgs())
+	env.datapub.SetEmps(baseEmps())
+	env.start()
+	target := &slowCreateTarget{Target: env.syncer.Target, started: make(chan struct{})}
+	env.syncer.Target = target
+
+	synced := make(chan error, 1)
+	go func() { synced <- env.syncer.Sync(context.Background(), "test") }()
+	<-target.started
+
+	config := *env.config
+	config.System.Fiber = "2"
+	if err := config.Init(); err != nil {
+		t.Fatal("config init: ", err)
+	}
+
+	// 与信号处理相同，在同一个协程中先重新加载再退出，和NewManager一样使用单独的配置副本
+	managerConfig := *env.config
+	manager := &Manager{Config: &managerConfig, Syncers: []*Syncer{env.syncer}}
+	stopped := make(chan bool, 1)
+	go func() {
+		if _, err := manager.Reload(&config); err != nil {
+			t.Error("reload: ", err)
+		}
+		stopped <- manager.Shutdown(100 * time.Millisecond)
+	}()
+
+	select {
+	case complete := <-stopped:
+		if complete {
+			t.Fatal("shutdown should report the cancelled sync as incomplete")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("shutdown after reload should return within the deadline")
+	}
+	if err := <-synced; err == nil {
+		t.Fatal("cancelled sync should fail")
+	}
+	if env.config.System.Fiber != "4" {
+		t.Fatal("reloaded config should not be applied during sync")
+	}
+}
@@ -0,0 +1,357 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// 快照文件格式版本，结构不兼容时需要增加
+const SnapshotVersion = 1
+
+// 快照中的部门信息
+type SnapshotOrg struct {
+	Code       string `json:"code"`
+	Name       string `json:"name"`
+	ParentCode string `json:"parentCode,omitempty"`
+	Root       bool   `json:"root,omitempty"`
+	OrgId      string `json:"orgId"`
+	DepId      string `json:"depId"`
+	FatherId   string `json:"fatherId,omitempty"`
+	LeaderCode string `json:"leaderCode,omitempty"`
+	ManagerId  string `json:"managerId,omitempty"`
+
+	SyncStatus string `json:"syncStatus,omitempty"` // 为空表示applied
+	SyncError  string `json:"syncError,omitempty"`
+}
+
+// 快照中的人员信息
+type SnapshotMember struct {
+	UserCode string `json:"userCode"`
+	UserName string `json:"userName"`
+	Email    string `json:"email"`
+	OAID     string `json:"oaid"`
+	Status   string `json:"status"`
+	OrgCode  string `json:"orgCode"`
+	Id       string `json:"id"`
+	OrgId    string `json:"orgId"`
+	DepId    string `json:"depId"`
+
+	ManagerCode string `json:"managerCode,omitempty"`
+	ManagerId   string `json:"managerId,omitempty"`
+
+	SyncStatus string `json:"syncStatus,omitempty"` // 为空表示applied
+	SyncError  string `json:"syncError,omitempty"`
+}
+
+// 最后一次同步后已同步到oneauth的数据快照
+type Snapshot struct {
+	Version  int              `json:"version"`
+	SavedAt  time.Time        `json:"savedAt"`
+	RootName string           `json:"rootName"`
+	Orgs     []SnapshotOrg    `json:"orgs"`
+	Members  []SnapshotMember `json:"members"`
+	// 主数据接口返回的原始数量，用于判断数据是否被截断
+	SourceOrgCount int `json:"sourceOrgCount,omitempty"`
+	SourceEmpCount int `json:"sourceEmpCount,omitempty"`
+	// 增量同步使用的全量原始数据和updateDate水位
+	SourceOrgs   []DataApiOrgNode `json:"sourceOrgs,omitempty"`
+	SourceEmps   []DataApiEmpNode `json:"sourceEmps,omitempty"`
+	OrgWatermark string           `json:"orgWatermark,omitempty"`
+	EmpWatermark string           `json:"empWatermark,omitempty"`
+	LastFullSync time.Time        `json:"lastFullSync,omitempty"`
+}
+
+// 将备份数据写入快照文件，先写临时文件再改名，防止写入中断导致文件损坏
+func (s *Syncer) SaveSnapshot(path string) error {
+	if len(path) == 0 || s.realOrgBak == nil {
+		return nil
+	}
+
+	snapshot := Snapshot{
+		Version:  SnapshotVersion,
+		SavedAt:  time.Now(),
+		RootName: s.realOrgBak.NodeCode,
+
+		SourceOrgCount: s.sourceOrgCountBak,
+		SourceEmpCount: s.sourceEmpCountBak,
+	}
+
+	// 只有开启增量同步时才需要保存原始数据
+	if s.Config.Database.Incremental.Enable {
+		snapshot.SourceOrgs = s.sourceOrgsBak
+		snapshot.SourceEmps = s.sourceEmpsBak
+		snapshot.OrgWatermark = s.orgWatermarkBak
+		snapshot.EmpWatermark = s.empWatermarkBak
+		snapshot.LastFullSync = s.lastFullSyncBak
+	}
+
+	// 从根节点层序遍历，保证父节点在子节点之前
+	queNode := new(Queue)
+	queNode.Push(s.realOrgBak)
+	for queNode.Len() > 0 {
+		node := queNode.Pop().(*DataOrgMemNode)
+		snapshot.Orgs = append(snapshot.Orgs, snapshotOrg(node))
+
+		codes := make([]string, 0, len(node.Children))
+		for code := range node.Children {
+			codes = append(codes, code)
+		}
+		sort.Strings(codes)
+		for _, code := range codes {
+			queNode.Push(node.Children[code])
+		}
+	}
+
+	for _, user := range s.membersBak {
+		snapshot.Members = append(snapshot.Members, snapshotMember(user))
+	}
+	sort.Slice(snapshot.Members, func(i, j int) bool {
+		return snapshot.Members[i].UserCode < snapshot.Members[j].UserCode
+	})
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		s.log.Error("[snapshot] marshal snapshot error: ", err)
+		return err
+	}
+
+	if dir := filepath.Dir(path); len(dir) > 0 {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			s.log.Error("[snapshot] create snapshot dir error: ", err)
+			return err
+		}
+	}
+
+	tmpPath := path + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, data, 0600); err != nil {
+		s.log.Error("[snapshot] write snapshot error: ", err)
+		return err
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		s.log.Error("[snapshot] rename snapshot error: ", err)
+		return err
+	}
+
+	s.log.Info("[snapshot] save snapshot success, orgs: ", len(snapshot.Orgs), ", members: ", len(snapshot.Members))
+	return nil
+}
+
+// 备份的部门转换为快照中的部门信息
+func snapshotOrg(node *DataOrgMemNode) SnapshotOrg {
+	org := SnapshotOrg{
+		Code:     node.NodeCode,
+		Name:     node.NodeName,
+		Root:     node.Root,
+		OrgId:    node.OrgId,
+		DepId:    node.DepId,
+		FatherId: node.FatherId,
+
+		LeaderCode: node.LeaderCode,
+		ManagerId:  node.ManagerId,
+
+		SyncStatus: node.SyncStatus,
+		SyncError:  node.SyncError,
+	}
+	if node.parent != nil {
+		org.ParentCode = node.parent.NodeCode
+	}
+	return org
+}
+
+// 快照中的部门信息转换为部门节点，不包含上下级关系
+func newOrgNode(org SnapshotOrg) *DataOrgMemNode {
+	node := new(DataOrgMemNode)
+	node.NodeCode = org.Code
+	node.NodeName = org.Name
+	node.OuName = org.Name + "(" + org.Code + ")"
+	node.Root = org.Root
+	node.OrgId = org.OrgId
+	node.DepId = org.DepId
+	node.FatherId = org.FatherId
+	node.LeaderCode = org.LeaderCode
+	node.ManagerId = org.ManagerId
+	node.SyncStatus = org.SyncStatus
+	node.SyncError = org.SyncError
+	node.Children = make(map[string]*DataOrgMemNode)
+	node.Value = &DataApiOrgNode{OrgUnitCode: org.Code, OrgUnitName: org.Name, UpperOrgUnitCode: org.ParentCode, Status: "1"}
+	return node
+}
+
+// 备份的人员转换为快照中的人员信息
+func snapshotMember(user *DataApiEmpNode) SnapshotMember {
+	return SnapshotMember{
+		UserCode: user.UserCode,
+		UserName: user.UserName,
+		Email:    user.Email,
+		OAID:     user.OAID,
+		Status:   user.Status,
+		OrgCode:  user.OrgCode,
+		Id:       user.Id,
+		OrgId:    user.OrgId,
+		DepId:    user.DepId,
+
+		ManagerCode: user.ManagerCode,
+		ManagerId:   user.ManagerId,
+
+		SyncStatus: user.SyncStatus,
+		SyncError:  user.SyncError,
+	}
+}
+
+// 快照中的人员信息转换为人员数据
+func memberNode(member SnapshotMember) *DataApiEmpNode {
+	user := new(DataApiEmpNode)
+	user.UserCode = member.UserCode
+	user.UserName = member.UserName
+	user.Email = member.Email
+	user.OAID = member.OAID
+	user.Status = member.Status
+	user.OrgCode = member.OrgCode
+	user.Id = member.Id
+	user.OrgId = member.OrgId
+	user.DepId = member.DepId
+	user.ManagerCode = member.ManagerCode
+	user.ManagerId = member.ManagerId
+	user.SyncStatus = member.SyncStatus
+	user.SyncError = member.SyncError
+	return user
+}
+
+// 根据部门列表生成部门树，部门的顺序不限，返回根节点和不含根节点的部门集合
+func BuildOrgTree(orgs []SnapshotOrg) (*DataOrgMemNode, map[string]*DataOrgMemNode, error) {
+	var root *DataOrgMemNode
+	orgMap := make(map[string]*DataOrgMemNode)
+	for _, org := range orgs {
+		node := newOrgNode(org)
+		if org.Root {
+			if root != nil {
+				return nil, nil, errors.New("more than one root")
+			}
+			root = node
+			continue
+		}
+		orgMap[node.NodeCode] = node
+	}
+
+	if root == nil {
+		return nil, nil, errors.New("root not found")
+	}
+
+	for _, org := range orgs {
+		if org.Root {
+			continue
+		}
+
+		father := orgMap[org.ParentCode]
+		if org.ParentCode == root.NodeCode {
+			father = root
+		}
+		if father == nil {
+			return nil, nil, fmt.Errorf("org %s parent %s not found", org.Code, org.ParentCode)
+		}
+
+		node := orgMap[org.Code]
+		node.parent = father
+		father.Children[node.NodeCode] = node
+	}
+
+	return root, orgMap, nil
+}
+
+// 读取快照文件，恢复为备份数据
+func (s *Syncer) LoadSnapshot(path string, maxAge time.Duration) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("snapshot corrupt: %v", err)
+	}
+
+	if snapshot.Version != SnapshotVersion {
+		return fmt.Errorf("snapshot version %d not supported", snapshot.Version)
+	}
+
+	if snapshot.RootName != s.Config.Oneauth.RootName {
+		return fmt.Errorf("snapshot root %s not match config rootname", snapshot.RootName)
+	}
+
+	if maxAge > 0 && time.Since(snapshot.SavedAt) > maxAge {
+		return fmt.Errorf("snapshot saved at %s is out of date", snapshot.SavedAt.Format("2006-01-02 15:04:05"))
+	}
+
+	root, orgMap, err := BuildOrgTree(snapshot.Orgs)
+	if err != nil {
+		return fmt.Errorf("snapshot corrupt: %v", err)
+	}
+
+	membersMap := make(map[string]*DataApiEmpNode)
+	for _, member := range snapshot.Members {
+		membersMap[member.UserCode] = memberNode(member)
+	}
+
+	s.orgMapBak = orgMap
+	s.realOrgBak = root
+	s.membersBak = membersMap
+	s.sourceOrgCountBak = snapshot.SourceOrgCount
+	s.sourceEmpCountBak = snapshot.SourceEmpCount
+	s.sourceOrgsBak = snapshot.SourceOrgs
+	s.sourceEmpsBak = snapshot.SourceEmps
+	s.orgWatermarkBak = snapshot.OrgWatermark
+	s.empWatermarkBak = snapshot.EmpWatermark
+	s.lastFullSyncBak = snapshot.LastFullSync
+
+	s.log.Info("[snapshot] load snapshot saved at ", snapshot.SavedAt.Format("2006-01-02 15:04:05"),
+		", orgs: ", len(snapshot.Orgs), ", members: ", len(snapshot.Members))
+	return nil
+}
+
+// 加载比对基准数据，优先使用快照，快照不可用时从oneauth全量读取
+func (s *Syncer) LoadBaseline(ctx context.Context) error {
+	if len(s.Config.System.Snapshot.Path) == 0 {
+		return s.SyncDataFromOneAuth(ctx)
+	}
+
+	// 上一次同步中途退出且没有保存快照，快照和oneauth的数据可能不一致
+	marker, err := s.ReadSyncMarker()
+	if err != nil {
+		s.log.Warn("[snapshot] read sync marker error, read data from oneauth: ", err)
+		return s.SyncDataFromOneAuth(ctx)
+	}
+	if marker != nil && marker.Snapshot {
+		s.log.Warn("[snapshot] previous sync started at ", marker.StartTime.Format("2006-01-02 15:04:05"),
+			" was interrupted (", marker.Error, "), completed tasks: ", len(marker.Completed), ", saved in snapshot")
+	} else if marker != nil {
+		s.log.Warn("[snapshot] previous sync started at ", marker.StartTime.Format("2006-01-02 15:04:05"),
+			" did not complete (", marker.Error, "), completed tasks: ", len(marker.Completed), ", read data from oneauth")
+		return s.SyncDataFromOneAuth(ctx)
+	}
+
+	var maxAge time.Duration
+	if len(s.Config.System.Snapshot.MaxAge) > 0 {
+		maxAge, _ = time.ParseDuration(s.Config.System.Snapshot.MaxAge)
+	}
+
+	err = s.LoadSnapshot(s.Config.System.Snapshot.Path, maxAge)
+	if err == nil {
+		return nil
+	}
+
+	if os.IsNotExist(err) {
+		s.log.Info("[snapshot] snapshot not exist, read data from oneauth")
+	} else {
+		s.log.Warn("[snapshot] snapshot unavailable, read data from oneauth: ", err)
+	}
+
+	return s.SyncDataFromOneAuth(ctx)
+}
@@ -0,0 +1,170 @@
+package agent
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+var OrgUrl = "/api/service/datapub/rest/api/v1/org/queryDlpOrg?bsId="
+var MemberUrl = "/api/service/datapub/rest/api/v1/emp/queryDlpEmp?bsId="
+
+// 主数据平台的数据来源实现
+type DatapubSource struct {
+	Config *DataBase
+	client *http.Client
+	sign   string // 请求签名，appKey和bsId不变，只需计算一次
+	log    *log.Entry
+}
+
+func DatabaseConn(ctx context.Context, network, addr string) (net.Conn, error) {
+	// netAddr := &net.TCPAddr{Port: apiConfig.localPort}
+	dial := net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 60 * 5 * time.Second,
+		// LocalAddr: netAddr,
+	}
+
+	conn, err := dial.DialContext(ctx, network, addr)
+	if err != nil {
+		return conn, err
+	}
+
+	return conn, err
+}
+
+// 创建主数据来源，config需要已经通过Config.Init生成接口地址
+func NewDatapubSource(config *DataBase) *DatapubSource {
+	return &DatapubSource{
+		Config: config,
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext:         DatabaseConn,
+				MaxIdleConns:        10,
+				MaxIdleConnsPerHost: 2,
+				IdleConnTimeout:     20 * time.Second,
+				DisableKeepAlives:   false,
+				TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
+			},
+			// 超时时间由每次请求的ctx控制
+		},
+		sign: DatapubSign(config.User.Appkey, string(config.User.Appsecret), config.User.Appkey),
+		log:  log.NewEntry(log.StandardLogger()),
+	}
+}
+
+func GetSign(secret string, urlParams map[string]string, mapkey []string) string {
+	if len(mapkey) == 0 || len(urlParams) == 0 {
+		return ""
+	}
+
+	target := make([]string, 0, len(mapkey))
+	for _, key := range mapkey {
+		target = append(target, key+"="+urlParams[key])
+	}
+
+	targetStr := strings.Join(target, "&") + secret
+	return fmt.Sprintf("%x", md5.Sum([]byte(targetStr)))
+}
+
+// 生成签名串
+func DatapubSign(appKey, appSecret, bsId string) string {
+	urlParams := make(map[string]string)
+	urlParams["bsId"] = bsId
+	urlParams["appKey"] = appKey
+
+	var mapkey []string
+	mapkey = append(mapkey, "appKey")
+	mapkey = append(mapkey, "bsId")
+	sort.Strings(mapkey)
+
+	return GetSign(appSecret, urlParams, mapkey)
+}
+
+// 在主数据接口地址上附加增量参数
+func (d *DatapubSource) incrementalUrl(urlStr, since string) string {
+	param := d.Config.Incremental.Param
+	if len(param) == 0 || len(since) == 0 {
+		return urlStr
+	}
+
+	return urlStr + "&" + url.QueryEscape(param) + "=" + url.QueryEscape(since)
+}
+
+// 获取组织架构，since不为空时只获取该时间之后变更的数据
+func (d *DatapubSource) FetchOrgs(ctx context.Context, since string) ([]DataApiOrgNode, error) {
+	body, err := d.GetDatabaseApi(ctx, "queryDlpOrg", d.incrementalUrl(d.Config.OrgInterface, since))
+	if err != nil {
+		d.log.Warn("[http] api get org some error: ", err)
+		return nil, err
+	}
+
+	return ParseDataApiOrgRsp(body)
+}
+
+// 获取人员，since不为空时只获取该时间之后变更的数据
+func (d *DatapubSource) FetchEmps(ctx context.Context, since string) ([]DataApiEmpNode, error) {
+	body, err := d.GetDatabaseApi(ctx, "queryDlpEmp", d.incrementalUrl(d.Config.MemberInterface, since))
+	if err != nil {
+		d.log.Warn("[http] api get members some error: ", err)
+		return nil, err
+	}
+
+	return ParseDataApiEmpRsp(body)
+}
+
+// 获取主数据接口，api为接口名，用于统计，每次请求使用database.timeout配置的超时时间
+func (d *DatapubSource) GetDatabaseApi(ctx context.Context, api, urlStr string) ([]byte, error) {
+	if timeout := d.Config.TimeoutDuration; timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", urlStr, nil)
+	if err != nil {
+		d.log.Info("[http] create new request error: ", err)
+		return nil, err
+	}
+
+	req.Header.Set("appKey", d.Config.User.Appkey)
+	req.Header.Set("sign", d.sign)
+
+	start := time.Now()
+	resp, err := d.client.Do(req)
+	MetricSourceLatency.Observe(time.Since(start).Seconds(), api)
+	if err != nil {
+		MetricSourceRequests.Inc(api, metricCode(0))
+		d.log.Info("[http] recv http response error: ", err)
+		return nil, err
+	}
+	MetricSourceRequests.Inc(api, metricCode(resp.StatusCode))
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		d.log.Info("[http] read http response body error: ", err)
+		return nil, err
+	}
+
+	if resp.StatusCode != 200 {
+		d.log.Info(string(body))
+		return nil, errors.New("response code: " + strconv.Itoa(resp.StatusCode))
+	}
+
+	//log.Info(string(body))
+	return body, nil
+}
@@ -0,0 +1,271 @@
+package agent
+
+import (
+	"strings"
+)
+
+// 部门和人员的同步状态，记录在备份数据和快照中
+const (
+	StatusApplied = "applied" // 数据已同步到oneauth
+	StatusFailed  = "failed"  // 同步失败，保留上一次同步成功的值，下次同步时重试
+	StatusPending = "pending" // 同步中止或任务被跳过，没有执行
+)
+
+// 本次同步计划执行的操作，执行任务前记录，执行后和任务结果一起判断每个部门和人员实际同步了哪些数据
+type PlannedActions struct {
+	orgs  map[*DataOrgMemNode]int
+	users map[*DataApiEmpNode]int
+}
+
+// 记录任务队列中每个部门和人员的操作，不会改变队列内容
+func NewPlannedActions(tasks *SyncTasks) *PlannedActions {
+	planned := &PlannedActions{
+		orgs:  make(map[*DataOrgMemNode]int),
+		users: make(map[*DataApiEmpNode]int),
+	}
+
+	addOrg := func(v interface{}) bool {
+		node := v.(*DataOrgMemNode)
+		planned.orgs[node] |= node.Action
+		return true
+	}
+	tasks.OrgNew.Range(addOrg)
+	tasks.OrgUpdate.Range(addOrg)
+	tasks.OrgDel.Range(addOrg)
+
+	tasks.Users.Range(func(v interface{}) bool {
+		user := v.(*DataApiEmpNode)
+		planned.users[user] |= user.Action
+		return true
+	})
+
+	return planned
+}
+
+// 单个部门或人员本次同步的所有任务结果
+type entityResults []taskResult
+
+// 按kind code汇总本次同步的任务结果
+func (s *Syncer) entityResults() map[string]entityResults {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+
+	results := make(map[string]entityResults)
+	for _, result := range s.results {
+		key := result.kind + " " + result.code
+		results[key] = append(results[key], result)
+	}
+	return results
+}
+
+// 操作是否执行成功，update+move这类组合操作包含在内
+func (r entityResults) applied(action string) bool {
+	ok := false
+	for _, result := range r {
+		for _, name := range strings.Split(result.action, "+") {
+			if name != action {
+				continue
+			}
+			// 同一个操作可能执行多次，以最后一次结果为准
+			ok = result.err == nil
+		}
+	}
+	return ok
+}
+
+// 操作是否执行过
+func (r entityResults) executed(action string) bool {
+	for _, result := range r {
+		for _, name := range strings.Split(result.action, "+") {
+			if name == action {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 根据计划的操作和执行结果得到同步状态，有失败时返回所有错误
+func (r entityResults) status(action int) (string, string) {
+	var errs []string
+	for _, result := range r {
+		if result.err != nil {
+			errs = append(errs, result.action+": "+result.err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return StatusFailed, strings.Join(errs, "; ")
+	}
+
+	for _, bit := range []int{1 << 0, 1 << 1, 1 << 2, 1 << 3, 1 << 4} {
+		if action&bit != 0 && !r.executed(ActionName(bit)) {
+			return StatusPending, ""
+		}
+	}
+	return StatusApplied, ""
+}
+
+// 比对基准数据中的部门，基准数据为oneauth数据或上一次同步的备份
+func (s *Syncer) baselineOrg(code string) *SnapshotOrg {
+	if s.upstreamExtraKey != nil {
+		node, ok := s.upstreamExtraKey[code]
+		if !ok {
+			return nil
+		}
+		org := s.upstreamOrg(code, node)
+		return &org
+	}
+
+	if node, ok := s.orgMapBak[code]; ok {
+		org := snapshotOrg(node)
+		return &org
+	}
+	return nil
+}
+
+// oneauth中的部门转换为快照中的部门信息，只有和配置的根节点名一致的组织作为根节点
+func (s *Syncer) upstreamOrg(code string, node *DataOrgNode) SnapshotOrg {
+	return SnapshotOrg{Code: code, Name: node.Name, ParentCode: node.FatherCode, OrgId: node.OrgId, DepId: node.DepId,
+		FatherId: node.ParentId, LeaderCode: node.LeaderCode, ManagerId: node.ManagerId,
+		Root: node.DepId == node.OrgId && code == s.Config.Oneauth.RootName}
+}
+
+// 比对基准数据中的人员
+func (s *Syncer) baselineMember(code string) *DataApiEmpNode {
+	if s.upstreamUsers != nil {
+		return s.upstreamUsers[code]
+	}
+	return s.membersBak[code]
+}
+
+// 备份中部门上一次的同步状态，已同步成功时返回空
+func (s *Syncer) lastOrgStatus(code string) (string, string) {
+	node := s.orgMapBak[code]
+	if s.realOrgBak != nil && s.realOrgBak.NodeCode == code {
+		node = s.realOrgBak
+	}
+	if node == nil || len(node.SyncStatus) == 0 || node.SyncStatus == StatusApplied {
+		return "", ""
+	}
+	return node.SyncStatus, node.SyncError
+}
+
+// 备份中人员上一次的同步状态，已同步成功时返回空
+func (s *Syncer) lastUserStatus(code string) (string, string) {
+	user := s.membersBak[code]
+	if user == nil || len(user.SyncStatus) == 0 || user.SyncStatus == StatusApplied {
+		return "", ""
+	}
+	return user.SyncStatus, user.SyncError
+}
+
+// 根据任务执行结果生成已同步到oneauth的部门数据，失败和没有执行的变更保留基准数据中的值
+func (s *Syncer) AppliedOrgState(planned *PlannedActions, results map[string]entityResults) []SnapshotOrg {
+	var orgs []SnapshotOrg
+
+	addOrg := func(node *DataOrgMemNode) {
+		action := planned.orgs[node]
+		result := results["org "+node.NodeCode]
+		org := snapshotOrg(node)
+		old := s.baselineOrg(node.NodeCode)
+
+		// 创建失败的部门保留在备份中，没有oneauth id，下次同步时重新创建
+		if action&(1<<0) != 0 && !result.applied("create") {
+			org.OrgId, org.DepId, org.FatherId = "", "", ""
+			if !node.Root {
+				org.OrgId = node.OrgId
+			}
+		}
+
+		// 改名和移动在同一个任务中执行，任务失败时两者都保留原来的值，下次同步时重新执行
+		if action&(1<<1) != 0 && !result.applied("update") && old != nil {
+			org.Name = old.Name
+		}
+		if action&(1<<2) != 0 && !result.applied("move") && old != nil {
+			org.ParentCode = old.ParentCode
+			org.FatherId = old.FatherId
+		}
+
+		org.SyncStatus, org.SyncError = result.status(action)
+		orgs = append(orgs, org)
+	}
+
+	addOrg(s.realOrg)
+	for _, node := range s.orgMap {
+		addOrg(node)
+	}
+
+	// 删除失败或没有执行的部门保留原来的数据
+	for node, action := range planned.orgs {
+		if action&(1<<3) == 0 {
+			continue
+		}
+
+		result := results["org "+node.NodeCode]
+		if result.applied("delete") {
+			continue
+		}
+
+		old := s.baselineOrg(node.NodeCode)
+		if old == nil {
+			continue
+		}
+		old.SyncStatus, old.SyncError = result.status(action)
+		orgs = append(orgs, *old)
+	}
+
+	return orgs
+}
+
+// 根据任务执行结果生成已同步到oneauth的人员数据，失败和没有执行的变更保留基准数据中的值
+func (s *Syncer) AppliedMemberState(planned *PlannedActions, results map[string]entityResults) map[string]*DataApiEmpNode {
+	members := make(map[string]*DataApiEmpNode, len(s.members))
+
+	for code, user := range s.members {
+		action := planned.users[user]
+		result := results["user "+code]
+		old := s.baselineMember(code)
+
+		member := new(DataApiEmpNode)
+		*member = *user
+		member.Action = 0
+
+		// 创建失败的人员没有oneauth id，下次同步时重新创建
+		if action&(1<<0) != 0 && !result.applied("create") {
+			member.Id = ""
+		}
+		if action&(1<<1) != 0 && !result.applied("update") && old != nil {
+			member.UserName = old.UserName
+			member.Email = old.Email
+			member.OAID = old.OAID
+		}
+		if action&(1<<2) != 0 && !result.applied("move") && old != nil {
+			member.OrgCode = old.OrgCode
+			member.OrgId = old.OrgId
+			member.DepId = old.DepId
+		}
+
+		member.SyncStatus, member.SyncError = result.status(action)
+		members[code] = member
+	}
+
+	// 删除失败或没有执行的人员保留原来的数据
+	for user, action := range planned.users {
+		if action&(1<<3) == 0 {
+			continue
+		}
+
+		result := results["user "+user.UserCode]
+		if result.applied("delete") {
+			continue
+		}
+
+		member := new(DataApiEmpNode)
+		*member = *user
+		member.Action = 0
+		member.SyncStatus, member.SyncError = result.status(action)
+		members[member.UserCode] = member
+	}
+
+	return members
+}
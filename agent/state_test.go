@@ -0,0 +1,185 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func readSnapshot(t *testing.T, path string) *Snapshot {
+	t.Helper()
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot := new(Snapshot)
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		t.Fatal("parse snapshot: ", err)
+	}
+	return snapshot
+}
+
+// 失败的创建、更新和移动不写入备份，快照中记录失败状态，下次同步时重试
+func TestFailedChangesRetried(t *testing.T) {
+	env := newE2EEnv(t)
+	env.datapub.SetOrgs(baseOrgs())
+	env.datapub.SetEmps(baseEmps())
+	env.start()
+	env.sync()
+
+	orgs := baseOrgs()
+	orgs[0].OrgUnitName = "Sales Global"
+	orgs[2].UpperOrgUnitCode = "B"
+	env.datapub.SetOrgs(orgs)
+	emps := append(baseEmps(), emp("E6", "Frank", "frank", "A1"))
+	emps[2].UserName = "Carol Smith"
+	env.datapub.SetEmps(emps)
+
+	// 部门改名和移动、人员创建和更新全部失败
+	env.oneauth.FailNext(http.MethodPut, "/api/v1/account/org/", http.StatusBadRequest, 2)
+	env.oneauth.FailNext(http.MethodPost, "/api/v1/account/user", http.StatusBadRequest, 1)
+	env.oneauth.FailNext(http.MethodPut, "/api/v1/account/user/", http.StatusBadRequest, 1)
+	if err := env.syncer.Sync(context.Background(), "test"); err != nil {
+		t.Fatal("sync: ", err)
+	}
+	env.assertTree(baseTree)
+	env.assertUsers(baseUsers)
+
+	snapshot := readSnapshot(t, env.config.System.Snapshot.Path)
+	snapshotOrgs := make(map[string]SnapshotOrg)
+	for _, org := range snapshot.Orgs {
+		snapshotOrgs[org.Code] = org
+	}
+	if org := snapshotOrgs["A"]; org.Name != "Sales" || org.SyncStatus != StatusFailed || len(org.SyncError) == 0 {
+		t.Fatal("failed rename should keep the applied name: ", org)
+	}
+	if org := snapshotOrgs["A2"]; org.ParentCode != "A" || org.SyncStatus != StatusFailed {
+		t.Fatal("failed move should keep the applied parent: ", org)
+	}
+	if org := snapshotOrgs["B1"]; org.SyncStatus != StatusApplied {
+		t.Fatal("unchanged org should be applied: ", org)
+	}
+	members := make(map[string]SnapshotMember)
+	for _, member := range snapshot.Members {
+		members[member.UserCode] = member
+	}
+	if member := members["E6"]; member.SyncStatus != StatusFailed || len(member.Id) > 0 {
+		t.Fatal("failed create should be kept without oneauth id: ", member)
+	}
+	if member := members["E3"]; member.UserName != "Carol" || member.SyncStatus != StatusFailed {
+		t.Fatal("failed update should keep the applied name: ", member)
+	}
+
+	// 计划中标记为重试
+	plan, _, err := env.syncer.Plan(context.Background())
+	if err != nil {
+		t.Fatal("plan: ", err)
+	}
+	if items := plan.Users["create"]; len(items) != 1 || items[0].UserCode != "E6" || items[0].LastStatus != StatusFailed {
+		t.Fatal("failed create should be planned as retry: ", items)
+	}
+	if items := plan.Orgs["move"]; len(items) != 1 || items[0].Code != "A2" || items[0].LastStatus != StatusFailed {
+		t.Fatal("failed move should be planned as retry: ", items)
+	}
+
+	// 重启后从快照恢复，失败的变更全部重试
+	env.restart()
+	env.sync()
+
+	tree := copyMap(baseTree)
+	tree["A"] = e2eRoot + "/Sales Global"
+	tree["A2"] = "B/Sales West"
+	env.assertTree(tree)
+	users := copyMap(baseUsers)
+	users["E3"] = "Carol Smith|carol|carol@example.com|A2"
+	users["E6"] = "Frank|frank|frank@example.com|A1"
+	env.assertUsers(users)
+
+	env.oneauth.ResetRequests()
+	env.sync()
+	env.assertNoWrites()
+}
+
+// 创建失败的部门和人员从主数据中删除后，不会产生删除操作
+func TestFailedCreateRemoved(t *testing.T) {
+	env := newE2EEnv(t)
+	env.datapub.SetOrgs(baseOrgs())
+	env.datapub.SetEmps(baseEmps())
+	env.start()
+	env.sync()
+
+	env.datapub.SetOrgs(append(baseOrgs(), org("C", "Legal", "")))
+	env.datapub.SetEmps(append(baseEmps(), emp("E6", "Frank", "frank", "A")))
+	env.oneauth.FailNext(http.MethodPost, "/api/v1/account/org/", http.StatusBadRequest, 1)
+	env.oneauth.FailNext(http.MethodPost, "/api/v1/account/user", http.StatusBadRequest, 1)
+	if err := env.syncer.Sync(context.Background(), "test"); err != nil {
+		t.Fatal("sync: ", err)
+	}
+	env.assertTree(baseTree)
+	env.assertUsers(baseUsers)
+
+	env.datapub.SetOrgs(baseOrgs())
+	env.datapub.SetEmps(baseEmps())
+	env.oneauth.ResetRequests()
+	env.sync()
+	env.assertNoWrites()
+}
+
+// 更新部门时不修改节点操作位的Target
+type copyDepartmentTarget struct {
+	Target
+	fail bool
+}
+
+func (t *copyDepartmentTarget) UpdateDepartment(ctx context.Context, node *DataOrgMemNode) error {
+	if t.fail {
+		return errors.New("injected update department failure")
+	}
+	copied := *node
+	return t.Target.UpdateDepartment(ctx, &copied)
+}
+
+// 部门改名和移动是否成功以任务结果为准，不依赖Target清除操作位
+func TestOrgChangesWithoutTargetMutation(t *testing.T) {
+	env := newE2EEnv(t)
+	env.datapub.SetOrgs(baseOrgs())
+	env.datapub.SetEmps(baseEmps())
+	env.start()
+	env.sync()
+
+	orgs := baseOrgs()
+	orgs[0].OrgUnitName = "Sales Global"
+	orgs[2].UpperOrgUnitCode = "B"
+	env.datapub.SetOrgs(orgs)
+	target := &copyDepartmentTarget{Target: env.syncer.Target, fail: true}
+	env.syncer.Target = target
+
+	for _, fail := range []bool{true, false} {
+		target.fail = fail
+		if err := env.syncer.Sync(context.Background(), "test"); err != nil {
+			t.Fatal("sync: ", err)
+		}
+
+		snapshotOrgs := make(map[string]SnapshotOrg)
+		for _, org := range readSnapshot(t, env.config.System.Snapshot.Path).Orgs {
+			snapshotOrgs[org.Code] = org
+		}
+		name, parent, status := "Sales Global", "B", StatusApplied
+		if fail {
+			name, parent, status = "Sales", "A", StatusFailed
+		}
+		if org := snapshotOrgs["A"]; org.Name != name || org.SyncStatus != status {
+			t.Fatal("rename should be recorded by its task result: ", org)
+		}
+		if org := snapshotOrgs["A2"]; org.ParentCode != parent || org.SyncStatus != status {
+			t.Fatal("move should be recorded by its task result: ", org)
+		}
+	}
+
+	env.oneauth.ResetRequests()
+	env.sync()
+	env.assertNoWrites()
+}
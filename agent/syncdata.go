@@ -0,0 +1,441 @@
+package agent
+
+import (
+	"encoding/json"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 组织架构信息
+type DataApiOrgNode struct {
+	OrgUnitCode      string `json:"orgUnitCode"`
+	OrgUnitName      string `json:"orgUnitName"`
+	Status           string `json:"status"`
+	UpperOrgUnitCode string `json:"upperOrgUnitCode"`
+	UpperOrgUnitName string `json:"upperOrgUnitName"`
+	LeaderCode       string `json:"leaderCode"`
+	LeaderName       string `json:"leaderName"`
+	UpdateDate       string `json:"updateDate"`
+}
+
+// 获取组织架构响应结构
+type DataApiOrgResponse struct {
+	Code        string           `json:"code"`
+	Message     string           `json:"message"`
+	Data        []DataApiOrgNode `json:"data"`
+	Placeholder string           `json:"placeholder"`
+	ErrorMsg    string           `json:"errorMsg"`
+}
+
+// 组织架构人员信息
+type DataApiEmpNode struct {
+	UserCode   string `json:"userCode"`
+	UserName   string `json:"userName"`
+	Email      string `json:"email"`
+	Status     string `json:"status"`
+	OAID       string `json:"OAID"`
+	BsId       string `json:"bsId"`
+	Version    string `json:"version"`
+	UpdateDate string `json:"updateDate"`
+	OrgCode    string `json:"orgCode"`
+	OrgName    string `json:"orgName"`
+
+	Id          string `json:"-"` // Oneauth用户id
+	DepId       string `json:"-"` // Oneauth部门id
+	OrgId       string `json:"-"` // Oneauth组织id
+	Action      int    `json:"-"` // Oneauth操作类型, 0不操作， 1 << 0新建，1 << 1修改，1 << 2移动，1 << 3删除，1 << 4更新直属上级
+	ManagerCode string `json:"-"` // 已同步到oneauth的直属上级工号
+	ManagerId   string `json:"-"` // 已同步到oneauth的直属上级用户id
+	NewManager  string `json:"-"` // 需要同步的直属上级工号，Action包含1 << 4时有效
+	SyncStatus  string `json:"-"` // 备份数据的同步状态，为空表示applied
+	SyncError   string `json:"-"` // 同步失败的错误
+}
+
+// 获取组织架构人员响应结构
+type DataApiEmpResponse struct {
+	Code        string           `json:"code"`
+	Message     string           `json:"message"`
+	Data        []DataApiEmpNode `json:"data"`
+	Placeholder string           `json:"placeholder"`
+	ErrorMsg    string           `json:"errorMsg"`
+}
+
+// 按任务执行结果更新备份数据，备份中只包含已同步到oneauth的值，失败和没有执行的变更在下次同步时重试
+func (s *Syncer) DataBaseRestore(planned *PlannedActions) {
+	results := s.entityResults()
+	orgs := s.AppliedOrgState(planned, results)
+
+	// 父部门已被删除时挂到根节点下，保证能生成部门树
+	codes := make(map[string]bool, len(orgs))
+	for _, org := range orgs {
+		codes[org.Code] = true
+	}
+	for i := range orgs {
+		if !orgs[i].Root && !codes[orgs[i].ParentCode] {
+			orgs[i].ParentCode = s.realOrg.NodeCode
+		}
+	}
+
+	root, orgMap, err := BuildOrgTree(orgs)
+	if err != nil {
+		// 只有数据异常时才会出现，直接使用本次数据作为备份
+		s.log.Error("[task] build applied org tree error: ", err)
+		root, orgMap = s.realOrg, s.orgMap
+	}
+
+	s.orgMapBak = orgMap
+	s.realOrgBak = root
+	s.membersBak = s.AppliedMemberState(planned, results)
+
+	s.sourceOrgCountBak = s.sourceOrgCount
+	s.sourceEmpCountBak = s.sourceEmpCount
+	s.sourceOrgsBak = s.sourceOrgs
+	s.sourceEmpsBak = s.sourceEmps
+	s.RestoreIncrementalState()
+
+	// 清理新数据变量
+	s.orgMap = nil
+	s.realOrg = nil
+	s.members = nil
+}
+
+// 过滤目录
+func (s *Syncer) ProcessOrgFilter(unitcode, unitname string) bool {
+	if _, ok := s.Config.Database.Filter.Filter[unitcode]; ok {
+		return true
+	}
+
+	if _, ok := s.Config.Database.Filter.Filter[unitname]; ok {
+		return true
+	}
+
+	return false
+}
+
+// 删除不需要的org节点
+func (s *Syncer) DeleteOrgNode(orgmap *DataOrgMemNode) {
+	for _, node := range orgmap.Children {
+		s.DeleteOrgNode(node)
+		delete(s.orgMap, node.NodeCode)
+	}
+
+	delete(s.orgMap, orgmap.NodeCode)
+}
+
+func (s *Syncer) FilterOrgMap(orgmap *DataOrgMemNode) {
+	queNode := new(Queue)
+	queNode.Push(orgmap)
+	for queNode.Len() > 0 {
+		size := queNode.Len()
+		//log.Info("queue length: ", queNode.length)
+		for i := 0; i < size; i++ {
+			tmpNode := queNode.Pop().(*DataOrgMemNode)
+			if len(tmpNode.NodeName) == 0 || tmpNode.Value.Status != "1" {
+				s.DeleteOrgNode(tmpNode)
+				//delete(orgMap, tmpNode.NodeCode)
+
+				if tmpNode.parent != nil {
+					delete(tmpNode.parent.Children, tmpNode.NodeCode)
+					tmpNode.parent = nil
+				}
+				continue
+			}
+
+			/*
+				if tmpNode.parent != nil {
+					s.log.Info("avalible org: ", tmpNode.NodeCode, ", ", tmpNode.NodeName, ", parent: ", tmpNode.parent.NodeCode, ", ", tmpNode.parent.NodeName)
+				} else {
+					s.log.Info("avalible org: ", tmpNode.NodeCode, ", ", tmpNode.NodeName, ", parent: nil")
+				}
+			*/
+
+			for _, node := range tmpNode.Children {
+				queNode.Push(node)
+				//log.Error("error log: ", node.NodeCode, node.NodeName)
+			}
+		}
+	}
+}
+
+// 过滤出指定目录数据
+func (s *Syncer) FiterSyncOu(topOrg *DataOrgMemNode) {
+	if len(s.Config.Database.SyncOu) == 0 {
+		return
+	}
+
+	basenode := s.orgMap[s.Config.Database.SyncOu]
+	if basenode == nil {
+		return
+	}
+
+	// 如果指定的目录直接是顶层目录，则删除其他顶层目录即可
+	if basenode.parent != topOrg {
+		// 将basenode从父节点中删除
+		delete(basenode.parent.Children, basenode.NodeCode)
+		// 变更basenode父节点为根节点
+		basenode.parent = topOrg
+		basenode.Root = false
+		topOrg.Children[s.Config.Database.SyncOu] = basenode
+	}
+
+	// 递归删除其他顶层结构
+	for code, node := range topOrg.Children {
+		if code != s.Config.Database.SyncOu {
+			s.DeleteOrgNode(node)
+			delete(topOrg.Children, code)
+			node.parent = nil
+		}
+	}
+}
+
+// 解析组织架构接口响应
+func ParseDataApiOrgRsp(body []byte) ([]DataApiOrgNode, error) {
+	var responseData DataApiOrgResponse
+	if err := json.Unmarshal(body, &responseData); err != nil {
+		log.Info("[http] org response json unmarshal error: ", err)
+		log.Info(body)
+		return nil, err
+	}
+
+	log.Info("[http] org response json org unmarshal success, get orgs count: ", len(responseData.Data))
+	return responseData.Data, nil
+}
+
+// 根据全量组织架构数据生成组织架构树
+func (s *Syncer) ProcessDataApiOrgData(orgs []DataApiOrgNode) {
+	// 清理原有的数据
+	s.orgMap = nil
+	s.realOrg = nil
+
+	s.sourceOrgs = orgs
+	s.sourceOrgCount = len(orgs)
+	MetricSourceCount.Set(float64(s.sourceOrgCount), s.Name(), "org", "total")
+
+	s.orgMap = make(map[string]*DataOrgMemNode)
+	for _, node := range orgs {
+		// 替换名字中的逗号为空格
+		node.OrgUnitName = strings.Replace(node.OrgUnitName, ",", " ", -1)
+		node.UpperOrgUnitName = strings.Replace(node.UpperOrgUnitName, ",", " ", -1)
+
+		newnode := new(DataApiOrgNode)
+		*newnode = node
+
+		// 组织编码如果没有，则设置组织名
+		if len(node.UpperOrgUnitCode) == 0 && len(node.UpperOrgUnitName) > 0 {
+			node.UpperOrgUnitCode = node.UpperOrgUnitName
+		}
+
+		// 设置目录过滤
+		if s.ProcessOrgFilter(newnode.OrgUnitCode, newnode.OrgUnitName) == true {
+			newnode.Status = "2"
+		}
+
+		if midnode, ok := s.orgMap[node.OrgUnitCode]; ok {
+			// key存在，创建实际中继节点，同时创建虚拟父节点
+			// s.log.Info("创建中继节点: ", midnode.NodeCode)
+			midnode.Value = newnode
+			// 虚拟节点的名字来自下级部门的上级名字，以本部门数据为准
+			midnode.NodeName = node.OrgUnitName
+			midnode.OuName = midnode.NodeName + "(" + midnode.NodeCode + ")"
+
+			// 顶层节点
+			if len(node.UpperOrgUnitCode) == 0 {
+				continue
+			}
+
+			// 父节点若存在则直接指针指过去，若不存在则建立虚拟父节点
+			if father, fatherok := s.orgMap[node.UpperOrgUnitCode]; fatherok {
+				midnode.parent = father
+				if father.Children == nil {
+					father.Children = make(map[string]*DataOrgMemNode)
+				}
+				father.Children[midnode.NodeCode] = midnode
+			} else {
+				// 创建虚拟父节点，并将本节点插入到父节点的子集中
+				var fatherOrg = new(DataOrgMemNode)
+				fatherOrg.NodeCode = node.UpperOrgUnitCode
+				fatherOrg.NodeName = node.UpperOrgUnitName
+				fatherOrg.Root = false
+				fatherOrg.OuName = fatherOrg.NodeName + "(" + fatherOrg.NodeCode + ")"
+
+				fatherOrg.Children = make(map[string]*DataOrgMemNode)
+				fatherOrg.Children[midnode.NodeCode] = midnode
+				s.orgMap[fatherOrg.NodeCode] = fatherOrg
+				midnode.parent = s.orgMap[node.UpperOrgUnitCode]
+				// s.log.Info("创建父节点: ", fatherOrg.NodeCode)
+			}
+		} else {
+			var newOrg = new(DataOrgMemNode)
+			newOrg.NodeCode = node.OrgUnitCode
+			newOrg.NodeName = node.OrgUnitName
+			newOrg.Root = false
+			newOrg.OuName = newOrg.NodeName + "(" + newOrg.NodeCode + ")"
+			newOrg.Value = newnode
+			s.orgMap[newOrg.NodeCode] = newOrg
+
+			// 顶层节点
+			if len(node.UpperOrgUnitCode) == 0 {
+				continue
+			}
+
+			// 父节点若存在则直接指针指过去，若不存在则建立虚拟父节点
+			father := s.orgMap[node.UpperOrgUnitCode]
+			if father != nil {
+				if father.Children == nil {
+					father.Children = make(map[string]*DataOrgMemNode)
+				}
+			} else {
+				// 创建虚拟父节点，并将本节点插入到父节点的子集中
+				father = new(DataOrgMemNode)
+				father.NodeCode = node.UpperOrgUnitCode
+				father.NodeName = node.UpperOrgUnitName
+				father.Root = false
+				father.OuName = father.NodeName + "(" + father.NodeCode + ")"
+				father.Children = make(map[string]*DataOrgMemNode)
+
+				s.orgMap[father.NodeCode] = father
+			}
+
+			father.Children[newOrg.NodeCode] = newOrg
+			newOrg.parent = father
+		}
+	}
+
+	// 添加顶层主目录
+	topOrg := new(DataOrgMemNode)
+	// 设置name和code是为了防止后面过滤目录时，被删除掉
+	topOrg.NodeName = s.Config.Oneauth.RootName
+	topOrg.NodeCode = s.Config.Oneauth.RootName
+	topOrg.OuName = s.Config.Oneauth.RootName
+	value := new(DataApiOrgNode)
+	value.Status = "1"
+	topOrg.Value = value
+	topOrg.Root = true
+	topOrg.Children = make(map[string]*DataOrgMemNode)
+
+	// 添加默认目录
+	if len(s.Config.Database.DefaultTree) > 0 {
+		DefaultOrg := new(DataOrgMemNode)
+		DefaultOrg.NodeName = s.Config.Database.DefaultTree
+		DefaultOrg.NodeCode = s.Config.Database.DefaultTree
+		DefaultOrg.OuName = s.Config.Database.DefaultTree
+		DefaultOrg.Root = false
+		// 添加到orgmap集合
+		s.orgMap[DefaultOrg.NodeCode] = DefaultOrg
+	}
+
+	for key, node := range s.orgMap {
+		if node.Value == nil || node.parent == nil {
+			if node.Value == nil {
+				value := new(DataApiOrgNode)
+				node.Value = value
+			}
+
+			// 设置目录过滤, 2无效，1有效
+			if s.ProcessOrgFilter(node.NodeCode, node.NodeName) == true {
+				node.Value.Status = "2"
+			} else {
+				node.Value.Status = "1"
+			}
+
+			node.parent = topOrg
+			topOrg.Children[key] = node
+			s.log.Info("id: " + node.NodeCode + ", name: " + node.NodeName)
+		}
+	}
+
+	s.log.Info("获取总组织数量: ", len(s.orgMap))
+
+	// 过滤掉name为空和无效的组织架构
+	s.FilterOrgMap(topOrg)
+	// 过滤出指定目录数据
+	s.FiterSyncOu(topOrg)
+
+	s.realOrg = topOrg
+	s.skippedOrgs = s.SkippedOrgs(orgs)
+	s.log.Info("有效总组织数量: ", len(s.orgMap), ", 总公司数量: ", len(s.realOrg.Children))
+	s.validOrgCount = len(s.orgMap)
+	MetricSourceCount.Set(float64(s.validOrgCount), s.Name(), "org", "valid")
+}
+
+func (s *Syncer) FilterUnrelatedUsers(usersMap *map[string]*DataApiEmpNode) {
+	s.members = nil
+	s.members = make(map[string]*DataApiEmpNode)
+
+	for key, value := range *usersMap {
+		if father, ok := s.orgMap[value.OrgCode]; ok {
+			// 更新人员的orgid和depid
+			value.DepId = father.DepId
+			value.OrgId = father.OrgId
+			s.members[key] = value
+			continue
+		}
+
+		s.skippedUsers = append(s.skippedUsers, ReportSkipped{Kind: "user", Code: value.UserCode, Name: value.UserName,
+			Reason: "org " + value.OrgCode + " is not synced"})
+	}
+}
+
+// 部门创建完成后，刷新人员的orgid和depid
+func (s *Syncer) UpdateMembersDepId() {
+	for _, value := range s.members {
+		if father, ok := s.orgMap[value.OrgCode]; ok {
+			value.DepId = father.DepId
+			value.OrgId = father.OrgId
+		}
+	}
+}
+
+// 解析人员接口响应
+func ParseDataApiEmpRsp(body []byte) ([]DataApiEmpNode, error) {
+	var responseData DataApiEmpResponse
+	if err := json.Unmarshal(body, &responseData); err != nil {
+		log.Info("[http] org response json unmarshal error: ", err)
+		return nil, err
+	}
+
+	return responseData.Data, nil
+}
+
+// 根据全量人员数据生成人员集合
+func (s *Syncer) ProcessDataApiEmpData(emps []DataApiEmpNode) {
+	// 清理原有的数据
+	s.members = nil
+	s.skippedUsers = nil
+
+	s.log.Info("总人员数量: ", len(emps))
+	s.sourceEmps = emps
+	s.sourceEmpCount = len(emps)
+	MetricSourceCount.Set(float64(s.sourceEmpCount), s.Name(), "user", "total")
+
+	if len(emps) > 0 {
+		var usersMap = make(map[string]*DataApiEmpNode)
+		for _, person := range emps {
+			// 无效用户直接过滤
+			if reason := EmpSkipReason(&person); len(reason) > 0 {
+				s.skippedUsers = append(s.skippedUsers, ReportSkipped{Kind: "user", Code: person.UserCode, Name: person.UserName,
+					Reason: reason})
+				continue
+			}
+
+			newUser := new(DataApiEmpNode)
+			*newUser = person
+
+			// 更新orgId和depId
+			if father, ok := s.orgMap[newUser.OrgCode]; ok {
+				newUser.OrgId = father.OrgId
+				newUser.DepId = father.DepId
+			}
+
+			usersMap[person.UserCode] = newUser
+		}
+
+		// 过滤掉找不到组织的人员
+		s.FilterUnrelatedUsers(&usersMap)
+	}
+
+	s.log.Info("有效人员数量: ", len(s.members))
+	s.validEmpCount = len(s.members)
+	MetricSourceCount.Set(float64(s.validEmpCount), s.Name(), "user", "valid")
+}
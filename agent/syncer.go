@@ -0,0 +1,349 @@
+// Package agent 将主数据平台的组织架构和人员同步到oneauth，可以作为库嵌入到其他服务中
+package agent
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 组织架构和人员的数据来源
+type Source interface {
+	// 获取组织架构，since不为空时只需返回updateDate晚于since的数据
+	FetchOrgs(ctx context.Context, since string) ([]DataApiOrgNode, error)
+	// 获取人员，since不为空时只需返回updateDate晚于since的数据
+	FetchEmps(ctx context.Context, since string) ([]DataApiEmpNode, error)
+}
+
+// 同步目标，人员相关接口会被多个协程并发调用，ctx取消时应尽快返回
+type Target interface {
+	ListRoots(ctx context.Context) ([]RootInfo, error)
+	ListDepartments(ctx context.Context, orgId string) ([]OrgInfo, error)
+	ListUsers(ctx context.Context) ([]MemInfo, error)
+
+	// 创建成功返回新节点的id，对象已存在时返回IsOneauthConflict可以识别的错误，同步器会查找并使用已有的对象
+	CreateRoot(ctx context.Context, node *DataOrgMemNode) (string, error)
+	UpdateRoot(ctx context.Context, node *DataOrgMemNode) error
+	// 父级部门id为node.FatherId，为空时创建在根节点下
+	CreateDepartment(ctx context.Context, node *DataOrgMemNode) (string, error)
+	// 按node.Action更新名字或移动到node.FatherId，成功后清除对应的位标记
+	UpdateDepartment(ctx context.Context, node *DataOrgMemNode) error
+	DeleteDepartment(ctx context.Context, node *DataOrgMemNode) error
+	// userId为空时清除部门主管
+	SetDepartmentManager(ctx context.Context, node *DataOrgMemNode, userId string) error
+
+	CreateUser(ctx context.Context, user *DataApiEmpNode) (string, error)
+	UpdateUser(ctx context.Context, user *DataApiEmpNode) error
+	MoveUser(ctx context.Context, user *DataApiEmpNode) error
+	DeleteUser(ctx context.Context, user *DataApiEmpNode) error
+	// managerId为空时清除直属上级
+	SetUserManager(ctx context.Context, user *DataApiEmpNode, managerId string) error
+}
+
+// 同步器，持有一个同步任务的配置、数据来源、同步目标以及比对所需的数据
+type Syncer struct {
+	Config *Config
+	Source Source // 主数据来源
+	Target Target // 同步目标
+
+	log *log.Entry // 带任务名字段的日志
+
+	lock sync.Mutex // 同步执行锁，保证同一时间只有一次同步
+
+	// 同步中重新加载的配置，在下一次同步开始时应用
+	reloadLock    sync.Mutex
+	reloadPending *Config
+
+	// 退出状态，stopping置位后不再开始新的同步和任务，interrupted表示本次同步有任务未执行
+	stopping    int32
+	interrupted int32
+
+	// 同步状态
+	statusLock  sync.Mutex
+	status      *SyncRunStatus     // 最近一次同步状态，同步中时为当前状态
+	lastSuccess time.Time          // 最近一次成功同步的结束时间
+	completed   []string           // 本次同步已成功执行的任务，如user create E1
+	results     []taskResult       // 本次同步所有任务的执行结果，用于生成同步报告
+	cancel      context.CancelFunc // 取消正在执行的同步，没有同步在执行时为nil
+
+	adoptCache adoptCache // 本次同步中创建冲突时读取的oneauth已有对象
+
+	// 本次拉取的组织架构和人员
+	orgMap  map[string]*DataOrgMemNode // 所有组织架构信息节点集合
+	realOrg *DataOrgMemNode            // 实际组织架构结构
+	members map[string]*DataApiEmpNode // 所有人员
+
+	// 本次拉取的数据中没有同步的部门和人员，以及原因
+	skippedOrgs, skippedUsers []ReportSkipped
+
+	// 作为老数据备份
+	orgMapBak  map[string]*DataOrgMemNode
+	realOrgBak *DataOrgMemNode
+	membersBak map[string]*DataApiEmpNode
+
+	// 主数据接口返回的全量原始数据，增量同步时在上一次数据的基础上合并变更
+	sourceOrgs, sourceOrgsBak []DataApiOrgNode
+	sourceEmps, sourceEmpsBak []DataApiEmpNode
+
+	// 主数据接口返回的原始数量，本次和上一次同步成功时的数量
+	sourceOrgCount, sourceEmpCount       int
+	sourceOrgCountBak, sourceEmpCountBak int
+	// 本次过滤后的有效数量
+	validOrgCount, validEmpCount int
+
+	// oneauth内所有的组织架构数据，只在第一次同步前从oneauth读取
+	upstreamExtraKey  map[string]*DataOrgNode    // key为外部id
+	upstreamInsideKey map[string]*DataOrgNode    // key为oneauth depid
+	upstreamUsers     map[string]*DataApiEmpNode // oneauth内所有的人员信息
+
+	// 增量同步状态
+	fullSync                         bool      // 本次同步是否为全量同步
+	orgWatermark, empWatermark       string    // 主数据updateDate的最大值，作为下一次增量同步的起点
+	orgWatermarkBak, empWatermarkBak string    // 上一次同步成功时的水位
+	lastFullSyncBak                  time.Time // 最后一次全量同步的时间
+}
+
+// 创建同步器，config需要已经通过Init初始化
+func NewSyncer(config *Config, source Source, target Target) *Syncer {
+	return &Syncer{Config: config, Source: source, Target: target, log: log.WithField("job", config.Name)}
+}
+
+// 使用配置中的主数据接口和oneauth创建同步器
+func NewDefaultSyncer(config *Config) *Syncer {
+	source := NewDatapubSource(&config.Database)
+	target := NewOneauthTarget(&config.Oneauth, config.System.Fiber)
+	syncer := NewSyncer(config, source, target)
+	source.log = syncer.log
+	target.log = syncer.log
+	return syncer
+}
+
+// 任务名
+func (s *Syncer) Name() string {
+	return s.Config.Name
+}
+
+// 一次同步需要执行的所有任务队列
+type SyncTasks struct {
+	OrgNew    *Queue // 需要新建的部门
+	OrgUpdate *Queue // 需要更新名字或移动的部门
+	OrgDel    *Queue // 需要删除的部门，在人员处理完成后执行
+	Users     *Queue // 人员的新建、更新、移动和删除任务
+	Managers  *Queue // 人员的直属上级更新任务，在部门负责人更新后执行
+
+	Diff *DiffPlan // 生成任务队列的比对结果
+}
+
+// 拉取主数据并和现有数据做比对，生成本次同步的任务队列，此过程不会修改oneauth数据
+func (s *Syncer) BuildSyncTasks(ctx context.Context) (*SyncTasks, error) {
+	orgs, emps, err := s.FetchSourceData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	s.ProcessDataApiOrgData(orgs)
+	s.ProcessDataApiEmpData(emps)
+
+	// 比对不会修改基准数据和本次数据，任务队列按比对结果的顺序生成
+	plan := DiffSyncState(s.BaselineState(), s.DesiredState(), DiffOptions{
+		Leader:  s.Config.Database.Leader.Department,
+		Manager: s.Config.Database.Leader.User,
+	})
+	return s.CreateSyncTasks(plan), nil
+}
+
+// 按顺序执行同步任务，并将已同步到oneauth的数据作为下一次比对的备份
+// 执行中收到退出请求时不再执行剩余任务，按已执行的结果更新备份并保存快照，在同步标记中记录中断原因，返回ErrSyncInterrupted
+func (s *Syncer) ExecuteSyncTasks(ctx context.Context, tasks *SyncTasks) error {
+	// 执行任务会清空队列，先记录每个部门和人员计划的操作
+	planned := NewPlannedActions(tasks)
+
+	steps := []func(){
+		func() { s.ProcessOrgTaskQueue(ctx, tasks.OrgNew, tasks.OrgUpdate) },
+		func() {
+			// 新建部门后才有部门id，需要刷新人员的部门信息
+			s.UpdateMembersDepId()
+			s.ProcessUsersTaskQueue(ctx, tasks.Users)
+		},
+		// 人员创建完成后才能获取负责人的用户id
+		func() { s.ProcessLeaderTaskQueue(ctx) },
+		func() { s.ProcessUsersTaskQueue(ctx, s.CreateUserManagerTaskQueue(tasks.Managers)) },
+		// 删除多余的org目录
+		func() { s.ProcessDelOrgTaskQueue(ctx, tasks.OrgDel) },
+	}
+
+	for _, step := range steps {
+		step()
+		if atomic.LoadInt32(&s.interrupted) == 1 {
+			break
+		}
+	}
+
+	// 备份需要和基准数据比对执行结果，在清空oneauth数据之前生成
+	s.DataBaseRestore(planned)
+	// 重启后，同步完成第一次数据后，清空从oneauth同步的数据，后续只做新老数据的比对
+	s.UpstreamDataClear()
+
+	if atomic.LoadInt32(&s.interrupted) == 1 {
+		err := ErrSyncInterrupted
+		if ctx.Err() != nil {
+			err = &interruptedError{cause: ctx.Err()}
+		}
+
+		// 快照记录了已完成的任务，未完成和结果未知的任务状态为未同步，重启后直接使用快照并重试这些任务
+		s.WriteSyncMarker(err, s.SaveSnapshot(s.Config.System.Snapshot.Path) == nil)
+		return err
+	}
+
+	// 备份数据持久化，重启后直接使用，保存成功后快照和oneauth的数据一致
+	if s.SaveSnapshot(s.Config.System.Snapshot.Path) == nil {
+		s.RemoveSyncMarker()
+	}
+	return nil
+}
+
+// 生成当前数据的同步计划，不修改oneauth数据，删除数量超过阈值时同时返回SafetyError
+func (s *Syncer) Plan(ctx context.Context) (*SyncPlan, *SafetyError, error) {
+	if !s.lock.TryLock() {
+		return nil, nil, ErrSyncRunning
+	}
+	defer s.lock.Unlock()
+	s.applyReloadedConfig()
+
+	tasks, err := s.BuildSyncTasks(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	safetyErr, _ := s.CheckSyncSafety(tasks).(*SafetyError)
+	return s.BuildSyncPlan(tasks), safetyErr, nil
+}
+
+// 同步数据库内容数据，用于更新到oneauth服务，已有同步在执行时直接返回ErrSyncRunning，退出中返回ErrShuttingDown
+func (s *Syncer) Sync(ctx context.Context, trigger string) error {
+	if s.Stopping() {
+		return ErrShuttingDown
+	}
+
+	if !s.lock.TryLock() {
+		s.log.Warn("[task] previous sync is still running, skip")
+		return ErrSyncRunning
+	}
+	defer s.lock.Unlock()
+
+	// 获取锁的同时可能已请求停止
+	if s.Stopping() {
+		return ErrShuttingDown
+	}
+
+	return s.runSync(ctx, trigger)
+}
+
+// 执行一次完整同步，调用方需要持有同步锁
+// 同步可以通过Cancel取消，配置了synctimeout时超时后同样取消，结束后生成报告并按配置发送通知
+func (s *Syncer) runSync(ctx context.Context, trigger string) (err error) {
+	s.applyReloadedConfig()
+	// 已读取的oneauth对象只在本次同步中使用，结束后释放
+	defer s.adoptCache.reset()
+
+	var cancel context.CancelFunc
+	if timeout := s.Config.System.SyncTimeoutDuration; timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	defer cancel()
+
+	s.BeginSyncStatus(trigger)
+	s.setCancel(cancel)
+	s.resetSourceStats()
+	defer s.setCancel(nil)
+
+	// 无论同步是否成功都生成报告，失败时发送通知，计划需要在执行前生成以保留变更前的数据
+	var plan *SyncPlan
+	defer func() {
+		report := s.BuildSyncReport(plan)
+		path, _ := s.WriteSyncReport(report)
+		s.NotifySyncResult(report, path, err)
+	}()
+
+	tasks, err := s.BuildSyncTasks(ctx)
+	if err != nil {
+		s.EndSyncStatus(err)
+		return err
+	}
+
+	plan = s.BuildSyncPlan(tasks)
+	s.RecordSyncPlanned(plan.Summary)
+
+	// 删除数量超过阈值时放弃本次同步，保留原有备份数据
+	if err := s.CheckSyncSafety(tasks); err != nil {
+		s.log.Error("[task] sync aborted, keep previous backup data")
+		s.EndSyncStatus(err)
+		return err
+	}
+
+	// 开始修改oneauth数据前记录同步标记，进程异常退出时下次启动重新读取oneauth
+	atomic.StoreInt32(&s.interrupted, 0)
+	s.WriteSyncMarker(nil, false)
+
+	if err := s.ExecuteSyncTasks(ctx, tasks); err != nil {
+		s.log.Warn("[task] sync interrupted, completed tasks are kept in backup data")
+		s.EndSyncStatus(err)
+		return err
+	}
+
+	s.EndSyncStatus(nil)
+	return nil
+}
+
+// 清空上一次拉取的统计，拉取失败时报告中不会出现上一次的数量和被过滤的数据
+func (s *Syncer) resetSourceStats() {
+	s.fullSync = false
+	s.sourceOrgCount, s.sourceEmpCount = 0, 0
+	s.validOrgCount, s.validEmpCount = 0, 0
+	s.skippedOrgs, s.skippedUsers = nil, nil
+}
+
+// 请求停止同步，正在执行的请求完成后不再执行新的任务，之后的同步直接返回ErrShuttingDown
+func (s *Syncer) Stop() {
+	atomic.StoreInt32(&s.stopping, 1)
+}
+
+// 是否已请求停止
+func (s *Syncer) Stopping() bool {
+	return atomic.LoadInt32(&s.stopping) == 1
+}
+
+// 取消正在执行的同步，正在执行的请求会被中止，没有同步在执行时返回false
+func (s *Syncer) Cancel() bool {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+
+	if s.cancel == nil {
+		return false
+	}
+
+	s.log.Warn("[task] cancel running sync")
+	s.cancel()
+	return true
+}
+
+func (s *Syncer) setCancel(cancel context.CancelFunc) {
+	s.statusLock.Lock()
+	defer s.statusLock.Unlock()
+	s.cancel = cancel
+}
+
+// 在取出下一个任务前调用，已请求停止或同步被取消时标记本次同步被中断
+func (s *Syncer) stopTask(ctx context.Context) bool {
+	if !s.Stopping() && ctx.Err() == nil {
+		return false
+	}
+
+	atomic.StoreInt32(&s.interrupted, 1)
+	return true
+}
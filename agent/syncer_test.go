@@ -0,0 +1,78 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/CipherChina/OneAuth-Agent/mock"
+)
+
+// 内存中的数据来源，用于替换主数据接口
+type staticSource struct {
+	orgs []DataApiOrgNode
+	emps []DataApiEmpNode
+}
+
+func (src *staticSource) FetchOrgs(ctx context.Context, since string) ([]DataApiOrgNode, error) {
+	return append([]DataApiOrgNode(nil), src.orgs...), nil
+}
+
+func (src *staticSource) FetchEmps(ctx context.Context, since string) ([]DataApiEmpNode, error) {
+	return append([]DataApiEmpNode(nil), src.emps...), nil
+}
+
+func newStaticSource(orgs []mock.Org, emps []mock.Emp) *staticSource {
+	src := new(staticSource)
+	for _, org := range orgs {
+		src.orgs = append(src.orgs, DataApiOrgNode(org))
+	}
+	for _, emp := range emps {
+		src.emps = append(src.emps, DataApiEmpNode{UserCode: emp.UserCode, UserName: emp.UserName, Email: emp.Email,
+			Status: emp.Status, OAID: emp.OAID, UpdateDate: emp.UpdateDate, OrgCode: emp.OrgCode})
+	}
+	return src
+}
+
+// 两个同步器各自持有数据，可以同时同步到不同的oneauth
+func TestSyncerIsolation(t *testing.T) {
+	envs := []*e2eEnv{newE2EEnv(t), newE2EEnv(t)}
+	sources := []*staticSource{
+		newStaticSource(baseOrgs(), baseEmps()),
+		newStaticSource(baseOrgs()[:3], baseEmps()[:3]),
+	}
+
+	syncers := make([]*Syncer, len(envs))
+	for i, env := range envs {
+		syncers[i] = NewSyncer(env.config, sources[i], NewOneauthTarget(&env.config.Oneauth, env.config.System.Fiber))
+		if err := syncers[i].LoadBaseline(context.Background()); err != nil {
+			t.Fatal("load baseline: ", err)
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(syncers))
+	for i := range syncers {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = syncers[i].Sync(context.Background(), "test")
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatal("sync ", i, ": ", err)
+		}
+	}
+
+	envs[0].assertTree(baseTree)
+	envs[0].assertUsers(baseUsers)
+	envs[1].assertTree(map[string]string{"A": baseTree["A"], "A1": baseTree["A1"], "A2": baseTree["A2"], e2eDefault: baseTree[e2eDefault]})
+	envs[1].assertUsers(map[string]string{"E1": baseUsers["E1"], "E2": baseUsers["E2"], "E3": baseUsers["E3"]})
+
+	if requests := envs[0].datapub.Requests(); len(requests) != 0 {
+		t.Fatal("static source should not call datapub, got: ", requests)
+	}
+}
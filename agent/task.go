@@ -0,0 +1,374 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type DataOrgMemNode struct {
+	NodeCode string
+	NodeName string
+	OuName   string
+	Children map[string]*DataOrgMemNode
+	parent   *DataOrgMemNode
+	Value    *DataApiOrgNode
+
+	Root       bool   // 是否是根节点
+	OrgId      string // Oneauth根节点id
+	DepId      string // oneauth部门id
+	FatherId   string // oneauth新父级部门id，用于部门创建和移动
+	Action     int    // 操作类型，1 << 0创建，1 << 1更新名字，1 << 2移动，6更新+移动，1 << 3删除，1 << 4更新负责人
+	LeaderCode string // 已同步到oneauth的部门负责人工号
+	ManagerId  string // 已同步到oneauth的部门负责人用户id
+	SyncStatus string // 备份数据的同步状态，为空表示applied
+	SyncError  string // 同步失败的错误
+}
+
+// 比对基准数据，基准数据为oneauth数据或上一次同步的备份
+func (s *Syncer) BaselineState() SyncState {
+	var state SyncState
+	if s.upstreamExtraKey != nil {
+		for code, node := range s.upstreamExtraKey {
+			state.Orgs = append(state.Orgs, s.upstreamOrg(code, node))
+		}
+		for _, user := range s.upstreamUsers {
+			state.Members = append(state.Members, snapshotMember(user))
+		}
+		return state
+	}
+
+	if s.realOrgBak != nil {
+		state.Orgs = append(state.Orgs, snapshotOrg(s.realOrgBak))
+	}
+	for _, node := range s.orgMapBak {
+		state.Orgs = append(state.Orgs, snapshotOrg(node))
+	}
+	for _, user := range s.membersBak {
+		state.Members = append(state.Members, snapshotMember(user))
+	}
+	return state
+}
+
+// 本次主数据生成的期望数据，部门负责人为主数据中的负责人
+func (s *Syncer) DesiredState() SyncState {
+	var state SyncState
+	addOrg := func(node *DataOrgMemNode) {
+		org := SnapshotOrg{Code: node.NodeCode, Name: node.NodeName, Root: node.Root, LeaderCode: DesiredLeaderCode(node)}
+		if node.parent != nil {
+			org.ParentCode = node.parent.NodeCode
+		}
+		state.Orgs = append(state.Orgs, org)
+	}
+
+	if s.realOrg != nil {
+		addOrg(s.realOrg)
+	}
+	for _, node := range s.orgMap {
+		addOrg(node)
+	}
+	for _, user := range s.members {
+		state.Members = append(state.Members, SnapshotMember{UserCode: user.UserCode, UserName: user.UserName, Email: user.Email,
+			OAID: user.OAID, Status: user.Status, OrgCode: user.OrgCode})
+	}
+	return state
+}
+
+// 本次数据中的部门，包括根节点
+func (s *Syncer) orgNode(code string) *DataOrgMemNode {
+	if s.realOrg != nil && s.realOrg.NodeCode == code {
+		return s.realOrg
+	}
+	return s.orgMap[code]
+}
+
+// 根据比对结果生成任务队列，队列顺序和比对结果一致
+// 比对结果中的oneauth id和已同步的负责人、直属上级填充到本次数据中，用于执行任务和备份
+func (s *Syncer) CreateSyncTasks(plan *DiffPlan) *SyncTasks {
+	for _, org := range plan.Resolved.Orgs {
+		node := s.orgNode(org.Code)
+		if node == nil {
+			continue
+		}
+		node.OrgId = org.OrgId
+		node.DepId = org.DepId
+		node.FatherId = org.FatherId
+		node.LeaderCode = org.LeaderCode
+		node.ManagerId = org.ManagerId
+		node.Action = 0
+	}
+
+	for _, member := range plan.Resolved.Members {
+		user, ok := s.members[member.UserCode]
+		if !ok {
+			continue
+		}
+		user.Id = member.Id
+		user.OrgId = member.OrgId
+		user.DepId = member.DepId
+		user.ManagerCode = member.ManagerCode
+		user.ManagerId = member.ManagerId
+		user.NewManager = ""
+		user.Action = 0
+	}
+
+	tasks := &SyncTasks{OrgNew: New(), OrgUpdate: New(), OrgDel: New(), Users: New(), Managers: New(), Diff: plan}
+	push := func(queue *Queue, change OrgChange) {
+		if node := s.orgNode(change.Org.Code); node != nil {
+			node.Action = change.Action
+			queue.Push(node)
+		}
+	}
+	for _, change := range plan.OrgCreates {
+		push(tasks.OrgNew, change)
+	}
+	for _, change := range plan.OrgUpdates {
+		push(tasks.OrgUpdate, change)
+	}
+
+	// 删除的部门和人员不在本次数据中，使用基准数据生成
+	for _, change := range plan.OrgDeletes {
+		node := newOrgNode(change.Org)
+		node.Action = change.Action
+		tasks.OrgDel.Push(node)
+	}
+
+	for _, change := range plan.Members {
+		user, ok := s.members[change.Member.UserCode]
+		if change.Action&(1<<3) != 0 || !ok {
+			user = memberNode(change.Member)
+		}
+
+		// 直属上级需要在人员创建后更新，单独放入队列
+		if change.Action&(1<<4) != 0 {
+			user.NewManager = change.ManagerCode
+			tasks.Managers.Push(user)
+		}
+		if action := change.Action &^ (1 << 4); action != 0 {
+			user.Action = action
+			tasks.Users.Push(user)
+		}
+	}
+
+	return tasks
+}
+
+// 执行组织架构任务队列的任务，此处只执行创建和更新任务，删除任务需要最后执行
+// 子部门在父部门创建成功后创建，移动的部门在新的父部门创建成功后移动，父部门失败时跳过
+func (s *Syncer) ProcessOrgTaskQueue(ctx context.Context, taskNewQueue, taskUpdateQueue *Queue) {
+	s.log.Info("[oneauth] create queue count: ", taskNewQueue.Len())
+	s.log.Info("[oneauth] update org queue count: ", taskUpdateQueue.Len())
+
+	graph := newOrgTaskGraph()
+	creates := make(map[string]*orgTask)
+	var root *orgTask
+	for taskNewQueue.Len() > 0 {
+		task := graph.add(taskNewQueue.Pop().(*DataOrgMemNode), "create")
+		creates[task.node.NodeCode] = task
+		if task.node.Root == true {
+			root = task
+		}
+	}
+
+	for taskUpdateQueue.Len() > 0 {
+		node := taskUpdateQueue.Pop().(*DataOrgMemNode)
+		if node.Root == true {
+			// TODO: 更新root节点信息
+			continue
+		}
+
+		// 只更新负责人的部门在人员同步完成后处理
+		if node.Action&(1<<1|1<<2) == 0 {
+			continue
+		}
+
+		graph.add(node, ActionName(node.Action&^(1<<4)))
+	}
+
+	for _, task := range graph.tasks {
+		if task.node.Root == true {
+			continue
+		}
+
+		if task.action == "create" {
+			// 根节点本次新建时，所有部门都需要根节点id
+			graph.depend(task, root)
+			if task.node.parent != nil {
+				graph.depend(task, creates[task.node.parent.NodeCode])
+			}
+		} else if task.node.Action&(1<<2) != 0 && task.node.parent != nil {
+			graph.depend(task, creates[task.node.parent.NodeCode])
+		}
+	}
+
+	s.runOrgTasks(ctx, graph, func(task *orgTask) error {
+		if task.action == "create" {
+			return s.createOrg(ctx, task.node)
+		}
+		return s.updateOrg(ctx, task.node)
+	})
+}
+
+// 执行删除部门任务，子部门删除成功后再删除父部门，子部门删除失败时跳过父部门
+func (s *Syncer) ProcessDelOrgTaskQueue(ctx context.Context, taskDelQueue *Queue) {
+	s.log.Info("[oneauth] delete org queue count: ", taskDelQueue.Len())
+
+	graph := newOrgTaskGraph()
+	for taskDelQueue.Len() > 0 {
+		node := taskDelQueue.Pop().(*DataOrgMemNode)
+		if node.Action&(1<<3) != 0 {
+			graph.add(node, "delete")
+		}
+	}
+
+	for _, task := range graph.tasks {
+		graph.depend(graph.byCode[orgParentCode(task.node)], task)
+	}
+
+	s.runOrgTasks(ctx, graph, func(task *orgTask) error {
+		return s.Target.DeleteDepartment(ctx, task.node)
+	})
+}
+
+// 执行单个人员的所有操作，返回最后一个失败操作的错误
+func (s *Syncer) ProcessUserTask(ctx context.Context, task *DataApiEmpNode) error {
+	var lastErr error
+	record := func(action string, err error) {
+		s.RecordSyncResult("user", action, task.UserCode, err)
+		if err != nil {
+			lastErr = err
+		}
+	}
+
+	// 所在部门新建失败或被跳过时没有部门id，跳过新建和移动，下次同步时重试
+	orgSynced := func(action string) bool {
+		if org, ok := s.orgMap[task.OrgCode]; ok && len(org.DepId) > 0 {
+			return true
+		}
+		s.log.Warn("[oneauth] skip user ", action, " [", task.UserCode, ", ", task.UserName, "], org ", task.OrgCode, " not synced")
+		record(action, fmt.Errorf("%w: org %s", ErrDependencyFailed, task.OrgCode))
+		return false
+	}
+
+	s.log.Debug(fmt.Sprintf("[oneauth] task process user: [%s, %s, %s, %s, %s, %d]", task.UserCode, task.UserName, task.OrgId, task.DepId, task.Id, task.Action))
+	// 新建
+	if task.Action&(1<<0) != 0 {
+		if !orgSynced("create") {
+			return lastErr
+		}
+
+		s.log.Debug(fmt.Sprintf("[oneauth] Create user: [%s, %s, %s, %s]", task.UserCode, task.UserName, task.OrgId, task.DepId))
+		id, err := s.Target.CreateUser(ctx, task)
+		if IsOneauthConflict(err) {
+			id, err = s.adoptUser(ctx, task, err)
+		}
+		record("create", err)
+		if err != nil {
+			return err
+		}
+
+		task.Id = id
+		task.Action &^= 1 << 0
+	}
+
+	// 更新
+	if task.Action&(1<<1) != 0 {
+		s.log.Debug(fmt.Sprintf("[oneauth] Update user: [%s, %s, %s, %s]", task.UserCode, task.UserName, task.OrgId, task.DepId))
+		record("update", s.Target.UpdateUser(ctx, task))
+		task.Action &^= 1 << 1
+	}
+
+	// 移动
+	if task.Action&(1<<2) != 0 {
+		if orgSynced("move") {
+			s.log.Debug(fmt.Sprintf("[oneauth] Move user: [%s, %s, %s, %s]", task.UserCode, task.UserName, task.OrgId, task.DepId))
+			record("move", s.Target.MoveUser(ctx, task))
+		}
+		task.Action &^= 1 << 2
+	}
+
+	// 更新直属上级
+	if task.Action&(1<<4) != 0 {
+		managerCode, managerId := task.NewManager, s.managerId(task.NewManager)
+		s.log.Debug(fmt.Sprintf("[oneauth] Update user manager: [%s, %s, %s] -> [%s]", task.UserCode, task.UserName, task.Id, managerCode))
+		err := s.Target.SetUserManager(ctx, task, managerId)
+		if err == nil {
+			task.ManagerCode = managerCode
+			task.ManagerId = managerId
+		}
+		record("leader", err)
+		task.Action &^= 1 << 4
+	}
+
+	// 删除
+	if task.Action&(1<<3) != 0 {
+		s.log.Debug(fmt.Sprintf("[oneauth] Delete user: [%s, %s, %s]", task.UserCode, task.UserName, task.Id))
+		record("delete", s.Target.DeleteUser(ctx, task))
+		task.Action &^= 1 << 3
+	}
+
+	return lastErr
+}
+
+// 并发执行人员任务，所有协程从同一个队列中取任务，执行慢的任务不会阻塞其他任务
+// 开启system.adaptive时根据限流、服务端错误和任务耗时自动调整并发数，不超过system.fiber
+func (s *Syncer) ProcessUsersTaskQueue(ctx context.Context, taskUsersQueue *Queue) {
+	if taskUsersQueue.Len() <= 0 {
+		return
+	}
+
+	s.log.Info("[task] ProcessUsersTaskQueue user task queue size: ", taskUsersQueue.Len())
+
+	fiberCount, _ := strconv.Atoi(s.Config.System.Fiber)
+	if fiberCount < 1 {
+		fiberCount = 1
+	}
+	if fiberCount > taskUsersQueue.Len() {
+		fiberCount = taskUsersQueue.Len()
+	}
+
+	// 所有任务一次放入队列，协程退出时不会阻塞
+	tasks := make(chan *DataApiEmpNode, taskUsersQueue.Len())
+	for taskUsersQueue.Len() > 0 {
+		tasks <- taskUsersQueue.Pop().(*DataApiEmpNode)
+	}
+	close(tasks)
+
+	limit := newAdaptiveLimit(fiberCount, s.Config.System.Adaptive)
+	MetricUserConcurrency.Set(float64(limit.current()), s.Name())
+
+	var wg sync.WaitGroup
+	for i := 0; i < fiberCount; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for task := range tasks {
+				limit.acquire()
+				// 收到退出请求时不再执行剩余任务
+				if s.stopTask(ctx) {
+					limit.release(0, false, nil)
+					return
+				}
+
+				action := ActionName(task.Action)
+				taskCtx, throttled := withThrottleCounter(ctx)
+				start := time.Now()
+				err := s.ProcessUserTask(taskCtx, task)
+				elapsed := time.Since(start)
+				MetricTaskDuration.Observe(elapsed.Seconds(), s.Name(), "user", action)
+
+				if current, changed := limit.release(elapsed, atomic.LoadInt32(throttled) > 0, err); changed {
+					MetricUserConcurrency.Set(float64(current), s.Name())
+					s.log.Info("[task] user task concurrency changed to ", current)
+				}
+				s.log.Debug("[task] user ", task.UserCode, " ", action, " took ", elapsed)
+			}
+		}()
+	}
+
+	// 等待协程都执行完毕
+	wg.Wait()
+}
@@ -0,0 +1,57 @@
+package agent
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 将hh:mm:ss格式的时间转化为秒数，基准为当天0点0分0秒的时间差
+func ParseReadTime(timeStr string) (time.Duration, error) {
+	if len(timeStr) == 0 {
+		return 0, nil
+	}
+
+	var timeArr = strings.Split(timeStr, ":")
+	if len(timeArr) != 3 {
+		return 0, errors.New("readtime must be hh:mm:ss")
+	}
+
+	hour, err1 := strconv.Atoi(timeArr[0])
+	minute, err2 := strconv.Atoi(timeArr[1])
+	second, err3 := strconv.Atoi(timeArr[2])
+	if err1 != nil || err2 != nil || err3 != nil ||
+		hour < 0 || hour > 23 || minute < 0 || minute > 59 || second < 0 || second > 59 {
+		return 0, errors.New("readtime must be hh:mm:ss")
+	}
+
+	return time.Duration(hour*3600 + minute*60 + second), nil
+}
+
+// 启动后立即执行一次，之后按同步计划定时执行，上一次未执行完时由f自行跳过
+func InitTimer(f func(), schedules []Schedule) {
+	go func() {
+		// 执行定时任务
+		f()
+
+		for {
+			// 设置定时器
+			now := time.Now()
+			next := NextScheduleTime(schedules, now)
+			if next.IsZero() {
+				log.Error("timer: no schedule will fire again, stop timer")
+				return
+			}
+
+			log.Info("timer: ", next.Sub(now), ", next: ", next.Format("2006-01-02 15:04:05 MST"))
+			t := time.NewTimer(next.Sub(now))
+			<-t.C
+
+			// 同步放到协程中执行，保证计划按时触发
+			go f()
+		}
+	}()
+}
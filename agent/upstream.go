@@ -0,0 +1,728 @@
+package agent
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// 创建相关接口返回结构
+type OrgStatus struct {
+	OrgId   string `json:"orgId"`
+	Success bool   `json:"success"`
+}
+
+type DepStatus struct {
+	DepId   string `json:"depId"`
+	Success bool   `json:"success"`
+}
+
+type UsersStatus struct {
+	UserId  string `json:"userId"`
+	Success bool   `json:"success"`
+}
+
+// 获取根节点接口返回相关结构
+type RootInfo struct {
+	OrgId    string `json:"orgId"` // 根节点id
+	Name     string `json:"name"`
+	OriginId string `json:"originId"` // 对应的外部id
+}
+
+type RootRspInfo struct {
+	Count int        `json:"count"`
+	Roots []RootInfo `json:"organizations"`
+}
+
+// 获取对应根节点下组织架构接口返回相关结构
+type OrgInfo struct {
+	ParentId  string `json:"parentId"` // 上级部门id
+	Name      string `json:"name"`
+	DepId     string `json:"DepId"`     // 当前部门id
+	OriginId  string `json:"originId"`  // 对应的外部id
+	ManagerId string `json:"managerId"` // 部门主管用户id
+}
+
+type OrgRspInfo struct {
+	LevelCount int       `json:"LevelCount"`
+	TreeStruct []OrgInfo `json:"treeStruct"`
+}
+
+// 获取对应根节点下所有人员接口返回相关结构
+type UserDepInfo struct {
+	OrgId string   `json:"orgId,omitempty"`
+	DepId []string `json:"depId,omitempty"`
+}
+
+type MemInfo struct {
+	Email       string `json:"email"`
+	Account     string `json:"account"`
+	EmployeeId  string `json:"employeeId"`
+	DisplayName string `json:"displayName"`
+	UserId      string `json:"userId"`
+	Status      int    `json:"status"`
+	ManagerId   string `json:"managerId"` // 直属上级用户id
+
+	Department []UserDepInfo `json:"department"` // 人员所在部门id
+}
+
+type MemRspInfo struct {
+	Count   int       `json:"count"`
+	Members []MemInfo `json:"users"`
+}
+
+// 组织架构信息
+type DataOrgNode struct {
+	Name        string // 部门名字
+	OrgUnitCode string // 外部部门id
+	Status      string // 部门状态
+	FatherCode  string // 父级部门id
+	FatherName  string // 父级部门名字
+	OrgId       string // oneauth根节点id
+	DepId       string // oneauth部门id
+	ParentId    string // oneauth父级id
+	ManagerId   string // oneauth部门主管用户id
+	LeaderCode  string // 部门主管工号
+}
+
+// 获取所有根节点
+var GetAllRoots = "/api/v1/account/org?page=1&limit=1000"
+
+// 更新根节点信息
+var UpdateOrgRoot = "/api/v1/account/org/%s?"
+
+// 获取根节点下所有组织架构
+var GetAllOrgs = "/api/v1/account/org/%s/tree"
+
+// 获取根节点下所有人员
+var GetAllMembers = "/api/v1/account/user?"
+
+// 创建组织架构根节点
+var CreateOrgRoot = "/api/v1/account/org?"
+
+// 创建组织架构下部门
+var CreateOrgDepartment = "/api/v1/account/org/%s/department?"
+
+// 部门更新+移动
+var UpdateOrgDepartment = "/api/v1/account/org/%s/department/%s"
+var MoveOrgDepartment = "/api/v1/account/org/%s/department/%s/shift/%s"
+var DeleteOrgDepartment = "/api/v1/account/org/%s/department/%s"
+var SetDepartmentManager = "/api/v1/account/org/%s/department/%s/manager"
+
+var CreateUser = "/api/v1/account/user"
+var UpdateUser = "/api/v1/account/user/%s"
+var MoveUser = "/api/v1/account/user/%s/org/%s/department/%s"
+var DelUser = "/api/v1/account/user/%s/lifecycle/remove"
+
+// 清空从oneauth读取的数据
+func (s *Syncer) UpstreamDataClear() {
+	if s.upstreamUsers != nil {
+		s.upstreamUsers = nil
+	}
+
+	if s.upstreamExtraKey != nil {
+		s.upstreamExtraKey = nil
+	}
+
+	if s.upstreamInsideKey != nil {
+		s.upstreamInsideKey = nil
+	}
+}
+
+// 从oneauth读取所有组织架构和人员，作为第一次同步的比对基准
+func (s *Syncer) SyncDataFromOneAuth(ctx context.Context) error {
+	// 同步根节点数据
+	roots, err := s.Target.ListRoots(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.log.Debug(roots)
+
+	// 创建upstream org的组织架构
+	s.upstreamExtraKey = make(map[string]*DataOrgNode)
+	s.upstreamInsideKey = make(map[string]*DataOrgNode)
+
+	// 将数据存在内存中，不做维护
+	for _, node := range roots {
+		newOrg := new(DataOrgNode)
+		newOrg.OrgId = node.OrgId
+		newOrg.DepId = node.OrgId
+		newOrg.OrgUnitCode = node.OriginId
+		newOrg.Name = node.Name
+
+		s.upstreamExtraKey[newOrg.OrgUnitCode] = newOrg
+		s.upstreamInsideKey[newOrg.DepId] = newOrg
+
+		// 从oneauth同步对应根节点组织架构信息
+		deps, err := s.Target.ListDepartments(ctx, node.OrgId)
+		if err != nil {
+			continue
+		}
+
+		// 直接粗暴解决判断是否存在，做调整
+		for _, depNode := range deps {
+			newDep := new(DataOrgNode)
+			newDep.OrgId = node.OrgId
+			newDep.DepId = depNode.DepId
+			newDep.OrgUnitCode = depNode.OriginId
+			newDep.Name = depNode.Name
+			newDep.ParentId = depNode.ParentId
+			newDep.ManagerId = depNode.ManagerId
+
+			s.upstreamExtraKey[newDep.OrgUnitCode] = newDep
+			s.upstreamInsideKey[newDep.DepId] = newDep
+		}
+
+		// 调整外部fatherid的对应关系
+		for _, value := range s.upstreamExtraKey {
+			if len(value.ParentId) > 0 && (len(value.FatherCode) == 0 || len(value.FatherName) == 0) {
+				if father, ok := s.upstreamInsideKey[value.ParentId]; ok {
+					value.FatherCode = father.OrgUnitCode
+					value.FatherName = father.Name
+				}
+			}
+		}
+
+	}
+
+	// 从oneauth同步人员信息
+	users, err := s.Target.ListUsers(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.upstreamUsers = make(map[string]*DataApiEmpNode)
+	for _, user := range users {
+		newUser := new(DataApiEmpNode)
+		newUser.Status = strconv.Itoa(user.Status)
+		newUser.UserName = user.DisplayName
+		newUser.UserCode = user.EmployeeId
+		newUser.OAID = user.Account
+		newUser.Id = user.UserId
+		newUser.Email = user.Email
+		newUser.ManagerId = user.ManagerId
+		if len(user.Department) > 0 {
+			newUser.OrgId = user.Department[0].OrgId
+			if len(user.Department[0].DepId) > 0 {
+				newUser.DepId = user.Department[0].DepId[0]
+			}
+		}
+
+		s.upstreamUsers[newUser.UserCode] = newUser
+		s.log.Trace(fmt.Sprintf("[onesuth] Get user: [%s, %s, %s, %s, %s, %s]",
+			newUser.UserCode, newUser.UserName, newUser.OAID, newUser.Id, newUser.OrgId, newUser.DepId))
+	}
+
+	// 将部门主管和直属上级的用户id转换为工号
+	userCodes := make(map[string]string, len(s.upstreamUsers))
+	for _, user := range s.upstreamUsers {
+		userCodes[user.Id] = user.UserCode
+	}
+
+	for _, dep := range s.upstreamExtraKey {
+		dep.LeaderCode = userCodes[dep.ManagerId]
+	}
+
+	for _, user := range s.upstreamUsers {
+		user.ManagerCode = userCodes[user.ManagerId]
+	}
+
+	return nil
+}
+
+func UpstreamConn(ctx context.Context, network, addr string) (net.Conn, error) {
+	// netAddr := &net.TCPAddr{Port: apiConfig.localPort}
+	dial := net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 60 * 5 * time.Second,
+		// LocalAddr: netAddr,
+	}
+
+	conn, err := dial.DialContext(ctx, network, addr)
+	if err != nil {
+		return conn, err
+	}
+
+	return conn, err
+}
+
+// 同步到oneauth的目标实现
+type OneauthTarget struct {
+	Config     *OneAuthConfig
+	client     *http.Client            // 组织架构接口使用的client
+	userClient *http.Client            // 人员接口并发执行，使用独立的连接池
+	userConns  int                     // 人员接口连接池保留的空闲连接数
+	limiters   map[string]*TokenBucket // 各类接口的限流器，所有协程共享
+	log        *log.Entry
+}
+
+// 空闲连接总数不少于单个host的空闲连接数，否则并发数较大时连接不能复用
+func maxIdleConns(maxIdleConnsPerHost int) int {
+	if maxIdleConnsPerHost > 10 {
+		return maxIdleConnsPerHost
+	}
+	return 10
+}
+
+func newUpstreamClient(maxIdleConnsPerHost int) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext:         UpstreamConn,
+			MaxIdleConns:        maxIdleConns(maxIdleConnsPerHost),
+			MaxIdleConnsPerHost: maxIdleConnsPerHost,
+			IdleConnTimeout:     20 * time.Second,
+			DisableKeepAlives:   false,
+			TLSClientConfig:     &tls.Config{InsecureSkipVerify: true},
+		},
+		// 超时时间由每次请求的ctx控制，读写接口分别配置
+	}
+}
+
+// 人员接口连接池的空闲连接数，和并发数一致，所有协程的连接都可以复用
+func userConns(fiber string) int {
+	fiberNum, err := strconv.Atoi(fiber)
+	if err != nil || fiberNum < 2 {
+		fiberNum = 2
+	}
+	return fiberNum
+}
+
+// 创建oneauth目标，fiber为人员接口的并发数
+func NewOneauthTarget(config *OneAuthConfig, fiber string) *OneauthTarget {
+	conns := userConns(fiber)
+	return &OneauthTarget{
+		Config:     config,
+		client:     newUpstreamClient(2),
+		userClient: newUpstreamClient(conns),
+		userConns:  conns,
+		limiters:   NewRateLimiters(config.RateLimit),
+		log:        log.NewEntry(log.StandardLogger()),
+	}
+}
+
+// fiber热加载后按新的并发数重建人员接口的连接池，调用时不能有正在执行的请求
+func (t *OneauthTarget) SetUserConcurrency(fiber string) {
+	conns := userConns(fiber)
+	if conns == t.userConns {
+		return
+	}
+
+	t.log.Info("[oneauth] resize user connection pool from ", t.userConns, " to ", conns)
+	t.userClient.CloseIdleConnections()
+	t.userClient = newUpstreamClient(conns)
+	t.userConns = conns
+}
+
+// 调用oneauth接口，api为接口模板名，用于统计；网络错误、限流和服务端错误按重试策略重试
+// 每次请求使用按读写类别配置的超时时间，ctx取消后不再重试
+func (t *OneauthTarget) GetDataByOneauthApi(ctx context.Context, client *http.Client, api, method, urlStr, reqBody string) ([]byte, error) {
+	for attempt := 1; ; attempt++ {
+		body, err := t.doOneauthRequest(ctx, client, api, method, urlStr, reqBody)
+		if err == nil {
+			return body, nil
+		}
+		noteThrottled(ctx, err)
+
+		if attempt >= t.Config.Retry.Attempts || ctx.Err() != nil || !ShouldRetry(err) {
+			return nil, err
+		}
+
+		var retryAfter time.Duration
+		var oneauthErr *OneauthError
+		if errors.As(err, &oneauthErr) {
+			retryAfter = oneauthErr.RetryAfter
+		}
+
+		wait := RetryBackoff(t.Config.Retry, attempt, retryAfter)
+		t.log.Warn("[http] oneauth [", api, "] attempt ", attempt, " failed, retry after ", wait, ": ", err)
+		if err := sleepContext(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// 发起一次oneauth接口请求，失败时返回OneauthError
+func (t *OneauthTarget) doOneauthRequest(ctx context.Context, client *http.Client, api, method, urlStr, reqBody string) ([]byte, error) {
+	class := OneauthApiClass(api, method)
+	if err := t.WaitUpstreamLimit(ctx, class); err != nil {
+		return nil, err
+	}
+
+	timeout := t.Config.Timeout.WriteDuration
+	if class == "read" {
+		timeout = t.Config.Timeout.ReadDuration
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	data := strings.NewReader(reqBody)
+	req, err := http.NewRequestWithContext(ctx, method, urlStr, data)
+	if err != nil {
+		t.log.Info("[http] oneauth create new request [", urlStr, "]  error: ", err)
+		return nil, err
+	}
+
+	req.Header.Set("accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", string(t.Config.Token))
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	MetricUpstreamLatency.Observe(time.Since(start).Seconds(), api)
+	if err != nil {
+		MetricUpstreamRequests.Inc(api, metricCode(0))
+		t.log.Info("[http] oneauth recv [", urlStr, "] http response error: ", err)
+		return nil, &OneauthError{Api: api, Method: method, Url: urlStr, Err: err}
+	}
+	MetricUpstreamRequests.Inc(api, metricCode(resp.StatusCode))
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.log.Info("[http] oneauth read [", urlStr, "] http response body error: ", err)
+		return nil, &OneauthError{Api: api, Method: method, Url: urlStr, Err: err}
+	}
+
+	if resp.StatusCode != 200 && resp.StatusCode != 201 {
+		return nil, &OneauthError{
+			Api:        api,
+			Method:     method,
+			Url:        urlStr,
+			StatusCode: resp.StatusCode,
+			Body:       string(body),
+			RetryAfter: ParseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+
+	//log.Info(string(body))
+	return body, nil
+}
+
+func ProcessUpstreamRootsResponse(body []byte) (RootRspInfo, error) {
+	var responseData RootRspInfo
+	if err := json.Unmarshal(body, &responseData); err != nil {
+		log.Info("[http] root response json unmarshal error: ", err)
+		log.Info(body)
+		return responseData, err
+	}
+
+	return responseData, nil
+}
+
+// 获取所有根节点
+func (t *OneauthTarget) ListRoots(ctx context.Context) ([]RootInfo, error) {
+	// 从oneauth同步根节点组织信息
+	rootUrl := t.Config.BaseUrl + GetAllRoots
+	rootBody, err := t.GetDataByOneauthApi(ctx, t.client, "GetAllRoots", "GET", rootUrl, "")
+	if err != nil {
+		t.log.Error("[http] oneauth get all roots error: ", err)
+		return nil, err
+	}
+
+	rootData, err := ProcessUpstreamRootsResponse(rootBody)
+	if err != nil {
+		t.log.Error("[http] oneauth parse all roots error: ", err)
+		return nil, err
+	}
+
+	return rootData.Roots, nil
+}
+
+func ProcessUpstreamOrgResponse(body []byte) (OrgRspInfo, error) {
+	var responseData OrgRspInfo
+	if err := json.Unmarshal(body, &responseData); err != nil {
+		log.Info("[http] org response json unmarshal error: ", err)
+		log.Info(body)
+		return responseData, err
+	}
+
+	return responseData, nil
+}
+
+// 获取根节点下所有部门
+func (t *OneauthTarget) ListDepartments(ctx context.Context, orgId string) ([]OrgInfo, error) {
+	orgUrl := fmt.Sprintf(t.Config.BaseUrl+GetAllOrgs, orgId)
+	orgBody, err := t.GetDataByOneauthApi(ctx, t.client, "GetAllOrgs", "GET", orgUrl, "")
+	if err != nil {
+		t.log.Error("[http] oneauth get all org error: ", err)
+		return nil, err
+	}
+
+	orgData, err := ProcessUpstreamOrgResponse(orgBody)
+	if err != nil {
+		t.log.Error("[http] oneauth parse all org error: ", err)
+		return nil, err
+	}
+
+	return orgData.TreeStruct, nil
+}
+
+// 分页获取所有人员
+func (t *OneauthTarget) ListUsers(ctx context.Context) ([]MemInfo, error) {
+	var users []MemInfo
+	for page := 1; ; page++ {
+		userUrl := t.Config.BaseUrl + GetAllMembers
+		params := url.Values{}
+		params.Add("page", strconv.Itoa(page))
+		params.Add("limit", "100")
+		userUrl += params.Encode()
+
+		userBody, err := t.GetDataByOneauthApi(ctx, t.client, "GetAllMembers", "GET", userUrl, "")
+		if err != nil {
+			t.log.Error("[http] oneauth get all users error: ", err)
+			return nil, err
+		}
+
+		userData, err := ProcessUpstreamMemberResponse(userBody)
+		if err != nil {
+			t.log.Error("[http] oneauth parse all users error: ", err)
+			return nil, err
+		}
+
+		if len(userData.Members) == 0 {
+			break
+		}
+
+		users = append(users, userData.Members...)
+	}
+
+	return users, nil
+}
+
+func ProcessUpstreamMemberResponse(body []byte) (MemRspInfo, error) {
+	var responseData MemRspInfo
+	if err := json.Unmarshal(body, &responseData); err != nil {
+		log.Info("[http] members response json unmarshal error: ", err)
+		//log.Info(body)
+		return responseData, err
+	}
+
+	return responseData, nil
+}
+
+// 创建根节点
+func (t *OneauthTarget) CreateRoot(ctx context.Context, node *DataOrgMemNode) (string, error) {
+	urlStr := t.Config.BaseUrl + CreateOrgRoot
+	params := url.Values{}
+	params.Add("orgName", node.NodeName)
+	params.Add("originId", node.NodeCode)
+	urlStr += params.Encode()
+
+	rootBody, err := t.GetDataByOneauthApi(ctx, t.client, "CreateOrgRoot", "POST", urlStr, "")
+	if err != nil {
+		t.log.Error("[http] oneauth create roots [", node.NodeName, "] error: ", err)
+		return "", err
+	}
+
+	var responseData OrgStatus
+	if err := json.Unmarshal(rootBody, &responseData); err != nil {
+		t.log.Info("[http] members response json unmarshal error: ", err)
+		return "", err
+	}
+
+	return responseData.OrgId, nil
+}
+
+// 创建部门，父级部门id为node.FatherId，为空时创建在根节点下
+func (t *OneauthTarget) CreateDepartment(ctx context.Context, node *DataOrgMemNode) (string, error) {
+	urlStr := fmt.Sprintf(t.Config.BaseUrl+CreateOrgDepartment, node.OrgId)
+	params := url.Values{}
+	params.Add("department", node.NodeName)
+	params.Add("originId", node.NodeCode)
+	if len(node.FatherId) > 0 {
+		params.Add("parentId", node.FatherId)
+	}
+	urlStr += params.Encode()
+
+	rootBody, err := t.GetDataByOneauthApi(ctx, t.client, "CreateOrgDepartment", "POST", urlStr, "")
+	if err != nil {
+		t.log.Error("[http] oneauth create department error: ", err)
+		return "", err
+	}
+
+	var responseData DepStatus
+	if err := json.Unmarshal(rootBody, &responseData); err != nil {
+		t.log.Info("[http] members response json unmarshal error: ", err)
+		return "", err
+	}
+
+	return responseData.DepId, nil
+}
+
+// 更新根节点
+func (t *OneauthTarget) UpdateRoot(ctx context.Context, node *DataOrgMemNode) error {
+	urlStr := fmt.Sprintf(t.Config.BaseUrl+UpdateOrgRoot, node.OrgId)
+	params := url.Values{}
+	params.Add("name", node.NodeName)
+	urlStr += params.Encode()
+
+	_, err := t.GetDataByOneauthApi(ctx, t.client, "UpdateOrgRoot", "PUT", urlStr, "")
+	if err != nil {
+		t.log.Error("[http] oneauth create roots [", node.NodeName, "] error: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// 更新普通部门节点，按Action更新名字和移动到node.FatherId
+func (t *OneauthTarget) UpdateDepartment(ctx context.Context, node *DataOrgMemNode) error {
+	if node.Action&(1<<1) != 0 {
+		urlStr := fmt.Sprintf(t.Config.BaseUrl+UpdateOrgDepartment, node.OrgId, node.DepId)
+		body := fmt.Sprintf("{\"name\": \"%s\"}", node.NodeName)
+
+		_, err := t.GetDataByOneauthApi(ctx, t.client, "UpdateOrgDepartment", "PUT", urlStr, body)
+		if err != nil {
+			t.log.Error("[http] oneauth update department error: ", err)
+			return err
+		}
+
+		// 清除位标记
+		node.Action &^= 1 << 1
+	}
+
+	if node.Action&(1<<2) != 0 {
+		urlStr := fmt.Sprintf(t.Config.BaseUrl+MoveOrgDepartment, node.OrgId, node.DepId, node.FatherId)
+		_, err := t.GetDataByOneauthApi(ctx, t.client, "MoveOrgDepartment", "PUT", urlStr, "")
+		if err != nil {
+			t.log.Error("[http] oneauth move department error: ", err)
+			return err
+		}
+
+		// 清除位标记
+		node.Action &^= 1 << 2
+	}
+
+	return nil
+}
+
+// 设置部门主管，userId为空时清除主管
+func (t *OneauthTarget) SetDepartmentManager(ctx context.Context, node *DataOrgMemNode, userId string) error {
+	urlStr := fmt.Sprintf(t.Config.BaseUrl+SetDepartmentManager, node.OrgId, node.DepId)
+	body := `{"userId":[]}`
+	if len(userId) > 0 {
+		body = fmt.Sprintf(`{"userId":["%s"]}`, userId)
+	}
+
+	_, err := t.GetDataByOneauthApi(ctx, t.client, "SetDepartmentManager", "PUT", urlStr, body)
+	if err != nil {
+		t.log.Error("[http] oneauth set department manager [", node.NodeCode, ", ", node.NodeName, "] error: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// 删除部门，部门已不存在时视为成功
+func (t *OneauthTarget) DeleteDepartment(ctx context.Context, node *DataOrgMemNode) error {
+	urlStr := fmt.Sprintf(t.Config.BaseUrl+DeleteOrgDepartment, node.OrgId, node.DepId)
+	_, err := t.GetDataByOneauthApi(ctx, t.client, "DeleteOrgDepartment", "DELETE", urlStr, "")
+	if IsOneauthNotFound(err) {
+		t.log.Info("[http] oneauth org [", node.NodeCode, ", ", node.NodeName, "] already deleted")
+		return nil
+	}
+	if err != nil {
+		t.log.Error("[http] oneauth delete org [", node.NodeCode, ", ", node.NodeName, "], [", urlStr, "] error: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// 创建人员
+func (t *OneauthTarget) CreateUser(ctx context.Context, node *DataApiEmpNode) (string, error) {
+	urlStr := t.Config.BaseUrl + CreateUser
+	body := fmt.Sprintf(`{"account":"%s","displayName":"%s","gender":"","idCardNumber":"","address":"","mobilePhone":"","nickName":"","groupId":["1"],"jobTitle":"","isImport":true,"firstName":"%s","lastName":"","birthday":"2022-06-06","email":"%s","employeeId":"%s","orgId":"%s","departmentId":["%s"]}`,
+		node.OAID, node.UserName, node.UserName, node.Email, node.UserCode, node.OrgId, node.DepId)
+
+	t.log.Trace(body)
+
+	rootBody, err := t.GetDataByOneauthApi(ctx, t.userClient, "CreateUser", "POST", urlStr, body)
+	if err != nil {
+		t.log.Error("[http] oneauth create users [", node.UserCode, ", ", node.UserName, "] error: ", err)
+		return "", err
+	}
+
+	var responseData UsersStatus
+	if err := json.Unmarshal(rootBody, &responseData); err != nil {
+		t.log.Error("[http] oneauth create users response json unmarshal [", node.UserCode, ", ", node.UserName, "] error: ", err)
+		return "", err
+	}
+
+	return responseData.UserId, nil
+}
+
+// 更新人员信息
+func (t *OneauthTarget) UpdateUser(ctx context.Context, node *DataApiEmpNode) error {
+	urlStr := fmt.Sprintf(t.Config.BaseUrl+UpdateUser, node.Id)
+	body := fmt.Sprintf(`{"propval":{"account":"%s","displayName":"%s","gender":"","idCardNumber":"","address":"","mobilePhone":"","nickName":"","groupId":["1"],"jobTitle":"","isImport":true,"firstName":"%s","lastName":"","birthday":"2022-06-06","email":"%s","employeeId":"%s","orgId":"%s","departmentId":["%s"]}}`,
+		node.OAID, node.UserName, node.UserName, node.Email, node.UserCode, node.OrgId, node.DepId)
+
+	t.log.Trace(body)
+
+	_, err := t.GetDataByOneauthApi(ctx, t.userClient, "UpdateUser", "PUT", urlStr, body)
+	if err != nil {
+		t.log.Error("[http] oneauth update user [", node.UserCode, ", ", node.UserName, ", ", node.Id, "] error: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// 移动人员到node.DepId
+func (t *OneauthTarget) MoveUser(ctx context.Context, node *DataApiEmpNode) error {
+	urlStr := fmt.Sprintf(t.Config.BaseUrl+MoveUser, node.Id, node.OrgId, node.DepId)
+	_, err := t.GetDataByOneauthApi(ctx, t.userClient, "MoveUser", "PUT", urlStr, "")
+	if err != nil {
+		t.log.Error("[http] oneauth move user [", node.UserCode, ", ", node.UserName, ", ", node.Id, "] error: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// 删除人员，人员已不存在时视为成功
+func (t *OneauthTarget) DeleteUser(ctx context.Context, node *DataApiEmpNode) error {
+	urlStr := fmt.Sprintf(t.Config.BaseUrl+DelUser, node.Id)
+	_, err := t.GetDataByOneauthApi(ctx, t.userClient, "DelUser", "PUT", urlStr, "")
+	if IsOneauthNotFound(err) {
+		t.log.Info("[http] oneauth user [", node.UserCode, ", ", node.UserName, ", ", node.Id, "] already deleted")
+		return nil
+	}
+	if err != nil {
+		t.log.Error("[http] oneauth delete user [", node.UserCode, ", ", node.UserName, ", ", node.Id, "] error: ", err)
+		return err
+	}
+
+	return nil
+}
+
+// 更新人员直属上级，managerId为空时清除
+func (t *OneauthTarget) SetUserManager(ctx context.Context, node *DataApiEmpNode, managerId string) error {
+	urlStr := fmt.Sprintf(t.Config.BaseUrl+UpdateUser, node.Id)
+	body := fmt.Sprintf(`{"propval":{"managerId":"%s"}}`, managerId)
+
+	_, err := t.GetDataByOneauthApi(ctx, t.userClient, "UpdateUserManager", "PUT", urlStr, body)
+	if err != nil {
+		t.log.Error("[http] oneauth update user manager [", node.UserCode, ", ", node.UserName, ", ", node.Id, "] error: ", err)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,355 @@
+package agent
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// 配置中的一个问题，Path为yaml路径，如jobs[0].database.port，Line为所在行，未知时为0
+type ConfigProblem struct {
+	Path    string `json:"path"`
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+func (p ConfigProblem) String() string {
+	if p.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", p.Line, p.Path, p.Message)
+	}
+	return p.Path + ": " + p.Message
+}
+
+// 配置校验失败，包含所有发现的问题
+type ConfigError struct {
+	Problems []ConfigProblem
+}
+
+func (e *ConfigError) Error() string {
+	lines := make([]string, 0, len(e.Problems))
+	for _, problem := range e.Problems {
+		lines = append(lines, problem.String())
+	}
+	return "invalid config:\n  " + strings.Join(lines, "\n  ")
+}
+
+// 收集配置问题，根据yaml路径查找所在行
+type configValidator struct {
+	lines    map[string]int // yaml路径对应的行号
+	problems []ConfigProblem
+}
+
+// 查找路径所在的行，路径本身没有出现在配置文件中时使用最近的上级路径
+func (v *configValidator) line(path string) int {
+	for len(path) > 0 {
+		if line, ok := v.lines[path]; ok {
+			return line
+		}
+
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			break
+		}
+		path = path[:i]
+	}
+	return 0
+}
+
+func (v *configValidator) add(path, format string, args ...interface{}) {
+	v.problems = append(v.problems, ConfigProblem{Path: path, Line: v.line(path), Message: fmt.Sprintf(format, args...)})
+}
+
+func (v *configValidator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	// 按行号排序，没有行号的问题放在最后
+	sort.SliceStable(v.problems, func(i, j int) bool {
+		li, lj := v.problems[i].Line, v.problems[j].Line
+		return li > 0 && (lj == 0 || li < lj)
+	})
+	return &ConfigError{Problems: v.problems}
+}
+
+var yamlErrorLine = regexp.MustCompile(`^line (\d+): (.*)$`)
+var yamlUnknownField = regexp.MustCompile(`^field (\S+) not found in type \S+$`)
+
+// 将yaml解析错误转换为配置问题，未知字段和类型错误会全部列出
+func (v *configValidator) addYamlError(err error, linePaths map[int]string) {
+	var messages []string
+	if typeErr, ok := err.(*yaml.TypeError); ok {
+		messages = typeErr.Errors
+	} else {
+		messages = []string{strings.TrimPrefix(err.Error(), "yaml: ")}
+	}
+
+	for _, message := range messages {
+		problem := ConfigProblem{Path: "(root)", Message: message}
+		if m := yamlErrorLine.FindStringSubmatch(message); m != nil {
+			problem.Line, _ = strconv.Atoi(m[1])
+			problem.Message = m[2]
+			if path, ok := linePaths[problem.Line]; ok {
+				problem.Path = path
+			}
+		}
+
+		if m := yamlUnknownField.FindStringSubmatch(problem.Message); m != nil {
+			problem.Message = "unknown field " + m[1]
+		}
+		v.problems = append(v.problems, problem)
+	}
+}
+
+// 扫描块格式的yaml，记录每个路径第一次出现的行号，以及每一行对应的路径
+func IndexYamlLines(data []byte) (map[string]int, map[int]string) {
+	type frame struct {
+		indent int
+		path   string
+		item   bool // 列表项
+		open   bool // 值在下面的行中
+	}
+
+	lines := make(map[string]int)
+	linePaths := make(map[int]string)
+	counts := make(map[string]int)
+	var stack []frame
+	blockIndent := -1 // 多行字符串所在键的缩进，-1表示不在多行字符串中
+
+	for i, raw := range strings.Split(string(data), "\n") {
+		lineNo := i + 1
+		content := strings.TrimLeft(raw, " ")
+		indent := len(raw) - len(content)
+		content = strings.TrimRight(content, " \t\r")
+
+		if blockIndent >= 0 {
+			if len(content) == 0 || indent > blockIndent {
+				continue
+			}
+			blockIndent = -1
+		}
+		if len(content) == 0 || strings.HasPrefix(content, "#") || content == "---" {
+			continue
+		}
+
+		// 列表项，可能和上级键在同一缩进
+		for strings.HasPrefix(content, "- ") || content == "-" {
+			for len(stack) > 0 {
+				top := stack[len(stack)-1]
+				if top.indent > indent || (top.indent == indent && (top.item || !top.open)) {
+					stack = stack[:len(stack)-1]
+					continue
+				}
+				break
+			}
+
+			parent := ""
+			if len(stack) > 0 {
+				parent = stack[len(stack)-1].path
+			}
+			path := parent + "[" + strconv.Itoa(counts[parent]) + "]"
+			counts[parent]++
+			if _, ok := lines[path]; !ok {
+				lines[path] = lineNo
+			}
+			linePaths[lineNo] = path
+			stack = append(stack, frame{indent: indent, path: path, item: true, open: true})
+
+			rest := strings.TrimPrefix(content, "-")
+			trimmed := strings.TrimLeft(rest, " ")
+			indent += 1 + len(rest) - len(trimmed)
+			content = trimmed
+		}
+
+		key, value, ok := splitYamlKey(content)
+		if !ok {
+			continue
+		}
+
+		for len(stack) > 0 && stack[len(stack)-1].indent >= indent {
+			stack = stack[:len(stack)-1]
+		}
+
+		path := key
+		if len(stack) > 0 {
+			path = stack[len(stack)-1].path + "." + key
+		}
+		if _, ok := lines[path]; !ok {
+			lines[path] = lineNo
+		}
+		linePaths[lineNo] = path
+
+		open := len(value) == 0 || strings.HasPrefix(value, "#")
+		stack = append(stack, frame{indent: indent, path: path, open: open})
+		if strings.HasPrefix(value, "|") || strings.HasPrefix(value, ">") {
+			blockIndent = indent
+		}
+	}
+
+	return lines, linePaths
+}
+
+// 拆分yaml的键值行，不是键值行时返回false
+func splitYamlKey(content string) (string, string, bool) {
+	if len(content) == 0 || strings.ContainsAny(content[:1], "{[\"'") && !strings.HasPrefix(content, "\"") && !strings.HasPrefix(content, "'") {
+		return "", "", false
+	}
+
+	var key, rest string
+	if quote := content[0]; quote == '"' || quote == '\'' {
+		end := strings.IndexByte(content[1:], quote)
+		if end < 0 {
+			return "", "", false
+		}
+		key = content[1 : end+1]
+		rest = content[end+2:]
+		if !strings.HasPrefix(rest, ":") {
+			return "", "", false
+		}
+		rest = rest[1:]
+	} else {
+		i := strings.Index(content, ":")
+		for i >= 0 && i+1 < len(content) && content[i+1] != ' ' && content[i+1] != '\t' {
+			next := strings.Index(content[i+1:], ":")
+			if next < 0 {
+				i = -1
+				break
+			}
+			i += next + 1
+		}
+		if i <= 0 {
+			return "", "", false
+		}
+		key = strings.TrimSpace(content[:i])
+		rest = content[i+1:]
+	}
+
+	if len(rest) > 0 && rest[0] != ' ' && rest[0] != '\t' {
+		return "", "", false
+	}
+	return key, strings.TrimSpace(rest), true
+}
+
+var jobNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+
+// 检查主机名或ip，不能带协议、路径和端口
+func checkHost(v *configValidator, path, host string) {
+	if len(host) == 0 {
+		v.add(path, "must be set")
+		return
+	}
+
+	if strings.Contains(host, "://") {
+		v.add(path, "must be a host name without scheme, got %q", host)
+		return
+	}
+
+	if net.ParseIP(strings.Trim(host, "[]")) != nil {
+		return
+	}
+
+	if len(host) > 253 || strings.ContainsAny(host, "/:?# \t") {
+		v.add(path, "is not a valid host name: %q", host)
+		return
+	}
+
+	for _, label := range strings.Split(host, ".") {
+		if len(label) == 0 || len(label) > 63 || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			v.add(path, "is not a valid host name: %q", host)
+			return
+		}
+		for _, r := range label {
+			if r != '-' && r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+				v.add(path, "is not a valid host name: %q", host)
+				return
+			}
+		}
+	}
+}
+
+// 检查端口号
+func checkPort(v *configValidator, path, port string) {
+	if len(port) == 0 {
+		v.add(path, "must be set")
+		return
+	}
+
+	n, err := strconv.Atoi(port)
+	if err != nil || n < 1 || n > 65535 {
+		v.add(path, "must be a number between 1 and 65535, got %q", port)
+	}
+}
+
+// 检查监听地址，格式为host:port，host可以为空
+func checkListen(v *configValidator, path, listen string) {
+	host, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		v.add(path, "must be host:port, got %q", listen)
+		return
+	}
+
+	if len(host) > 0 {
+		checkHost(v, path, host)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+		v.add(path, "port must be a number between 0 and 65535, got %q", port)
+	}
+}
+
+// 检查整数配置项的取值范围
+func checkInt(v *configValidator, path, value string, min, max int) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < min || n > max {
+		v.add(path, "must be an integer between %d and %d, got %q", min, max, value)
+	}
+}
+
+// 检查主数据的组织编码或名称是否合理
+func checkOrgCode(v *configValidator, path, code string) {
+	if len(strings.TrimSpace(code)) == 0 {
+		v.add(path, "must not be empty")
+		return
+	}
+
+	if strings.TrimSpace(code) != code {
+		v.add(path, "must not have leading or trailing spaces: %q", code)
+		return
+	}
+
+	for _, r := range code {
+		if unicode.IsControl(r) {
+			v.add(path, "must not contain control characters: %q", code)
+			return
+		}
+	}
+
+	if len(code) > 128 {
+		v.add(path, "is too long to be an org code or name: %d characters", len(code))
+	}
+}
+
+// 检查时间间隔配置，返回解析后的值
+func checkDuration(v *configValidator, path, value string) time.Duration {
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration < 0 {
+		v.add(path, "must be a duration such as 30s or 72h, got %q", value)
+		return 0
+	}
+	return duration
+}
+
+// 检查请求超时配置，必须大于0
+func checkTimeout(v *configValidator, path, value string) time.Duration {
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout <= 0 {
+		v.add(path, "must be a positive duration such as 30s, got %q", value)
+		return 0
+	}
+	return timeout
+}
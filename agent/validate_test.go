@@ -0,0 +1,152 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+)
+
+// 所有问题一次列出，并带有yaml路径和行号
+func TestParseConfigProblems(t *testing.T) {
+	data := `system:
+  fiber: ten
+  log:
+    level: "9"
+database:
+  host: https://datapub.example.com
+  port: "99999"
+  user:
+    appkey: key
+    appsecret: secret
+  defaulttree: Default
+  readtime: "25:00"
+  syncou: A
+  colour: red
+  filter:
+    unitcode:
+      - A
+      - A
+oneauth:
+  token: token
+  rootname: Root
+  upstream:
+    host: oneauth
+    port: "443"
+  retry:
+    attempts: many
+`
+	_, err := ParseConfig([]byte(data))
+	configErr, ok := err.(*ConfigError)
+	if !ok {
+		t.Fatal("expected config error, got: ", err)
+	}
+
+	expected := []ConfigProblem{
+		{Path: "system.fiber", Line: 2},
+		{Path: "system.log.level", Line: 4},
+		{Path: "database.host", Line: 6},
+		{Path: "database.port", Line: 7},
+		{Path: "database.readtime", Line: 12},
+		{Path: "database.syncou", Line: 13},
+		{Path: "database.colour", Line: 14},
+		{Path: "database.filter.unitcode[1]", Line: 18},
+		{Path: "oneauth.retry.attempts", Line: 26},
+	}
+	if len(configErr.Problems) != len(expected) {
+		t.Fatal("unexpected problems: ", err)
+	}
+	for i, problem := range configErr.Problems {
+		if problem.Path != expected[i].Path || problem.Line != expected[i].Line {
+			t.Errorf("problem %d: expected %s at line %d, got %s", i, expected[i].Path, expected[i].Line, problem)
+		}
+	}
+	if !strings.Contains(err.Error(), "unknown field colour") {
+		t.Error("unknown field should be reported, got: ", err)
+	}
+}
+
+// 任务中的类型错误不影响其他配置项的检查，路径使用任务的下标
+func TestParseConfigJobProblems(t *testing.T) {
+	data := `jobs:
+- name: a
+  database:
+    host: datapub
+    port: "443"
+    user: {appkey: key, appsecret: secret}
+    defaulttree: Default
+    schedule:
+      - every 1h
+      - every 10s
+  oneauth:
+    token: token
+    rootname: Root
+    upstream: {host: oneauth, port: "443"}
+- name: b
+  database:
+    host: datapub
+    port: "443"
+    user:
+      appkey: key
+      appsecret: secret
+    defaulttree: Default
+  oneauth:
+    token: token
+    rootname: Root
+    upstream:
+      host: oneauth
+      prt: "443"
+`
+	_, err := ParseConfig([]byte(data))
+	if err == nil {
+		t.Fatal("expected config error")
+	}
+
+	for _, expected := range []string{
+		"line 10: jobs[0].database.schedule[1]:",
+		"line 26: jobs[1].oneauth.upstream.port: must be set",
+		"line 28: jobs[1].oneauth.upstream.prt: unknown field prt",
+	} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("expected %q in: %v", expected, err)
+		}
+	}
+}
+
+func TestIndexYamlLines(t *testing.T) {
+	data := `# comment
+system:
+  log: {level: "4"}
+  snapshot:
+    path: "a: b"
+jobs:
+- name: a
+  database:
+    filter:
+      unitcode:
+        - X
+        - Y
+-   name: b
+    oneauth:
+      note: |
+        host: ignored
+      rootname: R
+`
+	lines, _ := IndexYamlLines([]byte(data))
+	expected := map[string]int{
+		"system":                              2,
+		"system.log":                          3,
+		"system.snapshot.path":                5,
+		"jobs[0]":                             7,
+		"jobs[0].name":                        7,
+		"jobs[0].database.filter.unitcode[1]": 12,
+		"jobs[1].name":                        13,
+		"jobs[1].oneauth.rootname":            17,
+	}
+	for path, line := range expected {
+		if lines[path] != line {
+			t.Errorf("%s: expected line %d, got %d", path, line, lines[path])
+		}
+	}
+	if _, ok := lines["jobs[1].oneauth.note.host"]; ok {
+		t.Error("block scalar content should not be indexed")
+	}
+}
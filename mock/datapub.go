@@ -0,0 +1,188 @@
+// Package mock 提供主数据接口、oneauth以及通知接收端的本地模拟服务，用于端到端测试
+package mock
+
+import (
+	"crypto/md5"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 主数据组织架构
+type Org struct {
+	OrgUnitCode      string `json:"orgUnitCode"`
+	OrgUnitName      string `json:"orgUnitName"`
+	Status           string `json:"status"`
+	UpperOrgUnitCode string `json:"upperOrgUnitCode"`
+	UpperOrgUnitName string `json:"upperOrgUnitName"`
+	LeaderCode       string `json:"leaderCode"`
+	LeaderName       string `json:"leaderName"`
+	UpdateDate       string `json:"updateDate"`
+}
+
+// 主数据人员
+type Emp struct {
+	UserCode   string `json:"userCode"`
+	UserName   string `json:"userName"`
+	Email      string `json:"email"`
+	Status     string `json:"status"`
+	OAID       string `json:"OAID"`
+	BsId       string `json:"bsId"`
+	Version    string `json:"version"`
+	UpdateDate string `json:"updateDate"`
+	OrgCode    string `json:"orgCode"`
+	OrgName    string `json:"orgName"`
+}
+
+// 主数据接口路径
+const (
+	DatapubOrgPath = "/api/service/datapub/rest/api/v1/org/queryDlpOrg"
+	DatapubEmpPath = "/api/service/datapub/rest/api/v1/emp/queryDlpEmp"
+)
+
+// 模拟主数据接口，校验appKey和sign，返回当前设置的组织和人员数据
+type Datapub struct {
+	Server    *httptest.Server
+	AppKey    string
+	AppSecret string
+	// 增量参数名，请求带该参数时只返回updateDate不早于参数值的数据
+	UpdateParam string
+
+	mu       sync.Mutex
+	orgs     []Org
+	emps     []Emp
+	requests []string
+	fail     int
+}
+
+// 启动https模拟服务，与agent访问主数据的方式一致
+func NewDatapub(appKey, appSecret string) *Datapub {
+	d := &Datapub{AppKey: appKey, AppSecret: appSecret}
+	d.Server = httptest.NewTLSServer(http.HandlerFunc(d.serveHTTP))
+	return d
+}
+
+func (d *Datapub) Close() {
+	d.Server.Close()
+}
+
+// 服务监听的主机和端口
+func (d *Datapub) HostPort() (string, string) {
+	return splitHostPort(d.Server.URL)
+}
+
+// 替换组织架构数据
+func (d *Datapub) SetOrgs(orgs []Org) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.orgs = append([]Org(nil), orgs...)
+}
+
+// 替换人员数据
+func (d *Datapub) SetEmps(emps []Emp) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.emps = append([]Emp(nil), emps...)
+}
+
+// 之后的n次请求返回500
+func (d *Datapub) FailNext(n int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.fail = n
+}
+
+// 已收到的请求，格式为path?query
+func (d *Datapub) Requests() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]string(nil), d.requests...)
+}
+
+// 计算签名，与主数据平台的规则一致：参数按key排序拼接后加上secret做md5
+func DatapubSign(appSecret string, params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+params[key])
+	}
+
+	return fmt.Sprintf("%x", md5.Sum([]byte(strings.Join(pairs, "&")+appSecret)))
+}
+
+func (d *Datapub) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.requests = append(d.requests, r.URL.RequestURI())
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if d.fail > 0 {
+		d.fail--
+		http.Error(w, "injected failure", http.StatusInternalServerError)
+		return
+	}
+
+	query := r.URL.Query()
+	appKey := r.Header.Get("appKey")
+	sign := DatapubSign(d.AppSecret, map[string]string{"appKey": d.AppKey, "bsId": query.Get("bsId")})
+	if appKey != d.AppKey || r.Header.Get("sign") != sign {
+		writeJson(w, http.StatusUnauthorized, map[string]interface{}{"code": "401", "message": "sign check failed", "data": nil})
+		return
+	}
+
+	since := ""
+	if len(d.UpdateParam) > 0 {
+		since = query.Get(d.UpdateParam)
+	}
+
+	switch r.URL.Path {
+	case DatapubOrgPath:
+		orgs := make([]Org, 0, len(d.orgs))
+		for _, org := range d.orgs {
+			if len(since) == 0 || org.UpdateDate >= since {
+				orgs = append(orgs, org)
+			}
+		}
+		writeJson(w, http.StatusOK, map[string]interface{}{"code": "200", "message": "success", "data": orgs})
+	case DatapubEmpPath:
+		emps := make([]Emp, 0, len(d.emps))
+		for _, emp := range d.emps {
+			if len(since) == 0 || emp.UpdateDate >= since {
+				emps = append(emps, emp)
+			}
+		}
+		writeJson(w, http.StatusOK, map[string]interface{}{"code": "200", "message": "success", "data": emps})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func writeJson(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func splitHostPort(rawUrl string) (string, string) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return "", ""
+	}
+
+	return u.Hostname(), u.Port()
+}
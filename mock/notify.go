@@ -0,0 +1,202 @@
+package mock
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// 收到的webhook请求
+type WebhookRequest struct {
+	Header http.Header
+	Body   []byte
+}
+
+// 模拟webhook接收端，记录收到的请求并返回Status
+type Webhook struct {
+	Server *httptest.Server
+	Status int // 响应码，默认200
+
+	mu       sync.Mutex
+	requests []WebhookRequest
+}
+
+func NewWebhook() *Webhook {
+	w := &Webhook{Status: http.StatusOK}
+	w.Server = httptest.NewServer(http.HandlerFunc(w.serveHTTP))
+	return w
+}
+
+func (w *Webhook) Close() {
+	w.Server.Close()
+}
+
+// webhook地址
+func (w *Webhook) Url() string {
+	return w.Server.URL + "/hook"
+}
+
+// 已收到的请求
+func (w *Webhook) Requests() []WebhookRequest {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]WebhookRequest(nil), w.requests...)
+}
+
+func (w *Webhook) serveHTTP(rw http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	w.mu.Lock()
+	w.requests = append(w.requests, WebhookRequest{Header: r.Header.Clone(), Body: body})
+	status := w.Status
+	w.mu.Unlock()
+
+	rw.WriteHeader(status)
+}
+
+// 收到的邮件
+type Mail struct {
+	From string
+	To   []string
+	Data string // 包含邮件头的原始内容
+}
+
+// 模拟smtp服务器，只支持发送邮件需要的命令，不支持STARTTLS和认证
+type Smtp struct {
+	listener net.Listener
+
+	mu    sync.Mutex
+	mails []Mail
+	wg    sync.WaitGroup
+}
+
+func NewSmtp() *Smtp {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+
+	s := &Smtp{listener: listener}
+	s.wg.Add(1)
+	go s.serve()
+	return s
+}
+
+func (s *Smtp) Close() {
+	s.listener.Close()
+	s.wg.Wait()
+}
+
+// 服务监听的主机和端口
+func (s *Smtp) HostPort() (string, string) {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return host, port
+}
+
+// 已收到的邮件
+func (s *Smtp) Mails() []Mail {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Mail(nil), s.mails...)
+}
+
+func (s *Smtp) serve() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+func (s *Smtp) serveConn(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+
+	reply("220 mock smtp ready")
+	var mail Mail
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command := strings.ToUpper(line)
+
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 mock")
+		case strings.HasPrefix(command, "MAIL FROM:"):
+			from, ok := envelopeAddress(line[len("MAIL FROM:"):])
+			if !ok {
+				reply("501 syntax error in address")
+				continue
+			}
+			mail = Mail{From: from}
+			reply("250 ok")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			to, ok := envelopeAddress(line[len("RCPT TO:"):])
+			if !ok {
+				reply("501 syntax error in address")
+				continue
+			}
+			mail.To = append(mail.To, to)
+			reply("250 ok")
+		case command == "DATA":
+			reply("354 end data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				// 去掉行首用于转义的点
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			mail.Data = data.String()
+
+			s.mu.Lock()
+			s.mails = append(s.mails, mail)
+			s.mu.Unlock()
+			reply("250 ok")
+		case command == "RSET", command == "NOOP":
+			reply("250 ok")
+		case command == "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 command not implemented")
+		}
+	}
+}
+
+// 解析<addr>形式的地址，和真实服务器一样不接受带显示名的地址
+func envelopeAddress(arg string) (string, bool) {
+	arg = strings.TrimSpace(arg)
+	if len(arg) < 2 || arg[0] != '<' || arg[len(arg)-1] != '>' {
+		return "", false
+	}
+
+	address := arg[1 : len(arg)-1]
+	if strings.ContainsAny(address, "<> ") {
+		return "", false
+	}
+	return address, true
+}
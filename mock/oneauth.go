@@ -0,0 +1,665 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// oneauth根节点
+type OneauthOrg struct {
+	OrgId    string
+	Name     string
+	OriginId string
+}
+
+// oneauth部门
+type OneauthDepartment struct {
+	DepId     string
+	OrgId     string
+	ParentId  string // 顶层部门的上级为根节点id
+	Name      string
+	OriginId  string
+	ManagerId string
+}
+
+// oneauth人员
+type OneauthUser struct {
+	UserId      string
+	Account     string
+	DisplayName string
+	Email       string
+	EmployeeId  string
+	OrgId       string
+	DepId       string
+	ManagerId   string
+	Status      int
+}
+
+// 注入的请求失败，status为0时只延迟响应
+type oneauthFault struct {
+	method string
+	prefix string
+	status int
+	delay  time.Duration
+	count  int
+}
+
+// 有状态的oneauth模拟服务，实现agent用到的根节点、部门树、人员查询以及增删改移接口
+type Oneauth struct {
+	Server *httptest.Server
+	Token  string
+
+	mu       sync.Mutex
+	nextId   int
+	orgs     map[string]*OneauthOrg
+	deps     map[string]*OneauthDepartment
+	users    map[string]*OneauthUser
+	faults   []*oneauthFault
+	delays   []*oneauthFault
+	requests []string
+}
+
+// 启动http模拟服务，token为空时不校验Authorization
+func NewOneauth(token string) *Oneauth {
+	o := &Oneauth{
+		Token: token,
+		orgs:  make(map[string]*OneauthOrg),
+		deps:  make(map[string]*OneauthDepartment),
+		users: make(map[string]*OneauthUser),
+	}
+	o.Server = httptest.NewServer(http.HandlerFunc(o.serveHTTP))
+	return o
+}
+
+func (o *Oneauth) Close() {
+	o.Server.Close()
+}
+
+// 服务监听的主机和端口
+func (o *Oneauth) HostPort() (string, string) {
+	return splitHostPort(o.Server.URL)
+}
+
+// 之后count次匹配method和路径前缀的请求返回status
+func (o *Oneauth) FailNext(method, prefix string, status, count int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.faults = append(o.faults, &oneauthFault{method: method, prefix: prefix, status: status, count: count})
+}
+
+// 之后count次匹配method和路径前缀的请求延迟delay后再处理，客户端在此期间取消时不处理请求
+func (o *Oneauth) DelayNext(method, prefix string, delay time.Duration, count int) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.delays = append(o.delays, &oneauthFault{method: method, prefix: prefix, delay: delay, count: count})
+}
+
+// 已收到的请求，格式为METHOD path
+func (o *Oneauth) Requests() []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]string(nil), o.requests...)
+}
+
+// 已收到的写请求，用于判断同步是否产生了变更
+func (o *Oneauth) Writes() []string {
+	var writes []string
+	for _, req := range o.Requests() {
+		if !strings.HasPrefix(req, http.MethodGet+" ") {
+			writes = append(writes, req)
+		}
+	}
+	return writes
+}
+
+// 清空请求记录
+func (o *Oneauth) ResetRequests() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.requests = nil
+}
+
+// 直接创建根节点，用于准备测试数据
+func (o *Oneauth) AddOrg(name, originId string) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.addOrg(name, originId).OrgId
+}
+
+// 直接创建部门，parentId为空时为顶层部门
+func (o *Oneauth) AddDepartment(orgId, parentId, name, originId string) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(parentId) == 0 {
+		parentId = orgId
+	}
+	return o.addDepartment(orgId, parentId, name, originId).DepId
+}
+
+// 直接创建人员
+func (o *Oneauth) AddUser(user OneauthUser) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	user.UserId = o.newId("u")
+	o.users[user.UserId] = &user
+	return user.UserId
+}
+
+// 所有根节点，按originId排序
+func (o *Oneauth) Orgs() []OneauthOrg {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	orgs := make([]OneauthOrg, 0, len(o.orgs))
+	for _, org := range o.orgs {
+		orgs = append(orgs, *org)
+	}
+	sort.Slice(orgs, func(i, j int) bool { return orgs[i].OriginId < orgs[j].OriginId })
+	return orgs
+}
+
+// 所有部门，按originId排序
+func (o *Oneauth) Departments() []OneauthDepartment {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	deps := make([]OneauthDepartment, 0, len(o.deps))
+	for _, dep := range o.deps {
+		deps = append(deps, *dep)
+	}
+	sort.Slice(deps, func(i, j int) bool { return deps[i].OriginId < deps[j].OriginId })
+	return deps
+}
+
+// 所有人员，按工号排序
+func (o *Oneauth) Users() []OneauthUser {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	users := make([]OneauthUser, 0, len(o.users))
+	for _, user := range o.users {
+		users = append(users, *user)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].EmployeeId < users[j].EmployeeId })
+	return users
+}
+
+// 部门或根节点的originId，不存在时为空
+func (o *Oneauth) OriginId(id string) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if dep, ok := o.deps[id]; ok {
+		return dep.OriginId
+	}
+	if org, ok := o.orgs[id]; ok {
+		return org.OriginId
+	}
+	return ""
+}
+
+// 人员工号，不存在时为空
+func (o *Oneauth) EmployeeId(userId string) string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if user, ok := o.users[userId]; ok {
+		return user.EmployeeId
+	}
+	return ""
+}
+
+func (o *Oneauth) newId(prefix string) string {
+	o.nextId++
+	return prefix + strconv.Itoa(o.nextId)
+}
+
+func (o *Oneauth) addOrg(name, originId string) *OneauthOrg {
+	org := &OneauthOrg{OrgId: o.newId("o"), Name: name, OriginId: originId}
+	o.orgs[org.OrgId] = org
+	return org
+}
+
+func (o *Oneauth) addDepartment(orgId, parentId, name, originId string) *OneauthDepartment {
+	dep := &OneauthDepartment{DepId: o.newId("d"), OrgId: orgId, ParentId: parentId, Name: name, OriginId: originId}
+	o.deps[dep.DepId] = dep
+	return dep
+}
+
+// 部门或根节点是否存在于指定根节点下
+func (o *Oneauth) parentExists(orgId, parentId string) bool {
+	if parentId == orgId {
+		_, ok := o.orgs[orgId]
+		return ok
+	}
+
+	dep, ok := o.deps[parentId]
+	return ok && dep.OrgId == orgId
+}
+
+// 查找并消耗一次匹配请求的注入
+func matchFault(faults *[]*oneauthFault, r *http.Request) *oneauthFault {
+	for i, f := range *faults {
+		if f.method == r.Method && strings.HasPrefix(r.URL.Path, f.prefix) {
+			f.count--
+			if f.count <= 0 {
+				*faults = append((*faults)[:i], (*faults)[i+1:]...)
+			}
+			return f
+		}
+	}
+	return nil
+}
+
+func (o *Oneauth) fault(r *http.Request) int {
+	if f := matchFault(&o.faults, r); f != nil {
+		return f.status
+	}
+	return 0
+}
+
+func (o *Oneauth) delay(r *http.Request) time.Duration {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if f := matchFault(&o.delays, r); f != nil {
+		return f.delay
+	}
+	return 0
+}
+
+func oneauthError(w http.ResponseWriter, code int, msg string) {
+	writeJson(w, code, map[string]interface{}{"success": false, "message": msg})
+}
+
+func (o *Oneauth) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	// 延迟期间不持有锁，不影响其他请求
+	if delay := o.delay(r); delay > 0 {
+		// 读完请求体后服务端才能感知客户端断开连接
+		body, _ := ioutil.ReadAll(r.Body)
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.requests = append(o.requests, r.Method+" "+r.URL.Path)
+
+	if len(o.Token) > 0 && r.Header.Get("Authorization") != o.Token {
+		oneauthError(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+
+	if status := o.fault(r); status != 0 {
+		oneauthError(w, status, "injected failure")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/v1/account"), "/"), "/")
+	switch {
+	case parts[0] == "org":
+		o.serveOrg(w, r, parts[1:])
+	case parts[0] == "user":
+		o.serveUser(w, r, parts[1:])
+	default:
+		oneauthError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// /org 相关接口
+func (o *Oneauth) serveOrg(w http.ResponseWriter, r *http.Request, parts []string) {
+	query := r.URL.Query()
+
+	switch {
+	// GET /org 获取根节点
+	case len(parts) == 0 && r.Method == http.MethodGet:
+		var roots []map[string]string
+		for _, org := range o.orgs {
+			roots = append(roots, map[string]string{"orgId": org.OrgId, "name": org.Name, "originId": org.OriginId})
+		}
+		sort.Slice(roots, func(i, j int) bool { return roots[i]["orgId"] < roots[j]["orgId"] })
+		writeJson(w, http.StatusOK, map[string]interface{}{"count": len(roots), "organizations": roots})
+
+	// POST /org 创建根节点
+	case len(parts) == 0 && r.Method == http.MethodPost:
+		originId := query.Get("originId")
+		for _, org := range o.orgs {
+			if len(originId) > 0 && org.OriginId == originId {
+				oneauthError(w, http.StatusConflict, "organization already exists")
+				return
+			}
+		}
+		org := o.addOrg(query.Get("orgName"), originId)
+		writeJson(w, http.StatusOK, map[string]interface{}{"orgId": org.OrgId, "success": true})
+
+	// PUT /org/{orgId} 更新根节点
+	case len(parts) == 1 && r.Method == http.MethodPut:
+		org, ok := o.orgs[parts[0]]
+		if !ok {
+			oneauthError(w, http.StatusNotFound, "organization not found")
+			return
+		}
+		org.Name = query.Get("name")
+		writeJson(w, http.StatusOK, map[string]interface{}{"success": true})
+
+	// GET /org/{orgId}/tree 获取部门树
+	case len(parts) == 2 && parts[1] == "tree" && r.Method == http.MethodGet:
+		if _, ok := o.orgs[parts[0]]; !ok {
+			oneauthError(w, http.StatusNotFound, "organization not found")
+			return
+		}
+		tree := []map[string]string{}
+		for _, dep := range o.deps {
+			if dep.OrgId == parts[0] {
+				tree = append(tree, map[string]string{"parentId": dep.ParentId, "name": dep.Name, "DepId": dep.DepId,
+					"originId": dep.OriginId, "managerId": dep.ManagerId})
+			}
+		}
+		sort.Slice(tree, func(i, j int) bool { return tree[i]["DepId"] < tree[j]["DepId"] })
+		writeJson(w, http.StatusOK, map[string]interface{}{"LevelCount": len(tree), "treeStruct": tree})
+
+	// POST /org/{orgId}/department 创建部门
+	case len(parts) == 2 && parts[1] == "department" && r.Method == http.MethodPost:
+		orgId := parts[0]
+		parentId := query.Get("parentId")
+		if len(parentId) == 0 {
+			parentId = orgId
+		}
+		if !o.parentExists(orgId, parentId) {
+			oneauthError(w, http.StatusNotFound, "parent not found")
+			return
+		}
+		originId := query.Get("originId")
+		for _, dep := range o.deps {
+			if len(originId) > 0 && dep.OrgId == orgId && dep.OriginId == originId {
+				oneauthError(w, http.StatusConflict, "department already exists")
+				return
+			}
+		}
+		dep := o.addDepartment(orgId, parentId, query.Get("department"), originId)
+		writeJson(w, http.StatusOK, map[string]interface{}{"depId": dep.DepId, "success": true})
+
+	// PUT/DELETE /org/{orgId}/department/{depId}[/shift/{parentId}|/manager]
+	case len(parts) >= 3 && parts[1] == "department":
+		dep, ok := o.deps[parts[2]]
+		if !ok || dep.OrgId != parts[0] {
+			oneauthError(w, http.StatusNotFound, "department not found")
+			return
+		}
+		o.serveDepartment(w, r, dep, parts[3:])
+
+	default:
+		oneauthError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// 单个部门的更新、移动、删除和设置主管
+func (o *Oneauth) serveDepartment(w http.ResponseWriter, r *http.Request, dep *OneauthDepartment, parts []string) {
+	switch {
+	case len(parts) == 0 && r.Method == http.MethodPut:
+		var body struct {
+			Name string `json:"name"`
+		}
+		if err := readJson(r, &body); err != nil {
+			oneauthError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		dep.Name = body.Name
+
+	case len(parts) == 0 && r.Method == http.MethodDelete:
+		// 有下级部门时不能删除，校验同步时先删除子部门
+		for _, child := range o.deps {
+			if child.ParentId == dep.DepId {
+				oneauthError(w, http.StatusBadRequest, "department has sub departments")
+				return
+			}
+		}
+		delete(o.deps, dep.DepId)
+
+	case len(parts) == 2 && parts[0] == "shift" && r.Method == http.MethodPut:
+		parentId := parts[1]
+		if !o.parentExists(dep.OrgId, parentId) {
+			oneauthError(w, http.StatusNotFound, "parent not found")
+			return
+		}
+		// 不能移动到自己或下级部门下
+		for id := parentId; id != dep.OrgId; id = o.deps[id].ParentId {
+			if id == dep.DepId {
+				oneauthError(w, http.StatusBadRequest, "can not move department under itself")
+				return
+			}
+		}
+		dep.ParentId = parentId
+
+	case len(parts) == 1 && parts[0] == "manager" && r.Method == http.MethodPut:
+		var body struct {
+			UserId []string `json:"userId"`
+		}
+		if err := readJson(r, &body); err != nil {
+			oneauthError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		dep.ManagerId = ""
+		if len(body.UserId) > 0 {
+			if _, ok := o.users[body.UserId[0]]; !ok {
+				oneauthError(w, http.StatusNotFound, "user not found")
+				return
+			}
+			dep.ManagerId = body.UserId[0]
+		}
+
+	default:
+		oneauthError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	writeJson(w, http.StatusOK, map[string]interface{}{"success": true})
+}
+
+// 人员属性，创建和更新接口共用
+type oneauthUserProps struct {
+	Account      *string  `json:"account"`
+	DisplayName  *string  `json:"displayName"`
+	Email        *string  `json:"email"`
+	EmployeeId   *string  `json:"employeeId"`
+	OrgId        *string  `json:"orgId"`
+	DepartmentId []string `json:"departmentId"`
+	ManagerId    *string  `json:"managerId"`
+}
+
+// 更新人员属性，只修改请求中出现的字段
+func (o *Oneauth) applyUserProps(user *OneauthUser, props oneauthUserProps) error {
+	if props.OrgId != nil || props.DepartmentId != nil {
+		orgId := user.OrgId
+		if props.OrgId != nil {
+			orgId = *props.OrgId
+		}
+		depId := orgId
+		if len(props.DepartmentId) > 0 && len(props.DepartmentId[0]) > 0 {
+			depId = props.DepartmentId[0]
+		}
+		if !o.parentExists(orgId, depId) {
+			return fmt.Errorf("department %s not found in org %s", depId, orgId)
+		}
+		user.OrgId = orgId
+		user.DepId = ""
+		if depId != orgId {
+			user.DepId = depId
+		}
+	}
+
+	if props.ManagerId != nil {
+		if _, ok := o.users[*props.ManagerId]; len(*props.ManagerId) > 0 && !ok {
+			return fmt.Errorf("manager %s not found", *props.ManagerId)
+		}
+		user.ManagerId = *props.ManagerId
+	}
+
+	for _, field := range []struct {
+		dst *string
+		src *string
+	}{
+		{&user.Account, props.Account},
+		{&user.DisplayName, props.DisplayName},
+		{&user.Email, props.Email},
+		{&user.EmployeeId, props.EmployeeId},
+	} {
+		if field.src != nil {
+			*field.dst = *field.src
+		}
+	}
+
+	return nil
+}
+
+// /user 相关接口
+func (o *Oneauth) serveUser(w http.ResponseWriter, r *http.Request, parts []string) {
+	switch {
+	// GET /user 分页获取人员
+	case len(parts) == 0 && r.Method == http.MethodGet:
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		if page <= 0 {
+			page = 1
+		}
+		if limit <= 0 {
+			limit = 100
+		}
+
+		ids := make([]string, 0, len(o.users))
+		for id := range o.users {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+
+		users := []map[string]interface{}{}
+		for i := (page - 1) * limit; i < len(ids) && i < page*limit; i++ {
+			user := o.users[ids[i]]
+			department := map[string]interface{}{"orgId": user.OrgId}
+			if len(user.DepId) > 0 {
+				department["depId"] = []string{user.DepId}
+			}
+			users = append(users, map[string]interface{}{
+				"email":       user.Email,
+				"account":     user.Account,
+				"employeeId":  user.EmployeeId,
+				"displayName": user.DisplayName,
+				"userId":      user.UserId,
+				"status":      user.Status,
+				"managerId":   user.ManagerId,
+				"department":  []interface{}{department},
+			})
+		}
+		writeJson(w, http.StatusOK, map[string]interface{}{"count": len(ids), "users": users})
+
+	// POST /user 创建人员
+	case len(parts) == 0 && r.Method == http.MethodPost:
+		var props oneauthUserProps
+		if err := readJson(r, &props); err != nil {
+			oneauthError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if props.Account == nil || len(*props.Account) == 0 {
+			oneauthError(w, http.StatusBadRequest, "account is required")
+			return
+		}
+		for _, user := range o.users {
+			if user.Account == *props.Account {
+				oneauthError(w, http.StatusConflict, "account already exists")
+				return
+			}
+		}
+		user := &OneauthUser{UserId: o.newId("u"), Status: 1}
+		if err := o.applyUserProps(user, props); err != nil {
+			oneauthError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		o.users[user.UserId] = user
+		writeJson(w, http.StatusOK, map[string]interface{}{"userId": user.UserId, "success": true})
+
+	case len(parts) >= 1:
+		user, ok := o.users[parts[0]]
+		if !ok {
+			oneauthError(w, http.StatusNotFound, "user not found")
+			return
+		}
+		o.serveSingleUser(w, r, user, parts[1:])
+
+	default:
+		oneauthError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// 单个人员的更新、移动和删除
+func (o *Oneauth) serveSingleUser(w http.ResponseWriter, r *http.Request, user *OneauthUser, parts []string) {
+	switch {
+	// PUT /user/{id} 更新人员
+	case len(parts) == 0 && r.Method == http.MethodPut:
+		var body struct {
+			Propval oneauthUserProps `json:"propval"`
+		}
+		if err := readJson(r, &body); err != nil {
+			oneauthError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if body.Propval.Account != nil {
+			for _, other := range o.users {
+				if other != user && other.Account == *body.Propval.Account {
+					oneauthError(w, http.StatusConflict, "account already exists")
+					return
+				}
+			}
+		}
+		// 先在副本上修改，失败时不改变原数据
+		updated := *user
+		if err := o.applyUserProps(&updated, body.Propval); err != nil {
+			oneauthError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		*user = updated
+
+	// PUT /user/{id}/org/{orgId}/department/{depId} 移动人员
+	case len(parts) == 4 && parts[0] == "org" && parts[2] == "department" && r.Method == http.MethodPut:
+		if !o.parentExists(parts[1], parts[3]) {
+			oneauthError(w, http.StatusNotFound, "department not found")
+			return
+		}
+		user.OrgId = parts[1]
+		user.DepId = ""
+		if parts[3] != parts[1] {
+			user.DepId = parts[3]
+		}
+
+	// PUT /user/{id}/lifecycle/remove 删除人员
+	case len(parts) == 2 && parts[0] == "lifecycle" && parts[1] == "remove" && r.Method == http.MethodPut:
+		delete(o.users, user.UserId)
+
+	default:
+		oneauthError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	writeJson(w, http.StatusOK, map[string]interface{}{"success": true})
+}
+
+func readJson(r *http.Request, v interface{}) error {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, v)
+}